@@ -0,0 +1,186 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// AICallLogEntry representa una llamada a un proveedor de IA registrada en ai_call_log
+type AICallLogEntry struct {
+	ConfigID      int
+	Provider      string
+	Model         string
+	Usage         TokenUsage
+	Latency       time.Duration
+	EstimatedCost float64 // USD, según los precios de ai_models al momento de la llamada
+	Success       bool
+	ErrorMessage  string
+}
+
+// EstimateCost calcula el costo estimado (USD) de una llamada con los precios por millón de tokens del modelo
+func EstimateCost(config *AIConfigDB, usage TokenUsage) float64 {
+	return (float64(usage.PromptTokens)*config.InputPricePerMTok +
+		float64(usage.CompletionTokens)*config.OutputPricePerMTok) / 1_000_000
+}
+
+// LogCall guarda una llamada en ai_call_log
+func (m *AIConfigManager) LogCall(entry AICallLogEntry) error {
+	var errorMessage sql.NullString
+	if entry.ErrorMessage != "" {
+		errorMessage = sql.NullString{String: entry.ErrorMessage, Valid: true}
+	}
+
+	_, err := m.db.Exec(`
+		INSERT INTO ai_call_log
+		(config_id, provider, model, prompt_tokens, completion_tokens, latency_ms, estimated_cost, success, error_message)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		entry.ConfigID,
+		entry.Provider,
+		entry.Model,
+		entry.Usage.PromptTokens,
+		entry.Usage.CompletionTokens,
+		entry.Latency.Milliseconds(),
+		entry.EstimatedCost,
+		entry.Success,
+		errorMessage,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert ai_call_log: %v", err)
+	}
+
+	return nil
+}
+
+// DailyCost es el costo de IA de un día junto con las cargas creadas ese día
+type DailyCost struct {
+	Date             string  `json:"date"`
+	Calls            int     `json:"calls"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	Cost             float64 `json:"cost"`
+	Cargas           int     `json:"cargas"`
+	CostPerCarga     float64 `json:"cost_per_carga"`
+}
+
+// ProviderCost es el costo de IA acumulado por proveedor y modelo
+type ProviderCost struct {
+	Provider         string  `json:"provider"`
+	Model            string  `json:"model"`
+	Calls            int     `json:"calls"`
+	FailedCalls      int     `json:"failed_calls"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	AvgLatencyMs     int     `json:"avg_latency_ms"`
+	Cost             float64 `json:"cost"`
+}
+
+// getCostStats calcula costos por día (con costo por carga) y por proveedor/modelo de los últimos days días
+func getCostStats(db *sql.DB, days int) (map[string]interface{}, error) {
+	stats := make(map[string]interface{})
+
+	// Costo y tokens por día
+	rows, err := db.Query(`
+		SELECT DATE_FORMAT(created_at, '%Y-%m-%d') as day, COUNT(*),
+		       COALESCE(SUM(prompt_tokens), 0), COALESCE(SUM(completion_tokens), 0), COALESCE(SUM(estimated_cost), 0)
+		FROM ai_call_log
+		WHERE created_at >= CURDATE() - INTERVAL ? DAY
+		GROUP BY day
+		ORDER BY day DESC
+	`, days-1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var byDay []DailyCost
+	dayIndex := make(map[string]int)
+	for rows.Next() {
+		var d DailyCost
+		if err := rows.Scan(&d.Date, &d.Calls, &d.PromptTokens, &d.CompletionTokens, &d.Cost); err != nil {
+			return nil, err
+		}
+		dayIndex[d.Date] = len(byDay)
+		byDay = append(byDay, d)
+	}
+
+	// Cargas creadas por día (para el costo por carga)
+	cargaRows, err := db.Query(`
+		SELECT DATE_FORMAT(processed_at, '%Y-%m-%d') as day, COALESCE(SUM(JSON_LENGTH(supabase_ids)), 0)
+		FROM ai_processing_results
+		WHERE processed_at >= CURDATE() - INTERVAL ? DAY
+		AND status = 'success'
+		AND supabase_ids IS NOT NULL
+		AND supabase_ids != '[]'
+		GROUP BY day
+	`, days-1)
+	if err != nil {
+		return nil, err
+	}
+	defer cargaRows.Close()
+
+	for cargaRows.Next() {
+		var day string
+		var cargas int
+		if err := cargaRows.Scan(&day, &cargas); err != nil {
+			return nil, err
+		}
+		if i, ok := dayIndex[day]; ok {
+			byDay[i].Cargas = cargas
+			if cargas > 0 {
+				byDay[i].CostPerCarga = byDay[i].Cost / float64(cargas)
+			}
+		}
+	}
+
+	today := time.Now().Format("2006-01-02")
+	stats["cost_today"] = 0.0
+	stats["cost_per_carga_today"] = 0.0
+	if i, ok := dayIndex[today]; ok {
+		stats["cost_today"] = byDay[i].Cost
+		stats["cost_per_carga_today"] = byDay[i].CostPerCarga
+	}
+
+	var totalCost float64
+	var totalCargas int
+	for _, d := range byDay {
+		totalCost += d.Cost
+		totalCargas += d.Cargas
+	}
+	stats["cost_period_days"] = days
+	stats["cost_total"] = totalCost
+	stats["cost_per_carga"] = 0.0
+	if totalCargas > 0 {
+		stats["cost_per_carga"] = totalCost / float64(totalCargas)
+	}
+	stats["cost_by_day"] = byDay
+
+	// Costo por proveedor y modelo
+	providerRows, err := db.Query(`
+		SELECT provider, model, COUNT(*), COALESCE(SUM(CASE WHEN success = 0 THEN 1 ELSE 0 END), 0),
+		       COALESCE(SUM(prompt_tokens), 0), COALESCE(SUM(completion_tokens), 0),
+		       COALESCE(ROUND(AVG(latency_ms)), 0), COALESCE(SUM(estimated_cost), 0)
+		FROM ai_call_log
+		WHERE created_at >= CURDATE() - INTERVAL ? DAY
+		GROUP BY provider, model
+		ORDER BY SUM(estimated_cost) DESC, COUNT(*) DESC
+	`, days-1)
+	if err != nil {
+		return nil, err
+	}
+	defer providerRows.Close()
+
+	var byProvider []ProviderCost
+	for providerRows.Next() {
+		var pc ProviderCost
+		if err := providerRows.Scan(&pc.Provider, &pc.Model, &pc.Calls, &pc.FailedCalls,
+			&pc.PromptTokens, &pc.CompletionTokens, &pc.AvgLatencyMs, &pc.Cost); err != nil {
+			return nil, err
+		}
+		byProvider = append(byProvider, pc)
+	}
+	stats["cost_by_provider"] = byProvider
+
+	return stats, nil
+}
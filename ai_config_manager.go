@@ -3,6 +3,7 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 )
@@ -24,6 +25,7 @@ type AIProvider struct {
 	Name        string    `json:"name"`
 	DisplayName string    `json:"display_name"`
 	BaseURL     string    `json:"base_url"`
+	APIFormat   string    `json:"api_format"` // Formato de API: gemini, qwen, openai-compatible
 	IsEnabled   bool      `json:"is_enabled"`
 	Priority    int       `json:"priority"`
 	CreatedAt   time.Time `json:"created_at"`
@@ -56,15 +58,30 @@ type AIConfigDB struct {
 	LastError     string    `json:"last_error"`
 	LastUsedAt    *time.Time `json:"last_used_at"`
 	LastSuccessAt *time.Time `json:"last_success_at"`
+	CooldownUntil *time.Time `json:"cooldown_until"` // Pausada por rate limit hasta esta hora
+	
+	// Límites del plan de la key (0 = sin límite)
+	RPMLimit          int `json:"rpm_limit"`           // Requests por minuto
+	TPMLimit          int `json:"tpm_limit"`           // Tokens por minuto
+	DailyRequestLimit int `json:"daily_request_limit"` // Requests por día
+	RequestsToday     int `json:"requests_today"`      // Requests enviados hoy
+	MaxConcurrency    int `json:"max_concurrency"`     // Mensajes procesados en paralelo con esta key
 	CreatedAt     time.Time `json:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at"`
 	
 	// Campos adicionales para facilitar el uso en el frontend
-	ProviderName    string `json:"provider_name"`
-	ProviderDisplay string `json:"provider_display"`
-	ModelName       string `json:"model_name"`
-	ModelDisplay    string `json:"model_display"`
-	MaxTokens       int    `json:"max_tokens"`
+	ProviderName     string `json:"provider_name"`
+	ProviderDisplay  string `json:"provider_display"`
+	BaseURL          string `json:"base_url"`
+	APIFormat        string `json:"api_format"`
+	StructuredOutput bool   `json:"structured_output"` // El proveedor acepta un JSON Schema de respuesta
+	ModelName        string `json:"model_name"`
+	ModelDisplay     string `json:"model_display"`
+	MaxTokens        int    `json:"max_tokens"`
+	
+	// Precio del modelo en USD por millón de tokens (0 = gratis / desconocido)
+	InputPricePerMTok  float64 `json:"input_price_per_mtok"`
+	OutputPricePerMTok float64 `json:"output_price_per_mtok"`
 }
 
 // NewAIConfigManager crea una nueva instancia del manejador
@@ -81,7 +98,7 @@ func (m *AIConfigManager) GetAllProviders() ([]AIProvider, error) {
 	defer m.mu.RUnlock()
 	
 	rows, err := m.db.Query(`
-		SELECT id, name, display_name, base_url, is_enabled, priority, created_at, updated_at
+		SELECT id, name, display_name, base_url, api_format, is_enabled, priority, created_at, updated_at
 		FROM ai_providers
 		ORDER BY priority DESC, display_name ASC
 	`)
@@ -93,7 +110,7 @@ func (m *AIConfigManager) GetAllProviders() ([]AIProvider, error) {
 	var providers []AIProvider
 	for rows.Next() {
 		var p AIProvider
-		err := rows.Scan(&p.ID, &p.Name, &p.DisplayName, &p.BaseURL, &p.IsEnabled, &p.Priority, &p.CreatedAt, &p.UpdatedAt)
+		err := rows.Scan(&p.ID, &p.Name, &p.DisplayName, &p.BaseURL, &p.APIFormat, &p.IsEnabled, &p.Priority, &p.CreatedAt, &p.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -137,15 +154,7 @@ func (m *AIConfigManager) GetAllConfigs() ([]AIConfigDB, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	
-	rows, err := m.db.Query(`
-		SELECT 
-			c.id, c.provider_id, c.model_id, c.api_key, c.name, c.is_active, c.is_enabled,
-			c.error_count, c.last_error, c.last_used_at, c.last_success_at, c.created_at, c.updated_at,
-			p.name as provider_name, p.display_name as provider_display,
-			m.name as model_name, m.display_name as model_display, m.max_tokens
-		FROM ai_configs c
-		JOIN ai_providers p ON c.provider_id = p.id
-		JOIN ai_models m ON c.model_id = m.id
+	rows, err := m.db.Query(aiConfigSelectQuery + `
 		ORDER BY c.is_active DESC, c.created_at DESC
 	`)
 	if err != nil {
@@ -155,37 +164,34 @@ func (m *AIConfigManager) GetAllConfigs() ([]AIConfigDB, error) {
 	
 	var configs []AIConfigDB
 	for rows.Next() {
-		var c AIConfigDB
-		var lastUsedAt, lastSuccessAt sql.NullTime
-		var lastError sql.NullString
-		
-		err := rows.Scan(
-			&c.ID, &c.ProviderID, &c.ModelID, &c.APIKey, &c.Name, &c.IsActive, &c.IsEnabled,
-			&c.ErrorCount, &lastError, &lastUsedAt, &lastSuccessAt, &c.CreatedAt, &c.UpdatedAt,
-			&c.ProviderName, &c.ProviderDisplay, &c.ModelName, &c.ModelDisplay, &c.MaxTokens,
-		)
+		c, err := scanAIConfig(rows)
 		if err != nil {
 			return nil, err
 		}
-		
-		if lastError.Valid {
-			c.LastError = lastError.String
-		}
-		if lastUsedAt.Valid {
-			t := lastUsedAt.Time
-			c.LastUsedAt = &t
-		}
-		if lastSuccessAt.Valid {
-			t := lastSuccessAt.Time
-			c.LastSuccessAt = &t
-		}
-		
-		configs = append(configs, c)
+		configs = append(configs, *c)
 	}
 	
 	return configs, nil
 }
 
+// GetConfigByID obtiene una configuración específica (sin caché)
+func (m *AIConfigManager) GetConfigByID(id int) (*AIConfigDB, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	
+	c, err := scanAIConfig(m.db.QueryRow(aiConfigSelectQuery+`
+		WHERE c.id = ?
+	`, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("configuración %d no encontrada", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	
+	return c, nil
+}
+
 // GetActiveConfig obtiene la configuración actualmente activa (con caché para optimizar concurrencia)
 func (m *AIConfigManager) GetActiveConfig() (*AIConfigDB, error) {
 	// Paso 1: Intentar leer del caché (solo RLock, permite múltiples lecturas simultáneas)
@@ -225,30 +231,55 @@ func (m *AIConfigManager) GetActiveConfig() (*AIConfigDB, error) {
 // getActiveConfigFromDB lee la configuración activa desde la base de datos (función interna)
 // NOTA: Esta función debe llamarse solo cuando ya se tiene el Lock (m.mu.Lock)
 func (m *AIConfigManager) getActiveConfigFromDB() (*AIConfigDB, error) {
-	var c AIConfigDB
-	var lastUsedAt, lastSuccessAt sql.NullTime
-	var lastError sql.NullString
+	c, err := scanAIConfig(m.db.QueryRow(aiConfigSelectQuery + `
+		WHERE c.is_active = 1 AND c.is_enabled = 1 AND p.is_enabled = 1
+		LIMIT 1
+	`))
 	
-	err := m.db.QueryRow(`
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no active AI configuration found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	
+	return c, nil
+}
+
+// aiConfigSelectQuery es la base de todas las consultas que devuelven AIConfigDB (usar con scanAIConfig).
+// requests_today se devuelve en 0 si el contador es de un día anterior.
+const aiConfigSelectQuery = `
 		SELECT 
 			c.id, c.provider_id, c.model_id, c.api_key, c.name, c.is_active, c.is_enabled,
-			c.error_count, c.last_error, c.last_used_at, c.last_success_at, c.created_at, c.updated_at,
-			p.name as provider_name, p.display_name as provider_display,
-			m.name as model_name, m.display_name as model_display, m.max_tokens
+			c.error_count, c.last_error, c.last_used_at, c.last_success_at, c.cooldown_until, c.created_at, c.updated_at,
+			c.rpm_limit, c.tpm_limit, c.daily_request_limit, c.max_concurrency,
+			IF(c.requests_today_date = CURDATE(), c.requests_today, 0) as requests_today,
+			p.name as provider_name, p.display_name as provider_display, p.base_url, p.api_format, p.structured_output,
+			m.name as model_name, m.display_name as model_display, m.max_tokens,
+			m.input_price_per_mtok, m.output_price_per_mtok
 		FROM ai_configs c
 		JOIN ai_providers p ON c.provider_id = p.id
 		JOIN ai_models m ON c.model_id = m.id
-		WHERE c.is_active = 1 AND c.is_enabled = 1 AND p.is_enabled = 1
-		LIMIT 1
-	`).Scan(
+`
+
+// rowScanner es implementado por *sql.Row y *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAIConfig lee una fila de aiConfigSelectQuery
+func scanAIConfig(row rowScanner) (*AIConfigDB, error) {
+	var c AIConfigDB
+	var lastUsedAt, lastSuccessAt, cooldownUntil sql.NullTime
+	var lastError sql.NullString
+	
+	err := row.Scan(
 		&c.ID, &c.ProviderID, &c.ModelID, &c.APIKey, &c.Name, &c.IsActive, &c.IsEnabled,
-		&c.ErrorCount, &lastError, &lastUsedAt, &lastSuccessAt, &c.CreatedAt, &c.UpdatedAt,
-		&c.ProviderName, &c.ProviderDisplay, &c.ModelName, &c.ModelDisplay, &c.MaxTokens,
+		&c.ErrorCount, &lastError, &lastUsedAt, &lastSuccessAt, &cooldownUntil, &c.CreatedAt, &c.UpdatedAt,
+		&c.RPMLimit, &c.TPMLimit, &c.DailyRequestLimit, &c.MaxConcurrency, &c.RequestsToday,
+		&c.ProviderName, &c.ProviderDisplay, &c.BaseURL, &c.APIFormat, &c.StructuredOutput, &c.ModelName, &c.ModelDisplay, &c.MaxTokens,
+		&c.InputPricePerMTok, &c.OutputPricePerMTok,
 	)
-	
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("no active AI configuration found")
-	}
 	if err != nil {
 		return nil, err
 	}
@@ -264,6 +295,10 @@ func (m *AIConfigManager) getActiveConfigFromDB() (*AIConfigDB, error) {
 		t := lastSuccessAt.Time
 		c.LastSuccessAt = &t
 	}
+	if cooldownUntil.Valid {
+		t := cooldownUntil.Time
+		c.CooldownUntil = &t
+	}
 	
 	return &c, nil
 }
@@ -342,6 +377,27 @@ func (m *AIConfigManager) AddConfigWithCustomModel(providerID int, modelName, ap
 	return err
 }
 
+// AddProvider agrega un nuevo proveedor de IA (por ejemplo, uno OpenAI-compatible con su base_url)
+func (m *AIConfigManager) AddProvider(name, displayName, baseURL, apiFormat string, priority int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || displayName == "" {
+		return fmt.Errorf("provider name and display name are required")
+	}
+	if apiFormat == "" {
+		apiFormat = "openai-compatible"
+	}
+	
+	_, err := m.db.Exec(`
+		INSERT INTO ai_providers (name, display_name, base_url, api_format, priority, is_enabled)
+		VALUES (?, ?, ?, ?, ?, 1)
+	`, name, displayName, strings.TrimRight(strings.TrimSpace(baseURL), "/"), apiFormat, priority)
+	
+	return err
+}
+
 // UpdateConfig actualiza una configuración existente
 func (m *AIConfigManager) UpdateConfig(id int, apiKey, name string, isEnabled bool) error {
 	m.mu.Lock()
@@ -370,6 +426,12 @@ func (m *AIConfigManager) SetActiveConfig(id int) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	
+	return m.setActiveConfigLocked(id)
+}
+
+// setActiveConfigLocked activa una configuración
+// NOTA: Esta función debe llamarse solo cuando ya se tiene el Lock (m.mu.Lock)
+func (m *AIConfigManager) setActiveConfigLocked(id int) error {
 	tx, err := m.db.Begin()
 	if err != nil {
 		return err
@@ -395,7 +457,7 @@ func (m *AIConfigManager) SetActiveConfig(id int) error {
 	
 	// Invalidar caché para que se recargue en la próxima lectura
 	m.activeConfigCache = nil
-	fmt.Printf("🔄 Caché invalidado (SetActiveConfig)\n")
+	fmt.Printf("🔄 Caché invalidado (setActiveConfigLocked)\n")
 	
 	return nil
 }
@@ -405,6 +467,31 @@ func (m *AIConfigManager) RotateToNextConfig() (*AIConfigDB, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	
+	return m.rotateToNextConfigLocked()
+}
+
+// RotateFromConfig rota a la siguiente configuración solo si la activa sigue siendo failedID.
+// Con varios workers en paralelo, el primero que detecta el rate limit rota y los demás usan la nueva
+// config activa en lugar de volver a rotar (y saltearse una config que funciona).
+func (m *AIConfigManager) RotateFromConfig(failedID int) (*AIConfigDB, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	
+	current, err := m.getActiveConfigFromDB()
+	if err == nil && current != nil && current.ID != failedID &&
+		(current.CooldownUntil == nil || !time.Now().Before(*current.CooldownUntil)) {
+		fmt.Printf("🔀 La configuración ya fue rotada por otro worker: ID=%d, %s - %s (%s)\n",
+			current.ID, current.ProviderDisplay, current.ModelDisplay, current.Name)
+		m.activeConfigCache = current
+		m.cacheTime = time.Now()
+		return current, nil
+	}
+	
+	return m.rotateToNextConfigLocked()
+}
+
+// rotateToNextConfigLocked activa la siguiente configuración disponible (requiere m.mu tomado)
+func (m *AIConfigManager) rotateToNextConfigLocked() (*AIConfigDB, error) {
 	fmt.Printf("🔍 Buscando siguiente configuración disponible...\n")
 	
 	// Obtener la configuración actualmente activa
@@ -413,16 +500,9 @@ func (m *AIConfigManager) RotateToNextConfig() (*AIConfigDB, error) {
 	fmt.Printf("📌 Configuración actual ID: %d\n", currentID)
 	
 	// Obtener TODAS las configuraciones disponibles
-	rows, err := m.db.Query(`
-		SELECT 
-			c.id, c.provider_id, c.model_id, c.api_key, c.name, c.is_active, c.is_enabled,
-			c.error_count, c.last_error, c.last_used_at, c.last_success_at, c.created_at, c.updated_at,
-			p.name as provider_name, p.display_name as provider_display,
-			m.name as model_name, m.display_name as model_display, m.max_tokens
-		FROM ai_configs c
-		JOIN ai_providers p ON c.provider_id = p.id
-		JOIN ai_models m ON c.model_id = m.id
+	rows, err := m.db.Query(aiConfigSelectQuery + `
 		WHERE c.is_enabled = 1 AND p.is_enabled = 1
+		  AND (c.cooldown_until IS NULL OR c.cooldown_until <= NOW())
 		ORDER BY 
 			p.priority DESC,
 			c.error_count ASC,
@@ -438,32 +518,11 @@ func (m *AIConfigManager) RotateToNextConfig() (*AIConfigDB, error) {
 	
 	var configs []AIConfigDB
 	for rows.Next() {
-		var c AIConfigDB
-		var lastUsedAt, lastSuccessAt sql.NullTime
-		var lastError sql.NullString
-		
-		err := rows.Scan(
-			&c.ID, &c.ProviderID, &c.ModelID, &c.APIKey, &c.Name, &c.IsActive, &c.IsEnabled,
-			&c.ErrorCount, &lastError, &lastUsedAt, &lastSuccessAt, &c.CreatedAt, &c.UpdatedAt,
-			&c.ProviderName, &c.ProviderDisplay, &c.ModelName, &c.ModelDisplay, &c.MaxTokens,
-		)
+		c, err := scanAIConfig(rows)
 		if err != nil {
 			continue
 		}
-		
-		if lastError.Valid {
-			c.LastError = lastError.String
-		}
-		if lastUsedAt.Valid {
-			t := lastUsedAt.Time
-			c.LastUsedAt = &t
-		}
-		if lastSuccessAt.Valid {
-			t := lastSuccessAt.Time
-			c.LastSuccessAt = &t
-		}
-		
-		configs = append(configs, c)
+		configs = append(configs, *c)
 	}
 	
 	fmt.Printf("📋 Encontradas %d configuraciones disponibles:\n", len(configs))
@@ -477,7 +536,7 @@ func (m *AIConfigManager) RotateToNextConfig() (*AIConfigDB, error) {
 	}
 	
 	if len(configs) == 0 {
-		fmt.Printf("❌ No hay configuraciones disponibles\n")
+		fmt.Printf("❌ No hay configuraciones disponibles (todas deshabilitadas o en cooldown)\n")
 		return nil, fmt.Errorf("no configurations available")
 	}
 	
@@ -502,14 +561,14 @@ func (m *AIConfigManager) RotateToNextConfig() (*AIConfigDB, error) {
 	fmt.Printf("🔄 Activando nueva configuración ID=%d...\n", nextConfig.ID)
 	fmt.Printf("   📋 Detalles: %s - %s (%s)\n", nextConfig.ProviderDisplay, nextConfig.ModelDisplay, nextConfig.Name)
 	
-	activateErr := m.SetActiveConfig(nextConfig.ID)
+	activateErr := m.setActiveConfigLocked(nextConfig.ID)
 	if activateErr != nil {
 		fmt.Printf("❌ ERROR AL ACTIVAR: %v\n", activateErr)
 		fmt.Printf("❌ Tipo de error: %T\n", activateErr)
 		return nil, fmt.Errorf("error activando configuración ID=%d: %v", nextConfig.ID, activateErr)
 	}
 	
-	fmt.Printf("✅ SetActiveConfig completado sin errores\n")
+	fmt.Printf("✅ setActiveConfigLocked completado sin errores\n")
 	nextConfig.IsActive = true
 	
 	// Actualizar caché con la nueva configuración activa
@@ -524,6 +583,38 @@ func (m *AIConfigManager) RotateToNextConfig() (*AIConfigDB, error) {
 	return nextConfig, nil
 }
 
+// GetAvailableConfigs obtiene las configuraciones habilitadas y fuera de cooldown, en el orden de rotación.
+// Solo lectura: no cambia la configuración activa.
+func (m *AIConfigManager) GetAvailableConfigs() ([]AIConfigDB, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	
+	rows, err := m.db.Query(aiConfigSelectQuery + `
+		WHERE c.is_enabled = 1 AND p.is_enabled = 1
+		  AND (c.cooldown_until IS NULL OR c.cooldown_until <= NOW())
+		ORDER BY
+			p.priority DESC,
+			c.error_count ASC,
+			CASE WHEN c.last_used_at IS NULL THEN 0 ELSE 1 END ASC,
+			c.last_used_at ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	
+	var configs []AIConfigDB
+	for rows.Next() {
+		c, err := scanAIConfig(rows)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, *c)
+	}
+	
+	return configs, nil
+}
+
 // ReportError reporta un error en una configuración
 func (m *AIConfigManager) ReportError(id int, errorMsg string) error {
 	m.mu.Lock()
@@ -541,6 +632,80 @@ func (m *AIConfigManager) ReportError(id int, errorMsg string) error {
 	return err
 }
 
+// SetCooldown pausa una configuración hasta la hora indicada (por rate limit / cuota)
+func (m *AIConfigManager) SetCooldown(id int, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	
+	_, err := m.db.Exec(`
+		UPDATE ai_configs 
+		SET cooldown_until = ?,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, until, id)
+	
+	// Invalidar caché para que la próxima lectura vea el cooldown
+	m.activeConfigCache = nil
+	
+	return err
+}
+
+// SetLimits actualiza los límites RPM/TPM/diarios (0 = sin límite) y la concurrencia máxima de una configuración
+func (m *AIConfigManager) SetLimits(id, rpmLimit, tpmLimit, dailyRequestLimit, maxConcurrency int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	
+	if rpmLimit < 0 || tpmLimit < 0 || dailyRequestLimit < 0 {
+		return fmt.Errorf("limits must be zero or positive")
+	}
+	if maxConcurrency < 1 || maxConcurrency > maxWorkerPoolSize {
+		return fmt.Errorf("max concurrency must be between 1 and %d", maxWorkerPoolSize)
+	}
+	
+	_, err := m.db.Exec(`
+		UPDATE ai_configs 
+		SET rpm_limit = ?,
+		    tpm_limit = ?,
+		    daily_request_limit = ?,
+		    max_concurrency = ?,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, rpmLimit, tpmLimit, dailyRequestLimit, maxConcurrency, id)
+	
+	m.activeConfigCache = nil
+	
+	return err
+}
+
+// ReserveDailyRequest suma un request al contador diario de una configuración (se reinicia al cambiar el día),
+// solo si no alcanzó su daily_request_limit. El UPDATE condicional hace que el cupo sea uno solo para todas
+// las instancias que comparten la base. Devuelve false si el límite diario ya se alcanzó.
+func (m *AIConfigManager) ReserveDailyRequest(id int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	
+	// requests_today se asigna antes que requests_today_date, así el IF compara con la fecha anterior
+	res, err := m.db.Exec(`
+		UPDATE ai_configs 
+		SET requests_today = IF(requests_today_date = CURDATE(), requests_today + 1, 1),
+		    requests_today_date = CURDATE()
+		WHERE id = ?
+		  AND (daily_request_limit = 0
+		       OR requests_today_date IS NULL OR requests_today_date <> CURDATE()
+		       OR requests_today < daily_request_limit)
+	`, id)
+	if err != nil {
+		return false, err
+	}
+	
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	
+	return affected > 0, nil
+}
+
 // ReportSuccess reporta un uso exitoso de una configuración
 func (m *AIConfigManager) ReportSuccess(id int) error {
 	m.mu.Lock()
@@ -557,7 +722,7 @@ func (m *AIConfigManager) ReportSuccess(id int) error {
 	return err
 }
 
-// ResetErrorCount resetea el contador de errores de una configuración
+// ResetErrorCount resetea el contador de errores (y el cooldown) de una configuración
 func (m *AIConfigManager) ResetErrorCount(id int) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -566,6 +731,7 @@ func (m *AIConfigManager) ResetErrorCount(id int) error {
 		UPDATE ai_configs 
 		SET error_count = 0,
 		    last_error = NULL,
+		    cooldown_until = NULL,
 		    updated_at = CURRENT_TIMESTAMP
 		WHERE id = ?
 	`, id)
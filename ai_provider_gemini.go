@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// geminiProvider implementa la API generateContent de Google Gemini
+type geminiProvider struct{}
+
+func init() {
+	RegisterProvider(&geminiProvider{})
+}
+
+func (p *geminiProvider) Name() string {
+	return "gemini"
+}
+
+// Call llama a la API de Gemini
+func (p *geminiProvider) Call(client *http.Client, config *AIConfigDB, req *ProviderRequest) (*ProviderResponse, error) {
+	fullPrompt := req.FullPrompt()
+
+	request := map[string]interface{}{
+		"contents": []map[string]interface{}{
+			{
+				"parts": []map[string]interface{}{
+					{"text": fullPrompt},
+				},
+			},
+		},
+		"generationConfig": map[string]interface{}{
+			"temperature":      0.7,
+			"maxOutputTokens":  config.MaxTokens,
+			"topP":             0.95,
+			"topK":             40,
+			"responseMimeType": "application/json",
+		},
+	}
+
+	if req.ResponseSchema != nil {
+		request["generationConfig"].(map[string]interface{})["responseSchema"] = geminiSchema(req.ResponseSchema)
+	}
+
+	url := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/%s:generateContent?key=%s",
+		config.ModelName, config.APIKey)
+
+	fmt.Printf("📏 Tamaño del prompt: %d caracteres\n", len(fullPrompt))
+
+	body, err := postJSON(client, p.Name(), "Gemini", url, nil, request)
+	if err != nil {
+		return nil, err
+	}
+
+	var geminiResp GeminiResponse
+	if err := json.Unmarshal(body, &geminiResp); err != nil {
+		return nil, fmt.Errorf("failed to parse Gemini response: %v", err)
+	}
+
+	if len(geminiResp.Candidates) == 0 || len(geminiResp.Candidates[0].Content.Parts) == 0 {
+		return nil, fmt.Errorf("empty response from Gemini")
+	}
+
+	responseText := geminiResp.Candidates[0].Content.Parts[0].Text
+	fmt.Printf("📦 Respuesta recibida: %d bytes\n", len(responseText))
+
+	return &ProviderResponse{
+		Content: []byte(responseText),
+		Usage: TokenUsage{
+			PromptTokens:     geminiResp.UsageMetadata.PromptTokenCount,
+			CompletionTokens: geminiResp.UsageMetadata.CandidatesTokenCount,
+		},
+	}, nil
+}
+
+// geminiSchema adapta un JSON Schema al subconjunto OpenAPI que acepta Gemini:
+// tipos en mayúsculas, enums con format "enum" y sin additionalProperties
+func geminiSchema(schema map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(schema))
+	for key, value := range schema {
+		switch key {
+		case "type":
+			if typeName, ok := value.(string); ok {
+				out[key] = strings.ToUpper(typeName)
+			}
+		case "properties":
+			if props, ok := value.(map[string]interface{}); ok {
+				converted := make(map[string]interface{}, len(props))
+				for name, prop := range props {
+					if propSchema, ok := prop.(map[string]interface{}); ok {
+						converted[name] = geminiSchema(propSchema)
+					}
+				}
+				out[key] = converted
+			}
+		case "items":
+			if items, ok := value.(map[string]interface{}); ok {
+				out[key] = geminiSchema(items)
+			}
+		case "additionalProperties", "$schema":
+			// No soportados por Gemini
+		default:
+			out[key] = value
+		}
+	}
+
+	if _, hasEnum := out["enum"]; hasEnum {
+		out["format"] = "enum"
+	}
+	return out
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ollamaDefaultBaseURL es la dirección por defecto de un servidor Ollama local
+const ollamaDefaultBaseURL = "http://localhost:11434"
+
+// ollamaProvider implementa la API /api/chat de Ollama para extracción offline.
+// Los mensajes nunca salen de la máquina (o de la red local) configurada en ai_providers.base_url.
+type ollamaProvider struct{}
+
+func init() {
+	RegisterProvider(&ollamaProvider{})
+}
+
+func (p *ollamaProvider) Name() string {
+	return "ollama"
+}
+
+// Call llama a un servidor Ollama usando modo JSON (format: "json")
+func (p *ollamaProvider) Call(client *http.Client, config *AIConfigDB, req *ProviderRequest) (*ProviderResponse, error) {
+	// En modo JSON, Ollama siempre devuelve un objeto: pedimos las cargas dentro de "cargas"
+	request := map[string]interface{}{
+		"model": config.ModelName,
+		"messages": []map[string]interface{}{
+			{
+				"role":    "system",
+				"content": fmt.Sprintf("%s\n\nIMPORTANTE: Responde ÚNICAMENTE con un objeto JSON de la forma {\"cargas\": [...]}. Si no hay cargas, responde {\"cargas\": []}.", req.SystemPrompt),
+			},
+			{
+				"role":    "user",
+				"content": req.UserContent(),
+			},
+		},
+		"stream": false,
+		"format": "json",
+		"options": map[string]interface{}{
+			"temperature": 0.7,
+			"num_predict": config.MaxTokens,
+		},
+	}
+
+	// Con structured output, Ollama (>= 0.5) acepta un JSON Schema en "format" en lugar de "json"
+	if req.ResponseSchema != nil {
+		request["format"] = wrapCargasSchema(req.ResponseSchema)
+	}
+
+	baseURL := strings.TrimRight(strings.TrimSpace(config.BaseURL), "/")
+	if baseURL == "" {
+		baseURL = ollamaDefaultBaseURL
+	}
+
+	// Ollama no usa API key, pero se envía si está configurada (útil detrás de un proxy)
+	headers := map[string]string{}
+	if config.APIKey != "" {
+		headers["Authorization"] = "Bearer " + config.APIKey
+	}
+
+	body, err := postJSON(client, p.Name(), "Ollama", baseURL+"/api/chat", headers, request)
+	if err != nil {
+		return nil, err
+	}
+
+	var ollamaResp struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+		PromptEvalCount int    `json:"prompt_eval_count"`
+		EvalCount       int    `json:"eval_count"`
+		Error           string `json:"error"`
+	}
+
+	if err := json.Unmarshal(body, &ollamaResp); err != nil {
+		return nil, fmt.Errorf("failed to parse Ollama response: %v", err)
+	}
+
+	if ollamaResp.Error != "" {
+		return nil, fmt.Errorf("ollama error: %s", ollamaResp.Error)
+	}
+
+	if strings.TrimSpace(ollamaResp.Message.Content) == "" {
+		return nil, fmt.Errorf("empty response from Ollama")
+	}
+
+	responseText := unwrapCargasObject(ollamaResp.Message.Content)
+	fmt.Printf("📦 Respuesta recibida: %d bytes\n", len(responseText))
+
+	return &ProviderResponse{
+		Content: []byte(responseText),
+		Usage: TokenUsage{
+			PromptTokens:     ollamaResp.PromptEvalCount,
+			CompletionTokens: ollamaResp.EvalCount,
+		},
+	}, nil
+}
+
+// unwrapCargasObject convierte {"cargas": [...]} en [...]. Si el contenido tiene otra forma, lo devuelve tal cual.
+func unwrapCargasObject(content string) string {
+	var wrapper map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(content), &wrapper); err != nil {
+		return content
+	}
+
+	cargas, ok := wrapper["cargas"]
+	if !ok || len(wrapper) != 1 {
+		return content
+	}
+
+	trimmed := strings.TrimSpace(string(cargas))
+	if !strings.HasPrefix(trimmed, "[") {
+		return content
+	}
+	return trimmed
+}
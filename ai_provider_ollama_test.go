@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// ollamaStub levanta un servidor /api/chat que guarda el request recibido y responde con handler
+func ollamaStub(t *testing.T, handler func(w http.ResponseWriter, request map[string]interface{})) (*httptest.Server, *map[string]interface{}) {
+	t.Helper()
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" || r.Method != http.MethodPost {
+			t.Errorf("request inesperado: %s %s", r.Method, r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("request no es JSON: %v", err)
+		}
+		handler(w, received)
+	}))
+	t.Cleanup(server.Close)
+	return server, &received
+}
+
+func ollamaTestConfig(baseURL string) *AIConfigDB {
+	return &AIConfigDB{ProviderName: "ollama", BaseURL: baseURL + "/", ModelName: "llama3.1", MaxTokens: 2048}
+}
+
+func TestOllamaProviderJSONMode(t *testing.T) {
+	server, received := ollamaStub(t, func(w http.ResponseWriter, _ map[string]interface{}) {
+		w.Write([]byte(`{"message":{"role":"assistant","content":"{\"cargas\": [{\"material\": \"Soja\"}]}"},"prompt_eval_count":120,"eval_count":30,"done":true}`))
+	})
+
+	resp, err := (&ollamaProvider{}).Call(server.Client(), ollamaTestConfig(server.URL), &ProviderRequest{SystemPrompt: "sistema", UserPrompt: "mensaje"})
+	if err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+
+	req := *received
+	if req["model"] != "llama3.1" || req["stream"] != false || req["format"] != "json" {
+		t.Errorf("request = model %v, stream %v, format %v; se esperaba llama3.1, false, json", req["model"], req["stream"], req["format"])
+	}
+	messages, _ := req["messages"].([]interface{})
+	if len(messages) != 2 {
+		t.Fatalf("se esperaban 2 mensajes (system y user), hay %d", len(messages))
+	}
+	system, _ := messages[0].(map[string]interface{})
+	if !strings.HasPrefix(system["content"].(string), "sistema") || !strings.Contains(system["content"].(string), `{"cargas": [...]}`) {
+		t.Errorf("el system prompt no pide el objeto {\"cargas\": [...]}: %q", system["content"])
+	}
+
+	if got := string(resp.Content); got != `[{"material": "Soja"}]` {
+		t.Errorf("Content = %s, se esperaba el array de cargas sin envolver", got)
+	}
+	if resp.Usage.PromptTokens != 120 || resp.Usage.CompletionTokens != 30 {
+		t.Errorf("Usage = %+v, se esperaba 120/30", resp.Usage)
+	}
+}
+
+func TestOllamaProviderStructuredOutput(t *testing.T) {
+	server, received := ollamaStub(t, func(w http.ResponseWriter, _ map[string]interface{}) {
+		w.Write([]byte(`{"message":{"content":"{\"cargas\": []}"}}`))
+	})
+
+	resp, err := (&ollamaProvider{}).Call(server.Client(), ollamaTestConfig(server.URL), &ProviderRequest{ResponseSchema: CargaJSONSchema()})
+	if err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+
+	format, ok := (*received)["format"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("format = %v, se esperaba el JSON Schema", (*received)["format"])
+	}
+	if _, ok := format["properties"].(map[string]interface{})["cargas"]; !ok {
+		t.Errorf("el schema no envuelve las cargas en \"cargas\": %v", format)
+	}
+	if string(resp.Content) != "[]" {
+		t.Errorf("Content = %s, se esperaba []", resp.Content)
+	}
+}
+
+func TestOllamaProviderResponseParsing(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    string
+		wantErr string
+	}{
+		{name: "array sin envolver", body: `{"message":{"content":"[{\"peso\": \"30000\"}]"}}`, want: `[{"peso": "30000"}]`},
+		{name: "objeto con otras claves", body: `{"message":{"content":"{\"cargas\": [], \"nota\": \"x\"}"}}`, want: `{"cargas": [], "nota": "x"}`},
+		{name: "respuesta vacía", body: `{"message":{"content":"  "}}`, wantErr: "empty response from Ollama"},
+		{name: "error en el cuerpo", body: `{"error":"out of memory"}`, wantErr: "ollama error: out of memory"},
+		{name: "cuerpo inválido", body: `no es json`, wantErr: "failed to parse Ollama response"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := ollamaStub(t, func(w http.ResponseWriter, _ map[string]interface{}) {
+				w.Write([]byte(tt.body))
+			})
+
+			resp, err := (&ollamaProvider{}).Call(server.Client(), ollamaTestConfig(server.URL), &ProviderRequest{})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, se esperaba %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Call: %v", err)
+			}
+			if string(resp.Content) != tt.want {
+				t.Errorf("Content = %s, se esperaba %s", resp.Content, tt.want)
+			}
+		})
+	}
+}
+
+func TestOllamaProviderMissingModel(t *testing.T) {
+	server, _ := ollamaStub(t, func(w http.ResponseWriter, _ map[string]interface{}) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"model \"llama3.1\" not found, try pulling it first"}`))
+	})
+
+	_, err := (&ollamaProvider{}).Call(server.Client(), ollamaTestConfig(server.URL), &ProviderRequest{})
+
+	var apiErr *ProviderAPIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, se esperaba un ProviderAPIError", err)
+	}
+	if apiErr.Provider != "ollama" || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("ProviderAPIError = %s %d, se esperaba ollama 404", apiErr.Provider, apiErr.StatusCode)
+	}
+	if !strings.Contains(apiErr.Body, "not found, try pulling it first") {
+		t.Errorf("el error no dice que falta el modelo: %q", apiErr.Body)
+	}
+}
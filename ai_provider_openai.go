@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// arrayJSONInstruction se agrega al system prompt de los proveedores que tienden a devolver un objeto suelto
+const arrayJSONInstruction = "IMPORTANTE: Debes responder con un array JSON. Si hay UNA carga, responde [{...carga...}]. Si hay MÚLTIPLES cargas, responde [{...carga1...}, {...carga2...}].\nEl formato debe ser SIEMPRE un array, nunca un objeto suelto."
+
+// openAIChatProvider implementa el formato chat/completions de OpenAI.
+// La URL se arma con ai_providers.base_url; defaultBaseURL solo se usa si la columna está vacía.
+type openAIChatProvider struct {
+	name           string
+	label          string // Si está vacío se usa ai_providers.display_name
+	defaultBaseURL string
+	systemSuffix   string
+	jsonMode       bool           // Enviar response_format: json_object
+	statusHints    map[int]string // Mensajes de ayuda para códigos HTTP específicos
+}
+
+func init() {
+	// Proveedor genérico: cualquier fila de ai_providers con api_format = 'openai-compatible'
+	// (together.ai, OpenRouter, vLLM, llama.cpp server, etc.) se resuelve acá sin cambiar código
+	RegisterProvider(&openAIChatProvider{
+		name:         "openai-compatible",
+		systemSuffix: arrayJSONInstruction,
+	})
+
+	// Proveedores conocidos con pequeñas diferencias de comportamiento
+	RegisterProvider(&openAIChatProvider{
+		name:           "groq",
+		label:          "Groq",
+		defaultBaseURL: "https://api.groq.com/openai/v1",
+		systemSuffix:   arrayJSONInstruction,
+	})
+	RegisterProvider(&openAIChatProvider{
+		name:           "grok",
+		label:          "Grok",
+		defaultBaseURL: "https://api.x.ai/v1",
+		systemSuffix:   "IMPORTANTE: Debes responder ÚNICAMENTE con un array JSON válido de cargas.",
+		jsonMode:       true,
+	})
+	RegisterProvider(&openAIChatProvider{
+		name:           "deepseek",
+		label:          "DeepSeek",
+		defaultBaseURL: "https://api.deepseek.com/v1",
+		systemSuffix:   arrayJSONInstruction,
+		statusHints: map[int]string{
+			402: "💳 SALDO INSUFICIENTE EN DEEPSEEK - Recarga tu cuenta en https://platform.deepseek.com",
+		},
+	})
+}
+
+func (p *openAIChatProvider) Name() string {
+	return p.name
+}
+
+// Call llama a un endpoint chat/completions compatible con OpenAI
+func (p *openAIChatProvider) Call(client *http.Client, config *AIConfigDB, req *ProviderRequest) (*ProviderResponse, error) {
+	request := map[string]interface{}{
+		"model": config.ModelName,
+		"messages": []map[string]interface{}{
+			{
+				"role":    "system",
+				"content": fmt.Sprintf("%s\n\n%s", req.SystemPrompt, p.systemSuffix),
+			},
+			{
+				"role":    "user",
+				"content": req.UserContent(),
+			},
+		},
+		"temperature": 0.7,
+		"max_tokens":  config.MaxTokens,
+	}
+	if req.ResponseSchema != nil {
+		// Structured output: la raíz debe ser un objeto, las cargas vienen en {"cargas": [...]}
+		request["response_format"] = map[string]interface{}{
+			"type": "json_schema",
+			"json_schema": map[string]interface{}{
+				"name":   "cargas",
+				"schema": wrapCargasSchema(req.ResponseSchema),
+			},
+		}
+	} else if p.jsonMode {
+		request["response_format"] = map[string]string{
+			"type": "json_object",
+		}
+	}
+
+	// Servidores locales (vLLM, llama.cpp) pueden no requerir API key
+	headers := map[string]string{}
+	if config.APIKey != "" {
+		headers["Authorization"] = "Bearer " + config.APIKey
+	}
+
+	url, err := p.endpoint(config)
+	if err != nil {
+		return nil, err
+	}
+
+	label := p.label
+	if label == "" {
+		label = config.ProviderDisplay
+	}
+
+	body, err := postJSON(client, config.ProviderName, label, url, headers, request)
+	if err != nil {
+		if apiErr, ok := err.(*ProviderAPIError); ok {
+			if hint, exists := p.statusHints[apiErr.StatusCode]; exists {
+				fmt.Printf("%s\n", hint)
+			}
+		}
+		return nil, err
+	}
+
+	var chatResp struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
+	}
+
+	if err := json.Unmarshal(body, &chatResp); err != nil {
+		return nil, fmt.Errorf("failed to parse %s response: %v", label, err)
+	}
+
+	if len(chatResp.Choices) == 0 {
+		return nil, fmt.Errorf("empty response from %s", label)
+	}
+
+	responseText := chatResp.Choices[0].Message.Content
+	if req.ResponseSchema != nil {
+		responseText = unwrapCargasObject(responseText)
+	}
+	fmt.Printf("📦 Respuesta recibida: %d bytes\n", len(responseText))
+
+	return &ProviderResponse{
+		Content: []byte(responseText),
+		Usage: TokenUsage{
+			PromptTokens:     chatResp.Usage.PromptTokens,
+			CompletionTokens: chatResp.Usage.CompletionTokens,
+		},
+	}, nil
+}
+
+// endpoint arma la URL de chat/completions a partir de ai_providers.base_url
+func (p *openAIChatProvider) endpoint(config *AIConfigDB) (string, error) {
+	baseURL := strings.TrimRight(strings.TrimSpace(config.BaseURL), "/")
+	if baseURL == "" {
+		baseURL = p.defaultBaseURL
+	}
+	if baseURL == "" {
+		return "", fmt.Errorf("provider %s has no base_url configured", config.ProviderName)
+	}
+
+	if strings.HasSuffix(baseURL, "/chat/completions") {
+		return baseURL, nil
+	}
+	return baseURL + "/chat/completions", nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// qwenProvider implementa la API text-generation de DashScope (Alibaba Qwen)
+type qwenProvider struct{}
+
+func init() {
+	RegisterProvider(&qwenProvider{})
+}
+
+func (p *qwenProvider) Name() string {
+	return "qwen"
+}
+
+// Call llama a la API de Qwen
+func (p *qwenProvider) Call(client *http.Client, config *AIConfigDB, req *ProviderRequest) (*ProviderResponse, error) {
+	request := map[string]interface{}{
+		"model": config.ModelName,
+		"input": map[string]interface{}{
+			"messages": []map[string]interface{}{
+				{
+					"role":    "system",
+					"content": fmt.Sprintf("%s\n\nIMPORTANTE: Responde ÚNICAMENTE con JSON válido.", req.SystemPrompt),
+				},
+				{
+					"role":    "user",
+					"content": req.UserContent(),
+				},
+			},
+		},
+		"parameters": map[string]interface{}{
+			"result_format": "message",
+			"temperature":   0.7,
+			"max_tokens":    config.MaxTokens,
+		},
+	}
+
+	url := "https://dashscope.aliyuncs.com/api/v1/services/aigc/text-generation/generation"
+	headers := map[string]string{
+		"Authorization": "Bearer " + config.APIKey,
+	}
+
+	body, err := postJSON(client, p.Name(), "Qwen", url, headers, request)
+	if err != nil {
+		return nil, err
+	}
+
+	var qwenResp struct {
+		Output struct {
+			Choices []struct {
+				Message struct {
+					Content string `json:"content"`
+				} `json:"message"`
+			} `json:"choices"`
+		} `json:"output"`
+		Usage struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	}
+
+	if err := json.Unmarshal(body, &qwenResp); err != nil {
+		return nil, fmt.Errorf("failed to parse Qwen response: %v", err)
+	}
+
+	if len(qwenResp.Output.Choices) == 0 {
+		return nil, fmt.Errorf("empty response from Qwen")
+	}
+
+	responseText := qwenResp.Output.Choices[0].Message.Content
+	fmt.Printf("📦 Respuesta recibida: %d bytes\n", len(responseText))
+
+	return &ProviderResponse{
+		Content: []byte(responseText),
+		Usage: TokenUsage{
+			PromptTokens:     qwenResp.Usage.InputTokens,
+			CompletionTokens: qwenResp.Usage.OutputTokens,
+		},
+	}, nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider es la interfaz que implementa cada backend de IA.
+// Name debe coincidir con la columna ai_providers.name de la base de datos.
+type Provider interface {
+	Name() string
+	Call(client *http.Client, config *AIConfigDB, req *ProviderRequest) (*ProviderResponse, error)
+}
+
+// ProviderResponse es la respuesta de un proveedor: el texto generado y los tokens consumidos
+type ProviderResponse struct {
+	Content []byte
+	Usage   TokenUsage
+}
+
+// TokenUsage son los tokens reportados por el proveedor (0 si no los informa)
+type TokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+var (
+	providerRegistryMu sync.RWMutex
+	providerRegistry   = map[string]Provider{}
+)
+
+// RegisterProvider registra un proveedor en el registro global.
+// Se llama desde el init() de cada archivo de proveedor.
+func RegisterProvider(p Provider) {
+	providerRegistryMu.Lock()
+	defer providerRegistryMu.Unlock()
+
+	if _, exists := providerRegistry[p.Name()]; exists {
+		panic(fmt.Sprintf("AI provider already registered: %s", p.Name()))
+	}
+	providerRegistry[p.Name()] = p
+}
+
+// GetProvider obtiene un proveedor registrado por nombre
+func GetProvider(name string) (Provider, bool) {
+	providerRegistryMu.RLock()
+	defer providerRegistryMu.RUnlock()
+
+	p, ok := providerRegistry[name]
+	return p, ok
+}
+
+// ResolveProvider busca el proveedor de una configuración: primero por ai_providers.name y,
+// si no hay uno registrado con ese nombre, por ai_providers.api_format (ej: "openai-compatible")
+func ResolveProvider(config *AIConfigDB) (Provider, bool) {
+	if p, ok := GetProvider(config.ProviderName); ok {
+		return p, true
+	}
+	if config.APIFormat != "" {
+		return GetProvider(config.APIFormat)
+	}
+	return nil, false
+}
+
+// RegisteredProviders devuelve los nombres de todos los proveedores registrados (ordenados)
+func RegisteredProviders() []string {
+	providerRegistryMu.RLock()
+	defer providerRegistryMu.RUnlock()
+
+	names := make([]string, 0, len(providerRegistry))
+	for name := range providerRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ProviderRequest agrupa el prompt ya armado a partir del template activo, con la fecha argentina ya calculada
+type ProviderRequest struct {
+	SystemPrompt string // System prompt con las variables reemplazadas
+	UserPrompt   string // Mensaje "user" (fecha, teléfono, mensaje) con las variables reemplazadas
+
+	// ResponseSchema es el JSON Schema de la respuesta (structured output).
+	// nil = modo solo-prompt: el formato se pide únicamente con instrucciones en el prompt.
+	ResponseSchema map[string]interface{}
+}
+
+// NewProviderRequest arma el request con un template del prompt, usando la fecha actual en zona horaria argentina (UTC-3)
+func NewProviderRequest(prompt *PromptTemplate, msg PromptMessage) *ProviderRequest {
+	now := time.Now()
+	if !msg.Now.IsZero() {
+		now = msg.Now
+	}
+	if argLocation, err := time.LoadLocation("America/Argentina/Buenos_Aires"); err == nil {
+		now = now.In(argLocation)
+	}
+
+	systemPrompt, userPrompt := prompt.Render(msg, now)
+	return &ProviderRequest{
+		SystemPrompt: systemPrompt,
+		UserPrompt:   userPrompt,
+	}
+}
+
+// FullPrompt construye un único prompt (system + fecha + teléfono + mensaje) para APIs sin roles
+func (r *ProviderRequest) FullPrompt() string {
+	return fmt.Sprintf("%s\n\n%s", r.SystemPrompt, r.UserPrompt)
+}
+
+// UserContent construye el contenido del mensaje "user" para APIs con roles (formato chat)
+func (r *ProviderRequest) UserContent() string {
+	return r.UserPrompt
+}
+
+// ProviderAPIError representa una respuesta HTTP distinta de 200 de un proveedor
+type ProviderAPIError struct {
+	Provider   string
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration // Valor del header Retry-After (0 si no vino)
+}
+
+func (e *ProviderAPIError) Error() string {
+	return fmt.Sprintf("%s API error %d: %s", e.Provider, e.StatusCode, e.Body)
+}
+
+// postJSON envía un POST con cuerpo JSON y devuelve el cuerpo de la respuesta.
+// label es el nombre legible del proveedor usado en los logs.
+func postJSON(client *http.Client, providerName, label, url string, headers map[string]string, payload interface{}) ([]byte, error) {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	fmt.Printf("🤖 [%s] Enviando request...\n", label)
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	startTime := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	elapsed := time.Since(startTime).Seconds()
+	fmt.Printf("⏱️ Respuesta recibida en %.2f segundos\n", elapsed)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	fmt.Printf("📥 Recibiendo respuesta de %s... (Status: %d)\n", label, resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == 429 {
+			fmt.Printf("🚨 ERROR 429 DETECTADO EN %s - RATE LIMIT EXCEDIDO\n", label)
+			fmt.Printf("📄 Respuesta completa: %s\n", string(body))
+		}
+
+		return nil, &ProviderAPIError{
+			Provider:   providerName,
+			StatusCode: resp.StatusCode,
+			Body:       string(body),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	return body, nil
+}
+
+// parseRetryAfter interpreta el header Retry-After (segundos o fecha HTTP)
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -1,11 +1,12 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -14,533 +15,454 @@ import (
 type AIProviderService struct {
 	client       *http.Client
 	configManager *AIConfigManager
+	limiter      *AIRateLimiter // Límites RPM/TPM/diarios por config
+	prompts      *PromptTemplateManager // Templates versionados del prompt (con override por proveedor)
+	
+	// noAccounting deja las llamadas fuera de la contabilidad en la BD (ai_call_log, requests_today,
+	// éxito/error de la config). Lo usa el comando eval para no mezclar las evaluaciones con el uso real.
+	noAccounting bool
 }
 
 // NewAIProviderService crea una nueva instancia del servicio
-func NewAIProviderService(configManager *AIConfigManager) *AIProviderService {
+func NewAIProviderService(configManager *AIConfigManager, prompts *PromptTemplateManager) *AIProviderService {
 	return &AIProviderService{
 		client: &http.Client{
 			Timeout: 120 * time.Second,
 		},
 		configManager: configManager,
+		limiter:       NewAIRateLimiter(),
+		prompts:       prompts,
 	}
 }
 
-// ProcessMessage procesa un mensaje usando el proveedor activo (sin reintentos automáticos)
-func (s *AIProviderService) ProcessMessage(systemPrompt, userMessage, realPhone string) ([]byte, error) {
+// DisableAccounting deja de registrar las llamadas en la BD: no se escriben ai_call_log ni los contadores de la config.
+// Los límites RPM/TPM/diarios se siguen respetando (el diario contra el contador actual, sin sumar requests).
+func (s *AIProviderService) DisableAccounting() {
+	s.noAccounting = true
+}
+
+// maxFailoverAttempts limita cuántas configuraciones se prueban para un mismo mensaje
+const maxFailoverAttempts = 5
+
+// Cooldowns por defecto cuando el proveedor no informa cuándo se libera el límite
+const (
+	defaultRateLimitCooldown = 1 * time.Minute
+	dailyQuotaCooldown       = 1 * time.Hour
+)
+
+// maxLimiterWait es la espera máxima cuando todas las configs agotaron su presupuesto RPM/TPM;
+// esperas más largas (ej: límite diario) devuelven error y el mensaje se reintenta más tarde
+const maxLimiterWait = 15 * time.Second
+
+// FailoverStep registra una configuración que falló por rate limit y fue reemplazada
+type FailoverStep struct {
+	ConfigID      int       `json:"config_id"`
+	ConfigName    string    `json:"config_name"`
+	Provider      string    `json:"provider"`
+	Model         string    `json:"model"`
+	Error         string    `json:"error"`
+	CooldownUntil time.Time `json:"cooldown_until"`
+}
+
+// AICallResult contiene la respuesta de IA, la configuración y versión del prompt que la produjeron y la cadena de failover
+type AICallResult struct {
+	Response      []byte
+	Config        *AIConfigDB
+	Prompt        *PromptTemplate
+	FailoverChain []FailoverStep
+}
+
+// ProcessMessage procesa un mensaje usando el proveedor activo.
+// Ante un rate limit (429/503/cuota) pone la config en cooldown y pasa a la siguiente elegible.
+// Siempre devuelve un AICallResult (aunque haya error) para poder registrar la cadena de failover.
+func (s *AIProviderService) ProcessMessage(msg PromptMessage) (*AICallResult, error) {
+	result := &AICallResult{}
 	
 	// Obtener configuración activa desde caché (optimizado para concurrencia)
 	config, err := s.configManager.GetActiveConfig()
 	if err != nil {
-		return nil, fmt.Errorf("no active AI configuration: %v", err)
+		return result, fmt.Errorf("no active AI configuration: %v", err)
 	}
 	
 	if config == nil {
-		return nil, fmt.Errorf("no hay configuración de IA activa. Ve a '⚙️ Configuración IA' y activa una")
-	}
-	
-	fmt.Printf("🤖 Usando: %s - %s (%s)\n", config.ProviderDisplay, config.ModelDisplay, config.Name)
-	
-	// Llamar al proveedor correspondiente
-	var response []byte
-	switch config.ProviderName {
-	case "gemini":
-		response, err = s.callGemini(config, systemPrompt, userMessage, realPhone)
-	case "groq":
-		response, err = s.callGroq(config, systemPrompt, userMessage, realPhone)
-	case "grok":
-		response, err = s.callGrok(config, systemPrompt, userMessage, realPhone)
-	case "deepseek":
-		response, err = s.callDeepSeek(config, systemPrompt, userMessage, realPhone)
-	case "qwen":
-		response, err = s.callQwen(config, systemPrompt, userMessage, realPhone)
-	default:
-		return nil, fmt.Errorf("unsupported provider: %s", config.ProviderName)
+		return result, fmt.Errorf("no hay configuración de IA activa. Ve a '⚙️ Configuración IA' y activa una")
 	}
 	
-	if err != nil {
-		// Reportar error
-		s.configManager.ReportError(config.ID, err.Error())
-		
-		// Si es error de rate limit (429, 503), detener y pedir cambio manual
-		if isRateLimitError(err) {
-			fmt.Printf("⚠️ RATE LIMIT DETECTADO (429/503) en %s - %s (%s)\n", config.ProviderDisplay, config.ModelDisplay, config.Name)
-			fmt.Printf("🛑 PROCESAMIENTO DETENIDO - Rate limit alcanzado\n")
-			fmt.Printf("💡 SOLUCIÓN: Ve a '⚙️ Configuración IA' y activa otra API key manualmente\n")
-			fmt.Printf("📋 Sugerencia: Activa una key de otro proveedor (Groq, Gemini, etc.)\n")
+	tried := make(map[int]bool)
+	
+	for attempt := 1; attempt <= maxFailoverAttempts; attempt++ {
+		// Si la config activa sigue en cooldown, pasar directamente a la siguiente
+		if config.CooldownUntil != nil && time.Now().Before(*config.CooldownUntil) {
+			fmt.Printf("⏸️ %s - %s (%s) en cooldown hasta %s\n", config.ProviderDisplay, config.ModelDisplay, config.Name,
+				config.CooldownUntil.Format("15:04:05"))
+			tried[config.ID] = true
 			
-			return nil, fmt.Errorf("rate limit alcanzado en %s - %s (%s). Ve a Configuración IA y activa otra key manualmente", 
-				config.ProviderDisplay, config.ModelDisplay, config.Name)
+			next, err := s.configManager.RotateFromConfig(config.ID)
+			if err != nil || tried[next.ID] {
+				return result, fmt.Errorf("todas las configuraciones de IA están en cooldown por rate limit (la activa hasta %s)",
+					config.CooldownUntil.Format("15:04:05"))
+			}
+			config = next
+		}
+		
+		// Respetar los límites RPM/TPM/diarios antes de recibir un 429. Es un límite local:
+		// se resuelve usando otra config con presupuesto o esperando, sin cooldown ni paso de failover
+		reserved, prompt, err := s.reserveBudget(config, tried, msg)
+		if err != nil {
+			return result, err
+		}
+		config = reserved
+		
+		response, err := s.CallWithConfig(config, prompt, msg)
+		if err == nil {
+			result.Response = response
+			result.Config = config
+			result.Prompt = prompt
+			return result, nil
 		}
 		
 		// Si es otro tipo de error, no reintentar
-		fmt.Printf("❌ Error no recuperable: %v\n", err)
-		return nil, err
+		if !isRateLimitError(err) {
+			fmt.Printf("❌ Error no recuperable: %v\n", err)
+			return result, err
+		}
+		
+		// Rate limit: cooldown de la config actual y failover a la siguiente
+		cooldownUntil := time.Now().Add(rateLimitCooldown(err))
+		fmt.Printf("⚠️ RATE LIMIT DETECTADO en %s - %s (%s), cooldown hasta %s\n",
+			config.ProviderDisplay, config.ModelDisplay, config.Name, cooldownUntil.Format("15:04:05"))
+		
+		next := s.failover(result, tried, config, err, cooldownUntil)
+		if next == nil {
+			fmt.Printf("🛑 No quedan configuraciones sin rate limit - el mensaje se reintentará más tarde\n")
+			return result, fmt.Errorf("rate limit alcanzado en todas las configuraciones disponibles (última: %s - %s (%s)): %v",
+				config.ProviderDisplay, config.ModelDisplay, config.Name, err)
+		}
+		config = next
 	}
 	
-	// Reportar éxito
-	s.configManager.ReportSuccess(config.ID)
-	fmt.Printf("✅ Procesamiento exitoso con %s - %s (%s)\n", config.ProviderDisplay, config.ModelDisplay, config.Name)
-	
-	return response, nil
+	return result, fmt.Errorf("failover agotado después de %d intentos", maxFailoverAttempts)
 }
 
-// callGemini llama a la API de Gemini
-func (s *AIProviderService) callGemini(config *AIConfigDB, systemPrompt, userMessage, realPhone string) ([]byte, error) {
-	// Obtener fecha actual en zona horaria argentina (UTC-3)
-	argLocation, _ := time.LoadLocation("America/Argentina/Buenos_Aires")
-	currentDate := time.Now().In(argLocation).Format("02/01/2006") // DD/MM/YYYY
-	currentDateTime := time.Now().In(argLocation).Format("02/01/2006 15:04") // DD/MM/YYYY HH:MM
-	
-	// Construir el prompt completo con fecha actual
-	fullPrompt := fmt.Sprintf("%s\n\n## FECHA Y HORA ACTUAL (Argentina)\n- Hoy es: %s\n- Fecha y hora actual: %s\n- Zona horaria: Argentina (UTC-3)\n- IMPORTANTE: Usa esta fecha como referencia para \"hoy\", \"mañana\", etc.\n\n## Información del Cliente\n- Teléfono: %s\n\n## Mensaje del Cliente\n%s",
-		systemPrompt, currentDate, currentDateTime, realPhone, userMessage)
-	
-	// Construir request
-	request := map[string]interface{}{
-		"contents": []map[string]interface{}{
-			{
-				"parts": []map[string]interface{}{
-					{"text": fullPrompt},
-				},
-			},
-		},
-		"generationConfig": map[string]interface{}{
-			"temperature":     0.7,
-			"maxOutputTokens": config.MaxTokens,
-			"topP":            0.95,
-			"topK":            40,
-			"responseMimeType": "application/json",
-		},
-	}
-	
-	jsonData, err := json.Marshal(request)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %v", err)
-	}
-	
-	// Construir URL
-	url := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/%s:generateContent?key=%s",
-		config.ModelName, config.APIKey)
-	
-	fmt.Printf("🤖 [Gemini] Enviando request...\n")
-	fmt.Printf("📏 Tamaño del prompt: %d caracteres\n", len(fullPrompt))
-	
-	startTime := time.Now()
-	resp, err := s.client.Post(url, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %v", err)
-	}
-	defer resp.Body.Close()
-	
-	elapsed := time.Since(startTime).Seconds()
-	fmt.Printf("⏱️ Respuesta recibida en %.2f segundos\n", elapsed)
-	
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %v", err)
-	}
-	
-	fmt.Printf("📥 Recibiendo respuesta de Gemini... (Status: %d)\n", resp.StatusCode)
-	
-	if resp.StatusCode != http.StatusOK {
-		// Log detallado del error con el código exacto
-		if resp.StatusCode == 429 {
-			fmt.Printf("🚨 ERROR 429 DETECTADO - RATE LIMIT EXCEDIDO\n")
-			fmt.Printf("📄 Respuesta completa: %s\n", string(body))
+// reserveBudget reserva presupuesto RPM/TPM/diario para el mensaje en la config. Si no alcanza, usa otra config
+// disponible con presupuesto (sin activarla) o espera a que se recargue el bucket más próximo (hasta maxLimiterWait).
+// Devuelve la config reservada y el template del prompt que le corresponde.
+func (s *AIProviderService) reserveBudget(config *AIConfigDB, tried map[int]bool, msg PromptMessage) (*AIConfigDB, *PromptTemplate, error) {
+	for attempt := 1; attempt <= maxFailoverAttempts; attempt++ {
+		// El template se resuelve por config: el proveedor puede tener su propio override
+		prompt := s.prompts.Resolve(config.ProviderName)
+		wait, limitErr := s.reserve(config, estimatePromptTokens(prompt, msg))
+		if limitErr == nil {
+			return config, prompt, nil
 		}
+		fmt.Printf("⏳ Presupuesto agotado en %s - %s (%s): %v\n", config.ProviderDisplay, config.ModelDisplay, config.Name, limitErr)
 		
-		return nil, fmt.Errorf("gemini API error %d: %s", resp.StatusCode, string(body))
-	}
-	
-	// Parsear respuesta
-	var geminiResp GeminiResponse
-	if err := json.Unmarshal(body, &geminiResp); err != nil {
-		return nil, fmt.Errorf("failed to parse Gemini response: %v", err)
-	}
-	
-	if len(geminiResp.Candidates) == 0 || len(geminiResp.Candidates[0].Content.Parts) == 0 {
-		return nil, fmt.Errorf("empty response from Gemini")
+		// Buscar otra config con presupuesto, sin cambiar la activa
+		candidates, err := s.configManager.GetAvailableConfigs()
+		if err != nil {
+			fmt.Printf("⚠️ Error obteniendo configuraciones disponibles: %v\n", err)
+		}
+		for i := range candidates {
+			alt := &candidates[i]
+			if alt.ID == config.ID || tried[alt.ID] {
+				continue
+			}
+			altPrompt := s.prompts.Resolve(alt.ProviderName)
+			altWait, altErr := s.reserve(alt, estimatePromptTokens(altPrompt, msg))
+			if altErr == nil {
+				fmt.Printf("🔀 Usando %s (%s) mientras se recarga el presupuesto de %s\n", alt.ProviderDisplay, alt.Name, config.Name)
+				return alt, altPrompt, nil
+			}
+			if altWait < wait {
+				wait = altWait
+			}
+		}
+		
+		if wait > maxLimiterWait {
+			return nil, nil, fmt.Errorf("presupuesto agotado en todas las configuraciones disponibles: %v", limitErr)
+		}
+		
+		// Ninguna config tiene presupuesto: esperar a que se recargue el bucket más próximo y reintentar
+		fmt.Printf("⏳ Esperando %s a que se recargue el presupuesto...\n", wait.Round(time.Second))
+		time.Sleep(wait + 100*time.Millisecond)
 	}
 	
-	responseText := geminiResp.Candidates[0].Content.Parts[0].Text
-	fmt.Printf("📦 Respuesta recibida: %d bytes\n", len(responseText))
-	
-	return []byte(responseText), nil
+	return nil, nil, fmt.Errorf("presupuesto agotado en todas las configuraciones disponibles después de %d esperas", maxFailoverAttempts)
 }
 
-// callGroq llama a la API de Groq
-func (s *AIProviderService) callGroq(config *AIConfigDB, systemPrompt, userMessage, realPhone string) ([]byte, error) {
-	// Obtener fecha actual en zona horaria argentina (UTC-3)
-	argLocation, _ := time.LoadLocation("America/Argentina/Buenos_Aires")
-	currentDate := time.Now().In(argLocation).Format("02/01/2006") // DD/MM/YYYY
-	currentDateTime := time.Now().In(argLocation).Format("02/01/2006 15:04") // DD/MM/YYYY HH:MM
-	
-	// Groq usa formato compatible con OpenAI
-	request := map[string]interface{}{
-		"model": config.ModelName,
-		"messages": []map[string]interface{}{
-			{
-				"role": "system",
-				"content": fmt.Sprintf("%s\n\nIMPORTANTE: Debes responder con un array JSON. Si hay UNA carga, responde [{...carga...}]. Si hay MÚLTIPLES cargas, responde [{...carga1...}, {...carga2...}].\nEl formato debe ser SIEMPRE un array, nunca un objeto suelto.", systemPrompt),
-			},
-			{
-				"role": "user",
-				"content": fmt.Sprintf("FECHA Y HORA ACTUAL (Argentina):\n- Hoy es: %s\n- Fecha y hora actual: %s\n- Zona horaria: Argentina (UTC-3)\n- IMPORTANTE: Usa esta fecha como referencia para \"hoy\", \"mañana\", etc.\n\nTeléfono del cliente: %s\n\n%s", currentDate, currentDateTime, realPhone, userMessage),
-			},
-		},
-		"temperature": 0.7,
-		"max_tokens": config.MaxTokens,
-	}
-	
-	jsonData, err := json.Marshal(request)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %v", err)
+// reserve reserva 1 request y estimatedTokens tokens de la config: RPM/TPM en el limitador en memoria
+// y el request del día en la BD, así el límite diario se comparte entre instancias
+func (s *AIProviderService) reserve(config *AIConfigDB, estimatedTokens int) (time.Duration, error) {
+	if wait, err := s.limiter.Reserve(config, estimatedTokens); err != nil {
+		return wait, err
 	}
 	
-	url := "https://api.groq.com/openai/v1/chat/completions"
-	
-	fmt.Printf("🤖 [Groq] Enviando request...\n")
-	
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+	ok := config.DailyRequestLimit == 0 || config.RequestsToday < config.DailyRequestLimit
+	if !s.noAccounting {
+		var err error
+		ok, err = s.configManager.ReserveDailyRequest(config.ID)
+		if err != nil {
+			// Sin la BD no se puede verificar el cupo diario: se deja pasar (si se excede, el proveedor responde 429)
+			fmt.Printf("⚠️ Error reservando request diario: %v\n", err)
+			return 0, nil
+		}
 	}
-	
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+config.APIKey)
-	
-	startTime := time.Now()
-	resp, err := s.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %v", err)
+	if !ok {
+		s.limiter.Release(config, estimatedTokens)
+		now := time.Now()
+		midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+		return midnight.Sub(now), fmt.Errorf("límite diario alcanzado (%d requests)", config.DailyRequestLimit)
 	}
-	defer resp.Body.Close()
 	
-	elapsed := time.Since(startTime).Seconds()
-	fmt.Printf("⏱️ Respuesta recibida en %.2f segundos\n", elapsed)
+	return 0, nil
+}
+
+// ProcessMessageWithConfig procesa un mensaje con una configuración elegida (reencolado desde la dead-letter queue).
+// No hace failover: si la config falla, el mensaje vuelve al backoff normal con esa misma config.
+func (s *AIProviderService) ProcessMessageWithConfig(configID int, msg PromptMessage) (*AICallResult, error) {
+	result := &AICallResult{}
 	
-	body, err := io.ReadAll(resp.Body)
+	config, err := s.configManager.GetConfigByID(configID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %v", err)
+		return result, err
 	}
-	
-	fmt.Printf("📥 Recibiendo respuesta de Groq... (Status: %d)\n", resp.StatusCode)
-	
-	if resp.StatusCode != http.StatusOK {
-		if resp.StatusCode == 429 {
-			fmt.Printf("🚨 ERROR 429 DETECTADO EN GROQ - RATE LIMIT EXCEDIDO\n")
-			fmt.Printf("📄 Respuesta completa: %s\n", string(body))
-		}
-		
-		return nil, fmt.Errorf("groq API error %d: %s", resp.StatusCode, string(body))
+	if !config.IsEnabled {
+		return result, fmt.Errorf("la configuración %s está deshabilitada", config.Name)
 	}
-	
-	// Parsear respuesta (formato OpenAI)
-	var groqResp struct {
-		Choices []struct {
-			Message struct {
-				Content string `json:"content"`
-			} `json:"message"`
-		} `json:"choices"`
+	if config.CooldownUntil != nil && time.Now().Before(*config.CooldownUntil) {
+		return result, fmt.Errorf("rate limit: %s en cooldown hasta %s", config.Name, config.CooldownUntil.Format("15:04:05"))
 	}
 	
-	if err := json.Unmarshal(body, &groqResp); err != nil {
-		return nil, fmt.Errorf("failed to parse Groq response: %v", err)
+	prompt := s.prompts.Resolve(config.ProviderName)
+	if wait, limitErr := s.reserve(config, estimatePromptTokens(prompt, msg)); limitErr != nil {
+		return result, fmt.Errorf("presupuesto agotado en %s (%v, disponible en %s)", config.Name, limitErr, wait.Round(time.Second))
 	}
 	
-	if len(groqResp.Choices) == 0 {
-		return nil, fmt.Errorf("empty response from Groq")
+	response, err := s.CallWithConfig(config, prompt, msg)
+	if err != nil {
+		return result, err
 	}
 	
-	responseText := groqResp.Choices[0].Message.Content
-	fmt.Printf("📦 Respuesta recibida: %d bytes\n", len(responseText))
-	
-	return []byte(responseText), nil
+	result.Response = response
+	result.Config = config
+	result.Prompt = prompt
+	return result, nil
 }
 
-// callDeepSeek llama a la API de DeepSeek
-func (s *AIProviderService) callDeepSeek(config *AIConfigDB, systemPrompt, userMessage, realPhone string) ([]byte, error) {
-	// Obtener fecha actual en zona horaria argentina (UTC-3)
-	argLocation, _ := time.LoadLocation("America/Argentina/Buenos_Aires")
-	currentDate := time.Now().In(argLocation).Format("02/01/2006") // DD/MM/YYYY
-	currentDateTime := time.Now().In(argLocation).Format("02/01/2006 15:04") // DD/MM/YYYY HH:MM
-	
-	// DeepSeek usa formato compatible con OpenAI
-	request := map[string]interface{}{
-		"model": config.ModelName,
-		"messages": []map[string]interface{}{
-			{
-				"role": "system",
-				"content": fmt.Sprintf("%s\n\nIMPORTANTE: Debes responder con un array JSON. Si hay UNA carga, responde [{...carga...}]. Si hay MÚLTIPLES cargas, responde [{...carga1...}, {...carga2...}].\nEl formato debe ser SIEMPRE un array, nunca un objeto suelto.", systemPrompt),
-			},
-			{
-				"role": "user",
-				"content": fmt.Sprintf("FECHA Y HORA ACTUAL (Argentina):\n- Hoy es: %s\n- Fecha y hora actual: %s\n- Zona horaria: Argentina (UTC-3)\n- IMPORTANTE: Usa esta fecha como referencia para \"hoy\", \"mañana\", etc.\n\nTeléfono del cliente: %s\n\n%s", currentDate, currentDateTime, realPhone, userMessage),
-			},
-		},
-		"temperature": 0.7,
-		"max_tokens": config.MaxTokens,
-	}
-	
-	jsonData, err := json.Marshal(request)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %v", err)
-	}
-	
-	url := "https://api.deepseek.com/v1/chat/completions"
-	
-	fmt.Printf("🤖 [DeepSeek] Enviando request...\n")
-	
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
-	}
-	
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+config.APIKey)
-	
-	startTime := time.Now()
-	resp, err := s.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %v", err)
+// failover pone la config en cooldown, la agrega a la cadena de failover y activa la siguiente config elegible.
+// Devuelve nil si no queda ninguna config sin probar para este mensaje.
+func (s *AIProviderService) failover(result *AICallResult, tried map[int]bool, config *AIConfigDB, cause error, cooldownUntil time.Time) *AIConfigDB {
+	if err := s.configManager.SetCooldown(config.ID, cooldownUntil); err != nil {
+		fmt.Printf("⚠️ Error guardando cooldown: %v\n", err)
+	}
+	
+	result.FailoverChain = append(result.FailoverChain, FailoverStep{
+		ConfigID:      config.ID,
+		ConfigName:    config.Name,
+		Provider:      config.ProviderName,
+		Model:         config.ModelName,
+		Error:         cause.Error(),
+		CooldownUntil: cooldownUntil,
+	})
+	tried[config.ID] = true
+	
+	next, err := s.configManager.RotateFromConfig(config.ID)
+	if err != nil || tried[next.ID] {
+		return nil
 	}
-	defer resp.Body.Close()
 	
-	elapsed := time.Since(startTime).Seconds()
-	fmt.Printf("⏱️ Respuesta recibida en %.2f segundos\n", elapsed)
-	
-	body, err := io.ReadAll(resp.Body)
+	fmt.Printf("🔀 FAILOVER: %s (%s) → %s (%s)\n", config.ProviderDisplay, config.Name, next.ProviderDisplay, next.Name)
+	return next
+}
+
+// GetBudgets devuelve el presupuesto restante (RPM/TPM/diario) de todas las configuraciones
+func (s *AIProviderService) GetBudgets() ([]ConfigBudget, error) {
+	configs, err := s.configManager.GetAllConfigs()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %v", err)
+		return nil, err
 	}
 	
-	fmt.Printf("📥 Recibiendo respuesta de DeepSeek... (Status: %d)\n", resp.StatusCode)
-	
-	if resp.StatusCode != http.StatusOK {
-		if resp.StatusCode == 429 {
-			fmt.Printf("🚨 ERROR 429 DETECTADO EN DEEPSEEK - RATE LIMIT EXCEDIDO\n")
-			fmt.Printf("📄 Respuesta completa: %s\n", string(body))
-		} else if resp.StatusCode == 402 {
-			fmt.Printf("💳 ERROR 402 DETECTADO EN DEEPSEEK - SALDO INSUFICIENTE\n")
-			fmt.Printf("📄 Respuesta: %s\n", string(body))
-			fmt.Printf("💡 SOLUCIÓN: Recarga tu cuenta en https://platform.deepseek.com\n")
-		}
-		
-		return nil, fmt.Errorf("deepseek API error %d: %s", resp.StatusCode, string(body))
+	budgets := make([]ConfigBudget, 0, len(configs))
+	for i := range configs {
+		budgets = append(budgets, s.limiter.Budget(&configs[i]))
 	}
 	
-	// Parsear respuesta (formato OpenAI)
-	var deepseekResp struct {
-		Choices []struct {
-			Message struct {
-				Content string `json:"content"`
-			} `json:"message"`
-		} `json:"choices"`
-	}
+	return budgets, nil
+}
+
+// maxRepairAttempts limita cuántas veces se le pide al modelo que corrija una respuesta inválida
+const maxRepairAttempts = 2
+
+// RepairResponse le devuelve al mismo modelo su respuesta inválida y el error del parser/validador,
+// pidiéndole una versión corregida con la misma versión del prompt. Respeta el presupuesto RPM/TPM/diario de la config (sin failover).
+func (s *AIProviderService) RepairResponse(config *AIConfigDB, prompt *PromptTemplate, msg PromptMessage, badResponse []byte, validationErr error) ([]byte, error) {
+	repairMsg := msg
+	repairMsg.Content = fmt.Sprintf("%s\n\n## CORRECCIÓN REQUERIDA\nTu respuesta anterior para este mensaje fue:\n%s\n\nEsa respuesta es inválida por el siguiente error:\n%v\n\nDevuelve la respuesta corregida completa: ÚNICAMENTE el array JSON de cargas, sin texto adicional ni bloques de código.",
+		msg.Content, string(badResponse), validationErr)
 	
-	if err := json.Unmarshal(body, &deepseekResp); err != nil {
-		return nil, fmt.Errorf("failed to parse DeepSeek response: %v", err)
+	if wait, limitErr := s.reserve(config, estimatePromptTokens(prompt, repairMsg)); limitErr != nil {
+		return nil, fmt.Errorf("no se puede reparar ahora (%v, disponible en %s)", limitErr, wait.Round(time.Second))
 	}
 	
-	if len(deepseekResp.Choices) == 0 {
-		return nil, fmt.Errorf("empty response from DeepSeek")
+	fmt.Printf("🔧 Pidiendo corrección a %s - %s (%s)\n", config.ProviderDisplay, config.ModelDisplay, config.Name)
+	return s.CallWithConfig(config, prompt, repairMsg)
+}
+
+// CallWithConfig llama a una configuración específica con un template del prompt, sin failover, y reporta éxito/error
+func (s *AIProviderService) CallWithConfig(config *AIConfigDB, prompt *PromptTemplate, msg PromptMessage) ([]byte, error) {
+	response, _, err := s.callWithConfig(config, prompt, msg)
+	return response, err
+}
+
+// CompareWithConfig llama a una configuración específica con su template del prompt, sin failover ni reparación,
+// y devuelve también los tokens, la latencia y el costo de la llamada (comparación de modelos).
+// Respeta el presupuesto RPM/TPM/diario de la config.
+func (s *AIProviderService) CompareWithConfig(config *AIConfigDB, msg PromptMessage) ([]byte, *PromptTemplate, AICallLogEntry, error) {
+	prompt := s.prompts.Resolve(config.ProviderName)
+	if wait, limitErr := s.reserve(config, estimatePromptTokens(prompt, msg)); limitErr != nil {
+		return nil, prompt, AICallLogEntry{}, fmt.Errorf("presupuesto agotado (%v, disponible en %s)", limitErr, wait.Round(time.Second))
 	}
 	
-	responseText := deepseekResp.Choices[0].Message.Content
-	fmt.Printf("📦 Respuesta recibida: %d bytes\n", len(responseText))
-	
-	return []byte(responseText), nil
+	response, entry, err := s.callWithConfig(config, prompt, msg)
+	return response, prompt, entry, err
 }
 
-// callGrok llama a la API de Grok (xAI)
-func (s *AIProviderService) callGrok(config *AIConfigDB, systemPrompt, userMessage, realPhone string) ([]byte, error) {
-	// Obtener fecha actual en zona horaria argentina (UTC-3)
-	argLocation, _ := time.LoadLocation("America/Argentina/Buenos_Aires")
-	currentDate := time.Now().In(argLocation).Format("02/01/2006") // DD/MM/YYYY
-	currentDateTime := time.Now().In(argLocation).Format("02/01/2006 15:04") // DD/MM/YYYY HH:MM
-	
-	// Grok usa formato compatible con OpenAI
-	request := map[string]interface{}{
-		"model": config.ModelName,
-		"messages": []map[string]interface{}{
-			{
-				"role": "system",
-				"content": fmt.Sprintf("%s\n\nIMPORTANTE: Debes responder ÚNICAMENTE con un array JSON válido de cargas.", systemPrompt),
-			},
-			{
-				"role": "user",
-				"content": fmt.Sprintf("FECHA Y HORA ACTUAL (Argentina):\n- Hoy es: %s\n- Fecha y hora actual: %s\n- Zona horaria: Argentina (UTC-3)\n- IMPORTANTE: Usa esta fecha como referencia para \"hoy\", \"mañana\", etc.\n\nTeléfono del cliente: %s\n\n%s", currentDate, currentDateTime, realPhone, userMessage),
-			},
-		},
-		"temperature": 0.7,
-		"max_tokens": config.MaxTokens,
-		"response_format": map[string]string{
-			"type": "json_object",
-		},
-	}
+// callWithConfig hace la llamada y devuelve la entrada registrada en ai_call_log
+func (s *AIProviderService) callWithConfig(config *AIConfigDB, prompt *PromptTemplate, msg PromptMessage) ([]byte, AICallLogEntry, error) {
+	fmt.Printf("🤖 Usando: %s - %s (%s) | prompt %s\n", config.ProviderDisplay, config.ModelDisplay, config.Name, prompt.Label())
 	
-	jsonData, err := json.Marshal(request)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	// Buscar el proveedor registrado para este ai_providers.name (o su api_format)
+	provider, ok := ResolveProvider(config)
+	if !ok {
+		return nil, AICallLogEntry{}, fmt.Errorf("unsupported provider: %s (api_format: %s)", config.ProviderName, config.APIFormat)
 	}
 	
-	url := "https://api.x.ai/v1/chat/completions"
-	
-	fmt.Printf("🤖 [Grok] Enviando request...\n")
-	
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+	req := NewProviderRequest(prompt, msg)
+	if config.StructuredOutput {
+		req.ResponseSchema = CargaJSONSchema()
 	}
 	
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+config.APIKey)
-	
+	estimatedTokens := estimatePromptTokens(prompt, msg)
 	startTime := time.Now()
-	resp, err := s.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %v", err)
-	}
-	defer resp.Body.Close()
-	
-	elapsed := time.Since(startTime).Seconds()
-	fmt.Printf("⏱️ Respuesta recibida en %.2f segundos\n", elapsed)
-	
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %v", err)
-	}
-	
-	fmt.Printf("📥 Recibiendo respuesta de Grok... (Status: %d)\n", resp.StatusCode)
-	
-	if resp.StatusCode != http.StatusOK {
-		if resp.StatusCode == 429 {
-			fmt.Printf("🚨 ERROR 429 DETECTADO EN GROK - RATE LIMIT EXCEDIDO\n")
-			fmt.Printf("📄 Respuesta completa: %s\n", string(body))
+	response, err := provider.Call(s.client, config, req)
+	
+	// Fallback: si el proveedor/modelo rechaza el schema, reintentar en modo solo-prompt.
+	// Es un segundo request: necesita su propia reserva RPM/TPM/diaria
+	if err != nil && req.ResponseSchema != nil && isSchemaRejectedError(err) {
+		fmt.Printf("⚠️ %s rechazó el structured output, reintentando en modo solo-prompt: %v\n", config.ProviderDisplay, err)
+		if wait, limitErr := s.reserve(config, estimatedTokens); limitErr != nil {
+			err = fmt.Errorf("%v (sin presupuesto para reintentar en modo solo-prompt: %v, disponible en %s)", err, limitErr, wait.Round(time.Second))
+		} else {
+			req.ResponseSchema = nil
+			response, err = provider.Call(s.client, config, req)
 		}
-		
-		return nil, fmt.Errorf("grok API error %d: %s", resp.StatusCode, string(body))
 	}
+	latency := time.Since(startTime)
 	
-	// Parsear respuesta (formato OpenAI)
-	var grokResp struct {
-		Choices []struct {
-			Message struct {
-				Content string `json:"content"`
-			} `json:"message"`
-		} `json:"choices"`
+	// Reemplazar la estimación de la reserva por los tokens reales (entrada + salida) que informó el proveedor
+	if err == nil && response.Usage.PromptTokens+response.Usage.CompletionTokens > 0 {
+		s.limiter.Settle(config, estimatedTokens, response.Usage.PromptTokens+response.Usage.CompletionTokens)
 	}
 	
-	if err := json.Unmarshal(body, &grokResp); err != nil {
-		return nil, fmt.Errorf("failed to parse Grok response: %v", err)
+	// Registrar tokens, latencia y costo de la llamada (también las fallidas)
+	entry := AICallLogEntry{
+		ConfigID: config.ID,
+		Provider: config.ProviderName,
+		Model:    config.ModelName,
+		Latency:  latency,
+		Success:  err == nil,
 	}
-	
-	if len(grokResp.Choices) == 0 {
-		return nil, fmt.Errorf("empty response from Grok")
-	}
-	
-	responseText := grokResp.Choices[0].Message.Content
-	fmt.Printf("📦 Respuesta recibida: %d bytes\n", len(responseText))
-	
-	return []byte(responseText), nil
-}
-
-// callQwen llama a la API de Qwen (Alibaba)
-func (s *AIProviderService) callQwen(config *AIConfigDB, systemPrompt, userMessage, realPhone string) ([]byte, error) {
-	// Obtener fecha actual en zona horaria argentina (UTC-3)
-	argLocation, _ := time.LoadLocation("America/Argentina/Buenos_Aires")
-	currentDate := time.Now().In(argLocation).Format("02/01/2006") // DD/MM/YYYY
-	currentDateTime := time.Now().In(argLocation).Format("02/01/2006 15:04") // DD/MM/YYYY HH:MM
-	
-	// Qwen usa formato similar a OpenAI
-	request := map[string]interface{}{
-		"model": config.ModelName,
-		"input": map[string]interface{}{
-			"messages": []map[string]interface{}{
-				{
-					"role": "system",
-					"content": fmt.Sprintf("%s\n\nIMPORTANTE: Responde ÚNICAMENTE con JSON válido.", systemPrompt),
-				},
-				{
-					"role": "user",
-					"content": fmt.Sprintf("FECHA Y HORA ACTUAL (Argentina):\n- Hoy es: %s\n- Fecha y hora actual: %s\n- Zona horaria: Argentina (UTC-3)\n- IMPORTANTE: Usa esta fecha como referencia para \"hoy\", \"mañana\", etc.\n\nTeléfono: %s\n\n%s", currentDate, currentDateTime, realPhone, userMessage),
-				},
-			},
-		},
-		"parameters": map[string]interface{}{
-			"result_format": "message",
-			"temperature": 0.7,
-			"max_tokens": config.MaxTokens,
-		},
-	}
-	
-	jsonData, err := json.Marshal(request)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %v", err)
-	}
-	
-	url := "https://dashscope.aliyuncs.com/api/v1/services/aigc/text-generation/generation"
-	
-	fmt.Printf("🤖 [Qwen] Enviando request...\n")
-	
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+		entry.ErrorMessage = err.Error()
+	} else {
+		entry.Usage = response.Usage
+		entry.EstimatedCost = EstimateCost(config, response.Usage)
+	}
+	if !s.noAccounting {
+		if logErr := s.configManager.LogCall(entry); logErr != nil {
+			fmt.Printf("⚠️ Error registrando llamada en ai_call_log: %v\n", logErr)
+		}
 	}
 	
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+config.APIKey)
-	
-	startTime := time.Now()
-	resp, err := s.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %v", err)
+		// Reportar error
+		if !s.noAccounting {
+			s.configManager.ReportError(config.ID, err.Error())
+		}
+		return nil, entry, err
 	}
-	defer resp.Body.Close()
-	
-	elapsed := time.Since(startTime).Seconds()
-	fmt.Printf("⏱️ Respuesta recibida en %.2f segundos\n", elapsed)
 	
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %v", err)
+	// Reportar éxito
+	if !s.noAccounting {
+		s.configManager.ReportSuccess(config.ID)
 	}
+	fmt.Printf("✅ Procesamiento exitoso con %s - %s (%s) | tokens: %d+%d | costo estimado: $%.6f\n",
+		config.ProviderDisplay, config.ModelDisplay, config.Name,
+		response.Usage.PromptTokens, response.Usage.CompletionTokens, entry.EstimatedCost)
 	
-	fmt.Printf("📥 Recibiendo respuesta de Qwen... (Status: %d)\n", resp.StatusCode)
-	
-	if resp.StatusCode != http.StatusOK {
-		if resp.StatusCode == 429 {
-			fmt.Printf("🚨 ERROR 429 DETECTADO EN QWEN - RATE LIMIT EXCEDIDO\n")
-			fmt.Printf("📄 Respuesta completa: %s\n", string(body))
+	return response.Content, entry, nil
+}
+
+// retryDelayPattern extrae el "retryDelay" que Gemini incluye en el cuerpo de los 429
+var retryDelayPattern = regexp.MustCompile(`"retryDelay"\s*:\s*"(\d+(?:\.\d+)?)s"`)
+
+// rateLimitCooldown calcula cuánto tiempo pausar una config después de un rate limit
+func rateLimitCooldown(err error) time.Duration {
+	var apiErr *ProviderAPIError
+	if errors.As(err, &apiErr) {
+		if apiErr.RetryAfter > 0 {
+			return apiErr.RetryAfter
+		}
+		if match := retryDelayPattern.FindStringSubmatch(apiErr.Body); match != nil {
+			if seconds, parseErr := strconv.ParseFloat(match[1], 64); parseErr == nil && seconds > 0 {
+				return time.Duration(seconds * float64(time.Second))
+			}
 		}
-		
-		return nil, fmt.Errorf("qwen API error %d: %s", resp.StatusCode, string(body))
 	}
 	
-	// Parsear respuesta de Qwen
-	var qwenResp struct {
-		Output struct {
-			Choices []struct {
-				Message struct {
-					Content string `json:"content"`
-				} `json:"message"`
-			} `json:"choices"`
-		} `json:"output"`
+	// Cuotas diarias: no tiene sentido reintentar en un minuto
+	errStr := strings.ToLower(err.Error())
+	if strings.Contains(errStr, "per day") || strings.Contains(errStr, "perday") || strings.Contains(errStr, "daily") {
+		return dailyQuotaCooldown
 	}
 	
-	if err := json.Unmarshal(body, &qwenResp); err != nil {
-		return nil, fmt.Errorf("failed to parse Qwen response: %v", err)
+	return defaultRateLimitCooldown
+}
+
+// schemaRejectionIndicators son textos que los proveedores usan al rechazar el structured output
+// (parámetro no soportado por el modelo o schema inválido), en minúsculas
+var schemaRejectionIndicators = []string{
+	"response_format",    // OpenAI / DeepSeek / Qwen
+	"json_schema",        // OpenAI / Qwen: tipo de response_format no soportado
+	"responseschema",     // Gemini (generationConfig.responseSchema)
+	"response_schema",    // Gemini (nombre snake_case en los errores)
+	"responsemimetype",   // Gemini
+	"response_mime_type", // Gemini
+	"invalid schema",     // Schema inválido para el proveedor
+	"invalid json schema",
+	"structured output",
+	"invalid format",     // Ollama: valor de "format" no soportado
+	"chatrequest.format", // Ollama viejo: "format" solo acepta un string
+}
+
+// isSchemaRejectedError verifica si el proveedor rechazó el request por el JSON Schema: 400/422 cuyo cuerpo
+// menciona el parámetro de structured output. Otros 400 (API key, prompt demasiado largo) no se reintentan.
+func isSchemaRejectedError(err error) bool {
+	var apiErr *ProviderAPIError
+	if !errors.As(err, &apiErr) {
+		return false
 	}
-	
-	if len(qwenResp.Output.Choices) == 0 {
-		return nil, fmt.Errorf("empty response from Qwen")
+	if apiErr.StatusCode != http.StatusBadRequest && apiErr.StatusCode != http.StatusUnprocessableEntity {
+		return false
 	}
 	
-	responseText := qwenResp.Output.Choices[0].Message.Content
-	fmt.Printf("📦 Respuesta recibida: %d bytes\n", len(responseText))
-	
-	return []byte(responseText), nil
+	body := strings.ToLower(apiErr.Body)
+	for _, indicator := range schemaRejectionIndicators {
+		if strings.Contains(body, indicator) {
+			return true
+		}
+	}
+	return false
 }
 
 // isRateLimitError verifica si un error es por rate limiting
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestIsSchemaRejectedError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "OpenAI: modelo sin json_schema",
+			err:  &ProviderAPIError{Provider: "openai", StatusCode: 400, Body: `{"error":{"message":"Invalid parameter: 'response_format' of type 'json_schema' is not supported with this model.","param":"response_format"}}`},
+			want: true,
+		},
+		{
+			name: "Gemini: responseSchema desconocido",
+			err:  &ProviderAPIError{Provider: "gemini", StatusCode: 400, Body: `{"error":{"message":"Invalid JSON payload received. Unknown name \"responseSchema\" at 'generation_config': Cannot find field."}}`},
+			want: true,
+		},
+		{
+			name: "Ollama: format inválido",
+			err:  &ProviderAPIError{Provider: "ollama", StatusCode: 400, Body: `{"error":"invalid format: expected \"json\" or a JSON schema"}`},
+			want: true,
+		},
+		{
+			name: "422 por schema inválido",
+			err:  &ProviderAPIError{Provider: "grok", StatusCode: 422, Body: `Invalid schema: additionalProperties is required`},
+			want: true,
+		},
+		{
+			name: "error envuelto",
+			err:  fmt.Errorf("llamada fallida: %w", &ProviderAPIError{Provider: "openai", StatusCode: 400, Body: `response_format unsupported`}),
+			want: true,
+		},
+		{
+			name: "400 por API key",
+			err:  &ProviderAPIError{Provider: "gemini", StatusCode: 400, Body: `{"error":{"message":"API key not valid. Please pass a valid API key."}}`},
+		},
+		{
+			name: "400 por prompt demasiado largo",
+			err:  &ProviderAPIError{Provider: "openai", StatusCode: 400, Body: `{"error":{"message":"This model's maximum context length is 8192 tokens.","code":"context_length_exceeded"}}`},
+		},
+		{
+			name: "500 que menciona el schema",
+			err:  &ProviderAPIError{Provider: "openai", StatusCode: 500, Body: `error processing response_format`},
+		},
+		{
+			name: "error de red",
+			err:  errors.New("failed to send request: response_format"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSchemaRejectedError(tt.err); got != tt.want {
+				t.Errorf("isSchemaRejectedError = %v, se esperaba %v", got, tt.want)
+			}
+		})
+	}
+}
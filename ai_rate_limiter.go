@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// tokenBucket es un token bucket clásico: capacity tokens que se recargan de forma continua
+// a razón de capacity por minuto
+type tokenBucket struct {
+	capacity   float64
+	tokens     float64
+	lastRefill time.Time
+}
+
+func newTokenBucket(perMinute int, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		capacity:   float64(perMinute),
+		tokens:     float64(perMinute),
+		lastRefill: now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.lastRefill).Minutes()
+	if elapsed <= 0 {
+		return
+	}
+	b.tokens += elapsed * b.capacity
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.lastRefill = now
+}
+
+// waitFor devuelve cuánto falta para tener n tokens disponibles (0 si ya están)
+func (b *tokenBucket) waitFor(n float64) time.Duration {
+	if n > b.capacity {
+		// Un pedido más grande que el bucket nunca entraría: se permite con el bucket lleno
+		n = b.capacity
+	}
+	if b.tokens >= n {
+		return 0
+	}
+	missing := n - b.tokens
+	return time.Duration(missing / b.capacity * float64(time.Minute))
+}
+
+// configLimiter agrupa los límites de una ai_config
+type configLimiter struct {
+	rpmLimit int
+	tpmLimit int
+	rpm      *tokenBucket
+	tpm      *tokenBucket
+}
+
+// AIRateLimiter aplica los límites RPM/TPM de cada ai_config antes de llamar al proveedor,
+// para cambiar de config antes de recibir un 429.
+// El límite diario no vive acá: se reserva en la BD (AIConfigManager.ReserveDailyRequest) para que
+// todas las instancias compartan el mismo cupo.
+type AIRateLimiter struct {
+	mu       sync.Mutex
+	limiters map[int]*configLimiter
+}
+
+// ConfigBudget es el presupuesto restante de una configuración (-1 = sin límite)
+type ConfigBudget struct {
+	ConfigID          int `json:"config_id"`
+	RPMLimit          int `json:"rpm_limit"`
+	RPMRemaining      int `json:"rpm_remaining"`
+	TPMLimit          int `json:"tpm_limit"`
+	TPMRemaining      int `json:"tpm_remaining"`
+	DailyRequestLimit int `json:"daily_request_limit"`
+	DailyRemaining    int `json:"daily_remaining"`
+	RequestsToday     int `json:"requests_today"`
+}
+
+// NewAIRateLimiter crea un limitador vacío; los buckets se crean al ver cada config
+func NewAIRateLimiter() *AIRateLimiter {
+	return &AIRateLimiter{
+		limiters: make(map[int]*configLimiter),
+	}
+}
+
+// limiterFor obtiene (o crea) el limitador de una config. Si cambiaron los límites, se recrean los buckets.
+// NOTA: debe llamarse con l.mu tomado
+func (l *AIRateLimiter) limiterFor(config *AIConfigDB, now time.Time) *configLimiter {
+	cl, ok := l.limiters[config.ID]
+	if !ok {
+		cl = &configLimiter{}
+		l.limiters[config.ID] = cl
+	}
+
+	if cl.rpmLimit != config.RPMLimit {
+		cl.rpmLimit = config.RPMLimit
+		cl.rpm = nil
+		if config.RPMLimit > 0 {
+			cl.rpm = newTokenBucket(config.RPMLimit, now)
+		}
+	}
+	if cl.tpmLimit != config.TPMLimit {
+		cl.tpmLimit = config.TPMLimit
+		cl.tpm = nil
+		if config.TPMLimit > 0 {
+			cl.tpm = newTokenBucket(config.TPMLimit, now)
+		}
+	}
+
+	if cl.rpm != nil {
+		cl.rpm.refill(now)
+	}
+	if cl.tpm != nil {
+		cl.tpm.refill(now)
+	}
+
+	return cl
+}
+
+// Reserve intenta consumir 1 request y estimatedTokens tokens del presupuesto RPM/TPM de la config.
+// Si algún límite no alcanza, no consume nada y devuelve cuánto esperar y qué límite se alcanzó.
+func (l *AIRateLimiter) Reserve(config *AIConfigDB, estimatedTokens int) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cl := l.limiterFor(config, time.Now())
+
+	if cl.rpm != nil {
+		if wait := cl.rpm.waitFor(1); wait > 0 {
+			return wait, fmt.Errorf("límite RPM alcanzado (%d requests/minuto)", config.RPMLimit)
+		}
+	}
+
+	if cl.tpm != nil {
+		if wait := cl.tpm.waitFor(float64(estimatedTokens)); wait > 0 {
+			return wait, fmt.Errorf("límite TPM alcanzado (%d tokens/minuto, se necesitan ~%d)", config.TPMLimit, estimatedTokens)
+		}
+	}
+
+	// Todos los límites alcanzan: consumir
+	if cl.rpm != nil {
+		cl.rpm.tokens--
+	}
+	if cl.tpm != nil {
+		cl.tpm.tokens -= float64(estimatedTokens)
+		if cl.tpm.tokens < 0 {
+			cl.tpm.tokens = 0
+		}
+	}
+
+	return 0, nil
+}
+
+// Release devuelve al presupuesto RPM/TPM una reserva que no llegó a usarse
+// (ej: la BD rechazó el request por el límite diario)
+func (l *AIRateLimiter) Release(config *AIConfigDB, estimatedTokens int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cl := l.limiterFor(config, time.Now())
+	if cl.rpm != nil {
+		cl.rpm.tokens = min(cl.rpm.tokens+1, cl.rpm.capacity)
+	}
+	if cl.tpm != nil {
+		cl.tpm.tokens = min(cl.tpm.tokens+float64(estimatedTokens), cl.tpm.capacity)
+	}
+}
+
+// Settle reemplaza en el bucket TPM la estimación descontada en Reserve por los tokens reales de la llamada
+// (prompt + completion). Si la llamada usó más de lo estimado, el bucket queda en negativo y las próximas reservas esperan.
+func (l *AIRateLimiter) Settle(config *AIConfigDB, estimatedTokens, actualTokens int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cl := l.limiterFor(config, time.Now())
+	if cl.tpm == nil {
+		return
+	}
+
+	cl.tpm.tokens += float64(estimatedTokens - actualTokens)
+	if cl.tpm.tokens > cl.tpm.capacity {
+		cl.tpm.tokens = cl.tpm.capacity
+	}
+}
+
+// Budget devuelve el presupuesto restante de una config. El uso diario sale del contador de la BD (config.RequestsToday).
+func (l *AIRateLimiter) Budget(config *AIConfigDB) ConfigBudget {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cl := l.limiterFor(config, time.Now())
+
+	budget := ConfigBudget{
+		ConfigID:          config.ID,
+		RPMLimit:          config.RPMLimit,
+		RPMRemaining:      -1,
+		TPMLimit:          config.TPMLimit,
+		TPMRemaining:      -1,
+		DailyRequestLimit: config.DailyRequestLimit,
+		DailyRemaining:    -1,
+		RequestsToday:     config.RequestsToday,
+	}
+
+	if cl.rpm != nil {
+		budget.RPMRemaining = int(cl.rpm.tokens)
+	}
+	if cl.tpm != nil {
+		budget.TPMRemaining = int(cl.tpm.tokens)
+		if budget.TPMRemaining < 0 {
+			budget.TPMRemaining = 0
+		}
+	}
+	if config.DailyRequestLimit > 0 {
+		budget.DailyRemaining = config.DailyRequestLimit - config.RequestsToday
+		if budget.DailyRemaining < 0 {
+			budget.DailyRemaining = 0
+		}
+	}
+
+	return budget
+}
+
+// estimatePromptTokens estima los tokens de entrada de un mensaje con su template del prompt
+func estimatePromptTokens(prompt *PromptTemplate, msg PromptMessage) int {
+	return estimateTokens(prompt.SystemTemplate) + estimateTokens(prompt.UserTemplate) + estimateTokens(msg.Content)
+}
+
+// estimateTokens estima los tokens de un texto (~4 caracteres por token)
+func estimateTokens(text string) int {
+	return len(text)/4 + 1
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAIRateLimiterReserve(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      AIConfigDB
+		tokens      []int // Tokens estimados de cada reserva, en orden
+		wantErr     string
+		wantMinWait time.Duration // Espera mínima devuelta por la última reserva
+	}{
+		{name: "sin límites", config: AIConfigDB{ID: 1}, tokens: []int{1000, 1000, 1000}},
+		{name: "dentro del RPM", config: AIConfigDB{ID: 1, RPMLimit: 3}, tokens: []int{10, 10, 10}},
+		{name: "RPM agotado", config: AIConfigDB{ID: 1, RPMLimit: 2}, tokens: []int{10, 10, 10}, wantErr: "límite RPM", wantMinWait: 25 * time.Second},
+		{name: "TPM agotado", config: AIConfigDB{ID: 1, TPMLimit: 1000}, tokens: []int{600, 600}, wantErr: "límite TPM", wantMinWait: 10 * time.Second},
+		{name: "pedido mayor que el bucket con el bucket lleno", config: AIConfigDB{ID: 1, TPMLimit: 1000}, tokens: []int{5000}},
+		{name: "pedido mayor que el bucket después de otro", config: AIConfigDB{ID: 1, TPMLimit: 1000}, tokens: []int{100, 5000}, wantErr: "límite TPM"},
+		{name: "el límite diario no se cuenta en memoria", config: AIConfigDB{ID: 1, DailyRequestLimit: 1, RequestsToday: 5}, tokens: []int{10, 10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewAIRateLimiter()
+
+			var wait time.Duration
+			var err error
+			for i, tokens := range tt.tokens {
+				wait, err = limiter.Reserve(&tt.config, tokens)
+				if i < len(tt.tokens)-1 && err != nil {
+					t.Fatalf("reserva %d: %v", i+1, err)
+				}
+			}
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Reserve: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, se esperaba %q", err, tt.wantErr)
+			}
+			if wait < tt.wantMinWait {
+				t.Errorf("wait = %s, se esperaba al menos %s", wait, tt.wantMinWait)
+			}
+		})
+	}
+}
+
+func TestAIRateLimiterRelease(t *testing.T) {
+	limiter := NewAIRateLimiter()
+	config := &AIConfigDB{ID: 1, RPMLimit: 1, TPMLimit: 1000}
+
+	if _, err := limiter.Reserve(config, 800); err != nil {
+		t.Fatalf("Reserve: %v", err)
+	}
+	if _, err := limiter.Reserve(config, 800); err == nil {
+		t.Fatalf("la segunda reserva debería superar el RPM")
+	}
+
+	// La BD rechazó el request por el límite diario: el presupuesto vuelve al bucket
+	limiter.Release(config, 800)
+	if _, err := limiter.Reserve(config, 800); err != nil {
+		t.Errorf("después de Release la reserva debería entrar: %v", err)
+	}
+}
+
+func TestAIRateLimiterBudget(t *testing.T) {
+	limiter := NewAIRateLimiter()
+	config := &AIConfigDB{ID: 7, RPMLimit: 10, TPMLimit: 1000, DailyRequestLimit: 50, RequestsToday: 48}
+
+	if _, err := limiter.Reserve(config, 300); err != nil {
+		t.Fatalf("Reserve: %v", err)
+	}
+
+	budget := limiter.Budget(config)
+	if budget.RPMRemaining != 9 || budget.TPMRemaining != 700 {
+		t.Errorf("RPM/TPM restantes = %d/%d, se esperaba 9/700", budget.RPMRemaining, budget.TPMRemaining)
+	}
+	if budget.RequestsToday != 48 || budget.DailyRemaining != 2 {
+		t.Errorf("diario = %d usados, %d restantes; se esperaba 48 y 2 (contador de la BD)", budget.RequestsToday, budget.DailyRemaining)
+	}
+
+	unlimited := limiter.Budget(&AIConfigDB{ID: 8})
+	if unlimited.RPMRemaining != -1 || unlimited.TPMRemaining != -1 || unlimited.DailyRemaining != -1 {
+		t.Errorf("sin límites se esperaba -1 en todo: %+v", unlimited)
+	}
+}
+
+func TestAIRateLimiterSettle(t *testing.T) {
+	tests := []struct {
+		name          string
+		estimated     int
+		actual        int
+		wantRemaining int
+	}{
+		{name: "usó lo estimado", estimated: 400, actual: 400, wantRemaining: 600},
+		{name: "usó menos: se devuelve la diferencia", estimated: 400, actual: 100, wantRemaining: 900},
+		{name: "usó más: se descuenta la diferencia", estimated: 400, actual: 700, wantRemaining: 300},
+		{name: "usó más que el bucket: queda en cero para la UI", estimated: 400, actual: 1500, wantRemaining: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewAIRateLimiter()
+			config := &AIConfigDB{ID: 1, TPMLimit: 1000}
+
+			if _, err := limiter.Reserve(config, tt.estimated); err != nil {
+				t.Fatalf("Reserve: %v", err)
+			}
+			limiter.Settle(config, tt.estimated, tt.actual)
+
+			if got := limiter.Budget(config).TPMRemaining; got != tt.wantRemaining {
+				t.Errorf("TPMRemaining = %d, se esperaba %d", got, tt.wantRemaining)
+			}
+		})
+	}
+}
@@ -44,7 +44,15 @@ type GeminiGenerationConfig struct {
 
 // GeminiResponse estructura de respuesta de Gemini
 type GeminiResponse struct {
-	Candidates []GeminiCandidate `json:"candidates"`
+	Candidates    []GeminiCandidate   `json:"candidates"`
+	UsageMetadata GeminiUsageMetadata `json:"usageMetadata"`
+}
+
+// GeminiUsageMetadata representa los tokens consumidos en una llamada
+type GeminiUsageMetadata struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	TotalTokenCount      int `json:"totalTokenCount"`
 }
 
 // GeminiCandidate representa una respuesta candidata
@@ -78,7 +86,7 @@ func NewAIService(keysManager *APIKeysManager) (*AIService, error) {
 // loadSystemPrompt carga el prompt desde el archivo de contexto
 func loadSystemPrompt() (string, error) {
 	// Intentar leer desde el archivo de contexto
-	promptBytes, err := os.ReadFile("contecto_funcionalidad_ia.md")
+	promptBytes, err := os.ReadFile(systemPromptFile)
 	if err != nil {
 		// Si no se puede leer el archivo, usar un prompt básico
 		return getBasicPrompt(), nil
@@ -108,13 +116,13 @@ func getBasicPrompt() string {
 		"9. Precio siempre como string en pesos argentinos\n" +
 		"10. Si no se especifica fecha, usar la fecha actual\n\n" +
 		"## MATERIALES VÁLIDOS\n" +
-		"Agroquímicos, Alimentos y bebidas, Fertilizante, Ganado, Girasol, Maiz, Maquinarias, Materiales construcción, Otras cargas generales, Otros cultivos, Refrigerados, Soja, Trigo\n\n" +
+		promptVarMateriales + "\n\n" +
 		"## PRESENTACIONES VÁLIDAS\n" +
-		"Big Bag, Bolsa, Granel, Otros, Pallet\n\n" +
+		promptVarPresentaciones + "\n\n" +
 		"## TIPOS DE EQUIPO VÁLIDOS\n" +
-		"Batea, Camioneta, CamionJaula, Carreton, Chasis y Acoplado, Furgon, Otros, Semi, Tolva\n\n" +
+		promptVarTiposEquipo + "\n\n" +
 		"## FORMAS DE PAGO VÁLIDAS\n" +
-		"Cheque, E-check, Efectivo, Otros, Transferencia"
+		promptVarFormasPago
 }
 
 // ProcessMessage procesa un mensaje con IA agregando el teléfono real
@@ -133,7 +141,8 @@ func (s *AIService) processMessageWithRetry(content string, realPhone string, re
 	messageWithAlt := fmt.Sprintf("%s\n\nALT: %s", content, realPhone)
 	
 	// Construir prompt completo con instrucción explícita de NO usar markdown
-	fullPrompt := fmt.Sprintf("%s\n\n**IMPORTANTE: Responde ÚNICAMENTE con el array JSON, SIN usar bloques de código markdown (```), SIN backticks, SIN explicaciones. Solo el JSON puro.**\n\nMENSAJE:\n%s", s.systemPrompt, messageWithAlt)
+	systemPrompt := strings.NewReplacer(catalogPromptReplacements()...).Replace(s.systemPrompt)
+	fullPrompt := fmt.Sprintf("%s\n\n**IMPORTANTE: Responde ÚNICAMENTE con el array JSON, SIN usar bloques de código markdown (```), SIN backticks, SIN explicaciones. Solo el JSON puro.**\n\nMENSAJE:\n%s", systemPrompt, messageWithAlt)
 	
 	// Crear solicitud para Gemini
 	request := GeminiRequest{
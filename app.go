@@ -3,6 +3,9 @@ package main
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/wailsapp/wails/v2/pkg/runtime"
@@ -11,11 +14,15 @@ import (
 
 // App struct
 type App struct {
-	ctx              context.Context
-	waService        *WhatsAppService
-	facebookService  *FacebookService
-	messageProcessor *MessageProcessor
-	qrCode           string
+	ctx               context.Context
+	waService         *WhatsAppService
+	facebookService   *FacebookService
+	messageProcessor  *MessageProcessor
+	qrCode            string
+	stopPromptWatcher func()
+	stopOutbox        func() // Detiene el dispatcher del outbox de Supabase
+	processingMu      sync.Mutex
+	cancelProcessing  context.CancelFunc // Cancela el ProcessMessages en curso
 }
 
 // SenderInfo representa información de un remitente (alias para frontend)
@@ -92,9 +99,29 @@ func (a *App) InitWhatsApp() error {
 		runtime.EventsEmit(a.ctx, "logged-out", reason)
 	}
 
+	// Recargar el prompt al editar el archivo de contexto, sin reiniciar ni re-escanear el QR
+	if a.messageProcessor != nil && a.stopPromptWatcher == nil {
+		a.stopPromptWatcher = a.messageProcessor.promptTemplates.WatchFile(systemPromptFile, a.emitPromptReload)
+	}
+
+	// Publicar en Supabase las cargas que quedaron en el outbox (también las de una sesión anterior)
+	if a.messageProcessor != nil && a.stopOutbox == nil {
+		a.stopOutbox = a.messageProcessor.StartOutboxDispatcher()
+	}
+
 	return nil
 }
 
+// emitPromptReload informa a la UI el resultado de una recarga del prompt
+func (a *App) emitPromptReload(result PromptReloadResult) {
+	if result.Success {
+		runtime.LogInfo(a.ctx, fmt.Sprintf("Prompt recargado (%s, versión activa: %s)", result.Source, result.Version))
+	} else {
+		runtime.LogWarning(a.ctx, fmt.Sprintf("Error recargando prompt: %s", result.Error))
+	}
+	runtime.EventsEmit(a.ctx, "prompt-reloaded", result)
+}
+
 // ConnectWhatsApp conecta al servicio de WhatsApp
 func (a *App) ConnectWhatsApp() error {
 	if a.waService == nil {
@@ -341,7 +368,33 @@ func (a *App) ProcessMessages(limit int) ([]ProcessingResult, error) {
 		return nil, fmt.Errorf("message processor not initialized")
 	}
 
-	return a.messageProcessor.ProcessPendingMessages(limit)
+	ctx, cancel := context.WithCancel(a.ctx)
+	a.processingMu.Lock()
+	if a.cancelProcessing != nil {
+		a.processingMu.Unlock()
+		cancel()
+		return nil, fmt.Errorf("ya hay un procesamiento en curso")
+	}
+	a.cancelProcessing = cancel
+	a.processingMu.Unlock()
+
+	defer func() {
+		a.processingMu.Lock()
+		a.cancelProcessing = nil
+		a.processingMu.Unlock()
+		cancel()
+	}()
+
+	return a.messageProcessor.ProcessPendingMessages(ctx, limit)
+}
+
+// CancelProcessing detiene el ProcessMessages en curso: los mensajes que ya se están procesando terminan y se guardan
+func (a *App) CancelProcessing() {
+	a.processingMu.Lock()
+	defer a.processingMu.Unlock()
+	if a.cancelProcessing != nil {
+		a.cancelProcessing()
+	}
 }
 
 // GetProcessingResults obtiene resultados de procesamiento
@@ -385,6 +438,19 @@ func (a *App) SimulateMessage(messageContent, realPhone string) (ProcessingResul
 	return result, nil
 }
 
+// CompareModels procesa el mismo mensaje con varias configuraciones de IA en paralelo (simulador, sin guardar)
+func (a *App) CompareModels(messageContent, realPhone string, configIDs []int) (*ModelComparison, error) {
+	if a.messageProcessor == nil {
+		return nil, fmt.Errorf("message processor not initialized")
+	}
+
+	if realPhone == "" {
+		realPhone = "+5490000000000" // Teléfono por defecto para simulación
+	}
+
+	return a.messageProcessor.CompareModels(messageContent, realPhone, configIDs)
+}
+
 // ===== FUNCIONES PARA GESTIÓN DE API KEYS =====
 
 // GetGeminiKeys obtiene todas las API keys de Gemini configuradas
@@ -508,6 +574,433 @@ func (a *App) GetMessagesWithErrors(limit int) ([]ProcessingResult, error) {
 	return a.waService.messageProcessor.GetMessagesWithErrors(limit)
 }
 
+// ===== REVISIÓN DE CARGAS =====
+
+// GetPendingCargas obtiene las cargas de la cola de revisión con un estado (needs_review, approved, rejected)
+func (a *App) GetPendingCargas(status string, limit int) ([]PendingCarga, error) {
+	if a.waService == nil {
+		return nil, fmt.Errorf("WhatsApp service not initialized")
+	}
+	return a.waService.messageStore.GetPendingCargas(status, limit)
+}
+
+// GetPendingCargaCount cuenta las cargas que esperan revisión
+func (a *App) GetPendingCargaCount() (int, error) {
+	if a.waService == nil {
+		return 0, fmt.Errorf("WhatsApp service not initialized")
+	}
+	return a.waService.messageStore.GetPendingCargaCount()
+}
+
+// UpdatePendingCarga guarda las correcciones del operador a una carga en revisión
+func (a *App) UpdatePendingCarga(id int, carga CargaData) error {
+	if a.waService == nil {
+		return fmt.Errorf("WhatsApp service not initialized")
+	}
+	return a.waService.messageStore.UpdatePendingCarga(id, carga)
+}
+
+// ApprovePendingCarga aprueba una carga en revisión y la publica en Supabase
+func (a *App) ApprovePendingCarga(id int) (string, error) {
+	if a.waService == nil || a.waService.messageProcessor == nil {
+		return "", fmt.Errorf("message processor not initialized")
+	}
+	return a.waService.messageProcessor.ApprovePendingCarga(id)
+}
+
+// RejectPendingCarga rechaza una carga en revisión
+func (a *App) RejectPendingCarga(id int, reason string) error {
+	if a.waService == nil || a.waService.messageProcessor == nil {
+		return fmt.Errorf("message processor not initialized")
+	}
+	return a.waService.messageProcessor.RejectPendingCarga(id, reason)
+}
+
+// GetReviewSettings obtiene la configuración del modo revisión
+func (a *App) GetReviewSettings() (*ReviewSettings, error) {
+	if a.waService == nil || a.waService.systemConfigManager == nil {
+		return nil, fmt.Errorf("system config manager not initialized")
+	}
+
+	rules, err := a.waService.messageStore.GetReviewGroupRules()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReviewSettings{
+		Enabled:        a.waService.systemConfigManager.GetReviewEnabled(),
+		TrustThreshold: a.waService.systemConfigManager.GetReviewTrustThreshold(),
+		GroupRules:     rules,
+	}, nil
+}
+
+// SaveReviewSettings activa/desactiva el modo revisión y fija la confianza mínima para publicar sin revisión
+func (a *App) SaveReviewSettings(enabled bool, trustThreshold int) error {
+	if a.waService == nil || a.waService.systemConfigManager == nil {
+		return fmt.Errorf("system config manager not initialized")
+	}
+
+	if err := a.waService.systemConfigManager.SetConfig("review_enabled", strconv.FormatBool(enabled)); err != nil {
+		return err
+	}
+	return a.waService.systemConfigManager.SetConfig("review_trust_threshold", strconv.Itoa(trustThreshold))
+}
+
+// SetReviewGroupRule fuerza (always) u omite (never) la revisión para un grupo; mode = "" elimina la regla
+func (a *App) SetReviewGroupRule(chatJID, mode string) error {
+	if a.waService == nil {
+		return fmt.Errorf("WhatsApp service not initialized")
+	}
+	return a.waService.messageStore.SetReviewGroupRule(chatJID, mode)
+}
+
+// ===== CATÁLOGOS DE SUPABASE =====
+
+// GetCatalogSettings devuelve los catálogos vigentes (origen, cantidad, última sincronización), su TTL,
+// el umbral de coincidencia y los sinónimos
+func (a *App) GetCatalogSettings() (*CatalogSettings, error) {
+	if a.waService == nil || a.waService.systemConfigManager == nil {
+		return nil, fmt.Errorf("system config manager not initialized")
+	}
+	synonyms, err := a.waService.messageStore.GetCatalogSynonyms()
+	if err != nil {
+		return nil, err
+	}
+	return &CatalogSettings{
+		TTLHours:       int(a.waService.systemConfigManager.GetCatalogTTL().Hours()),
+		MatchThreshold: int(a.waService.systemConfigManager.GetCatalogMatchThreshold()*100 + 0.5),
+		Catalogs:       catalogs.Status(),
+		Synonyms:       synonyms,
+	}, nil
+}
+
+// SyncCatalogs vuelve a pedir los catálogos a Supabase sin esperar a que venza el TTL
+func (a *App) SyncCatalogs() ([]CatalogStatus, error) {
+	if a.waService == nil || a.waService.messageProcessor == nil {
+		return nil, fmt.Errorf("message processor not initialized")
+	}
+	return a.waService.messageProcessor.SyncCatalogs()
+}
+
+// SaveCatalogTTL guarda cada cuántas horas se sincronizan los catálogos
+func (a *App) SaveCatalogTTL(hours int) error {
+	if a.waService == nil || a.waService.systemConfigManager == nil {
+		return fmt.Errorf("system config manager not initialized")
+	}
+	if hours < 1 {
+		return fmt.Errorf("el TTL debe ser de al menos 1 hora")
+	}
+	return a.waService.systemConfigManager.SetConfig("catalog_ttl_hours", strconv.Itoa(hours))
+}
+
+// SaveCatalogMatchThreshold guarda la confianza mínima (%) para aceptar un valor de catálogo aproximado sin revisión
+func (a *App) SaveCatalogMatchThreshold(percent int) error {
+	if a.waService == nil || a.waService.systemConfigManager == nil {
+		return fmt.Errorf("system config manager not initialized")
+	}
+	if percent < 1 || percent > 100 {
+		return fmt.Errorf("el umbral debe estar entre 1 y 100")
+	}
+	return a.waService.systemConfigManager.SetConfig("catalog_match_threshold", strconv.Itoa(percent))
+}
+
+// SaveCatalogSynonym crea o reemplaza un sinónimo de un valor del catálogo
+func (a *App) SaveCatalogSynonym(kind, synonym, value string) error {
+	if a.waService == nil || a.waService.messageProcessor == nil {
+		return fmt.Errorf("message processor not initialized")
+	}
+	return a.waService.messageProcessor.SaveCatalogSynonym(kind, synonym, value)
+}
+
+// DeleteCatalogSynonym elimina un sinónimo
+func (a *App) DeleteCatalogSynonym(id int) error {
+	if a.waService == nil || a.waService.messageProcessor == nil {
+		return fmt.Errorf("message processor not initialized")
+	}
+	return a.waService.messageProcessor.DeleteCatalogSynonym(id)
+}
+
+// ===== DADORES DE SUPABASE =====
+
+// GetDadorSettings devuelve el dador por defecto y las asignaciones por remitente y por grupo
+func (a *App) GetDadorSettings() (*DadorSettings, error) {
+	if a.waService == nil || a.waService.systemConfigManager == nil {
+		return nil, fmt.Errorf("system config manager not initialized")
+	}
+	mappings, err := a.waService.messageStore.GetDadorMappings()
+	if err != nil {
+		return nil, err
+	}
+	return &DadorSettings{
+		DefaultDadorID: a.waService.systemConfigManager.GetDefaultDadorID(),
+		Mappings:       mappings,
+	}, nil
+}
+
+// SaveDefaultDador guarda el dador de las cargas cuyo remitente o grupo no tiene uno asignado
+func (a *App) SaveDefaultDador(dadorID string) error {
+	if a.waService == nil || a.waService.systemConfigManager == nil {
+		return fmt.Errorf("system config manager not initialized")
+	}
+	dadorID = strings.TrimSpace(dadorID)
+	if dadorID == "" {
+		return fmt.Errorf("falta el ID del dador")
+	}
+	return a.waService.systemConfigManager.SetConfig("supabase_default_dador_id", dadorID)
+}
+
+// SaveDadorMapping asigna un dador de Supabase a un remitente (phone) o a un grupo (group)
+func (a *App) SaveDadorMapping(matchType, matchValue, dadorID, nombre string) error {
+	if a.waService == nil {
+		return fmt.Errorf("WhatsApp service not initialized")
+	}
+	if err := validateDadorMapping(matchType, matchValue, dadorID); err != nil {
+		return err
+	}
+	return a.waService.messageStore.SaveDadorMapping(matchType, strings.TrimSpace(matchValue), strings.TrimSpace(dadorID), strings.TrimSpace(nombre))
+}
+
+// DeleteDadorMapping elimina una asignación de dador
+func (a *App) DeleteDadorMapping(id int) error {
+	if a.waService == nil {
+		return fmt.Errorf("WhatsApp service not initialized")
+	}
+	return a.waService.messageStore.DeleteDadorMapping(id)
+}
+
+// CreateDadorForPhone crea el dador de un loader en Supabase (o reutiliza el que tiene su teléfono) y se lo asigna
+func (a *App) CreateDadorForPhone(realPhone, nombre string) (*DadorMapping, error) {
+	if a.waService == nil || a.waService.messageProcessor == nil {
+		return nil, fmt.Errorf("message processor not initialized")
+	}
+	return a.waService.messageProcessor.CreateDadorForPhone(realPhone, nombre)
+}
+
+// ===== CACHÉ DE GEOCODING =====
+
+// GetGeocodeCacheStats cuenta las direcciones en caché (resueltas, sin resultados, vencidas)
+func (a *App) GetGeocodeCacheStats() (*GeocodeCacheStats, error) {
+	if a.waService == nil || a.waService.systemConfigManager == nil {
+		return nil, fmt.Errorf("system config manager not initialized")
+	}
+	return NewGeocodeCache(a.waService.systemConfigManager).Stats()
+}
+
+// GetGeocodeCacheEntries busca direcciones en la caché de geocoding
+func (a *App) GetGeocodeCacheEntries(search string, limit int) ([]GeocodeCacheEntry, error) {
+	if a.waService == nil || a.waService.systemConfigManager == nil {
+		return nil, fmt.Errorf("system config manager not initialized")
+	}
+	return NewGeocodeCache(a.waService.systemConfigManager).List(search, limit)
+}
+
+// DeleteGeocodeCacheEntry borra una dirección de la caché para que se vuelva a buscar
+func (a *App) DeleteGeocodeCacheEntry(addressKey string) error {
+	if a.waService == nil || a.waService.systemConfigManager == nil {
+		return fmt.Errorf("system config manager not initialized")
+	}
+	return NewGeocodeCache(a.waService.systemConfigManager).Delete(addressKey)
+}
+
+// ClearGeocodeNotFound borra las direcciones sin resultados para que se reintenten ya
+func (a *App) ClearGeocodeNotFound() (int64, error) {
+	if a.waService == nil || a.waService.systemConfigManager == nil {
+		return 0, fmt.Errorf("system config manager not initialized")
+	}
+	return NewGeocodeCache(a.waService.systemConfigManager).ClearNotFound()
+}
+
+// SaveGeocodeNegativeTTL guarda cuántas horas se recuerda que una dirección no tiene resultados
+func (a *App) SaveGeocodeNegativeTTL(hours int) error {
+	if a.waService == nil || a.waService.systemConfigManager == nil {
+		return fmt.Errorf("system config manager not initialized")
+	}
+	if hours < 1 {
+		return fmt.Errorf("el TTL debe ser de al menos 1 hora")
+	}
+	return a.waService.systemConfigManager.SetConfig("geocode_negative_ttl_hours", strconv.Itoa(hours))
+}
+
+// ===== NOMENCLADOR DE LOCALIDADES =====
+
+// GetGazetteerStatus cuenta las provincias, departamentos y localidades cargados en el nomenclador
+func (a *App) GetGazetteerStatus() (*GazetteerStatus, error) {
+	if a.waService == nil || a.waService.systemConfigManager == nil {
+		return nil, fmt.Errorf("system config manager not initialized")
+	}
+	return NewGazetteer(a.waService.systemConfigManager).Status()
+}
+
+// ReloadGazetteer carga el nomenclador desde un archivo o directorio de CSV ("" = dataset incluido en la app).
+// Si no se puede cargar, se conserva el origen anterior.
+func (a *App) ReloadGazetteer(path string) (*GazetteerStatus, error) {
+	if a.waService == nil || a.waService.systemConfigManager == nil {
+		return nil, fmt.Errorf("system config manager not initialized")
+	}
+	scm := a.waService.systemConfigManager
+	previous := scm.GetGazetteerPath()
+
+	if err := scm.SetConfig("gazetteer_path", strings.TrimSpace(path)); err != nil {
+		return nil, err
+	}
+	status, err := NewGazetteer(scm).Load(true)
+	if err != nil {
+		if restoreErr := scm.SetConfig("gazetteer_path", previous); restoreErr != nil {
+			fmt.Printf("⚠️ Error restaurando gazetteer_path: %v\n", restoreErr)
+		}
+		return nil, err
+	}
+	return status, nil
+}
+
+// SaveGazetteerValidation activa o desactiva el rechazo de cargas con localidades fuera del nomenclador
+func (a *App) SaveGazetteerValidation(enabled bool) error {
+	if a.waService == nil || a.waService.systemConfigManager == nil {
+		return fmt.Errorf("system config manager not initialized")
+	}
+	return a.waService.systemConfigManager.SetConfig("gazetteer_validation", strconv.FormatBool(enabled))
+}
+
+// ResolveGazetteerPlace busca una dirección en el nomenclador (para probar desde Configuración)
+func (a *App) ResolveGazetteerPlace(direccion string) (*GazetteerPlace, error) {
+	if a.waService == nil || a.waService.systemConfigManager == nil {
+		return nil, fmt.Errorf("system config manager not initialized")
+	}
+	return NewGazetteer(a.waService.systemConfigManager).Resolve(direccion)
+}
+
+// ===== OUTBOX DE SUPABASE =====
+
+// GetOutboxEntries obtiene las cargas extraídas que esperan (o terminaron) su publicación en Supabase
+func (a *App) GetOutboxEntries(status string, limit int) ([]OutboxEntry, error) {
+	if a.waService == nil {
+		return nil, fmt.Errorf("WhatsApp service not initialized")
+	}
+	return a.waService.messageStore.GetOutboxEntries(status, limit)
+}
+
+// GetOutboxCount cuenta las entradas del outbox sin publicar (pendientes y fallidas)
+func (a *App) GetOutboxCount() (int, error) {
+	if a.waService == nil {
+		return 0, fmt.Errorf("WhatsApp service not initialized")
+	}
+	return a.waService.messageStore.GetOutboxCount()
+}
+
+// RetryOutboxEntry vuelve a encolar una publicación fallida y despierta al dispatcher
+func (a *App) RetryOutboxEntry(id int) error {
+	if a.waService == nil || a.waService.messageProcessor == nil {
+		return fmt.Errorf("message processor not initialized")
+	}
+	if err := a.waService.messageStore.RetryOutboxEntry(id); err != nil {
+		return err
+	}
+	a.waService.messageProcessor.wakeOutbox()
+	return nil
+}
+
+// RetryFailedPublication vuelve a encolar la publicación fallida de un mensaje (desde la vista de errores)
+func (a *App) RetryFailedPublication(messageID, chatJID string) error {
+	if a.waService == nil || a.waService.messageProcessor == nil {
+		return fmt.Errorf("message processor not initialized")
+	}
+	if err := a.waService.messageStore.RetryFailedPublication(messageID, chatJID); err != nil {
+		return err
+	}
+	a.waService.messageProcessor.wakeOutbox()
+	return nil
+}
+
+// DiscardOutboxEntry descarta una publicación fallida
+func (a *App) DiscardOutboxEntry(id int) error {
+	if a.waService == nil {
+		return fmt.Errorf("WhatsApp service not initialized")
+	}
+	return a.waService.messageStore.DiscardOutboxEntry(id)
+}
+
+// DispatchOutboxNow publica ya las entradas pendientes cuyo reintento venció y devuelve cuántas se publicaron
+func (a *App) DispatchOutboxNow() (int, error) {
+	if a.waService == nil || a.waService.messageProcessor == nil {
+		return 0, fmt.Errorf("message processor not initialized")
+	}
+	return a.waService.messageProcessor.DispatchOutbox(outboxBatchSize)
+}
+
+// ===== DEAD-LETTER QUEUE =====
+
+// GetDeadLetters obtiene los mensajes que agotaron sus reintentos, agrupados por clase de error y etapa
+func (a *App) GetDeadLetters(limit int) ([]DeadLetterGroup, error) {
+	if a.waService == nil {
+		return nil, fmt.Errorf("WhatsApp service not initialized")
+	}
+	return a.waService.messageStore.GetDeadLetters(limit)
+}
+
+// GetDeadLetterCount cuenta los mensajes de la dead-letter queue
+func (a *App) GetDeadLetterCount() (int, error) {
+	if a.waService == nil {
+		return 0, fmt.Errorf("WhatsApp service not initialized")
+	}
+	return a.waService.messageStore.GetDeadLetterCount()
+}
+
+// RequeueDeadLetters reencola mensajes de la dead-letter queue (aiConfigID = 0 usa la config activa)
+func (a *App) RequeueDeadLetters(keys []MessageKey, aiConfigID int) (int, error) {
+	if a.waService == nil {
+		return 0, fmt.Errorf("WhatsApp service not initialized")
+	}
+	if err := a.checkRequeueConfig(aiConfigID); err != nil {
+		return 0, err
+	}
+	return a.waService.messageStore.RequeueDeadLetters(keys, aiConfigID)
+}
+
+// EditAndRequeueDeadLetter corrige el contenido de un mensaje de la dead-letter queue y lo reencola
+func (a *App) EditAndRequeueDeadLetter(messageID, chatJID, newContent string, aiConfigID int) error {
+	if a.waService == nil {
+		return fmt.Errorf("WhatsApp service not initialized")
+	}
+	if err := a.checkRequeueConfig(aiConfigID); err != nil {
+		return err
+	}
+	return a.waService.messageStore.EditAndRequeueDeadLetter(MessageKey{ID: messageID, ChatJID: chatJID}, newContent, aiConfigID)
+}
+
+// DiscardDeadLetters descarta mensajes de la dead-letter queue con un motivo
+func (a *App) DiscardDeadLetters(keys []MessageKey, reason string) (int, error) {
+	if a.waService == nil {
+		return 0, fmt.Errorf("WhatsApp service not initialized")
+	}
+	return a.waService.messageStore.DiscardDeadLetters(keys, reason)
+}
+
+// GetDeadLetterAudit obtiene el historial de acciones sobre la dead-letter queue
+func (a *App) GetDeadLetterAudit(limit int) ([]DeadLetterAuditEntry, error) {
+	if a.waService == nil {
+		return nil, fmt.Errorf("WhatsApp service not initialized")
+	}
+	return a.waService.messageStore.GetDeadLetterAudit(limit)
+}
+
+// checkRequeueConfig verifica que la config elegida para reencolar exista y esté habilitada
+func (a *App) checkRequeueConfig(aiConfigID int) error {
+	if aiConfigID == 0 {
+		return nil
+	}
+	if a.waService.aiConfigManager == nil {
+		return fmt.Errorf("AI config manager not initialized")
+	}
+	config, err := a.waService.aiConfigManager.GetConfigByID(aiConfigID)
+	if err != nil {
+		return err
+	}
+	if !config.IsEnabled {
+		return fmt.Errorf("la configuración %s está deshabilitada", config.Name)
+	}
+	return nil
+}
+
 // ProcessSingleMessage procesa un solo mensaje por ID
 func (a *App) ProcessSingleMessage(messageID, chatJID string) (ProcessingResult, error) {
 	if a.messageProcessor == nil {
@@ -549,6 +1042,14 @@ func (a *App) GetAIProviders() ([]AIProvider, error) {
 	return a.waService.aiConfigManager.GetAllProviders()
 }
 
+// AddAIProvider agrega un nuevo proveedor de IA (OpenAI-compatible si apiFormat está vacío)
+func (a *App) AddAIProvider(name, displayName, baseURL, apiFormat string, priority int) error {
+	if a.waService == nil || a.waService.aiConfigManager == nil {
+		return fmt.Errorf("AI config manager not initialized")
+	}
+	return a.waService.aiConfigManager.AddProvider(name, displayName, baseURL, apiFormat, priority)
+}
+
 // GetAIModelsByProvider obtiene los modelos disponibles para un proveedor
 func (a *App) GetAIModelsByProvider(providerID int) ([]AIModel, error) {
 	if a.waService == nil || a.waService.aiConfigManager == nil {
@@ -621,6 +1122,64 @@ func (a *App) ResetAIConfigErrors(id int) error {
 	return a.waService.aiConfigManager.ResetErrorCount(id)
 }
 
+// UpdateAIConfigLimits actualiza los límites RPM/TPM/diarios (0 = sin límite) y la concurrencia máxima de una configuración
+func (a *App) UpdateAIConfigLimits(id, rpmLimit, tpmLimit, dailyRequestLimit, maxConcurrency int) error {
+	if a.waService == nil || a.waService.aiConfigManager == nil {
+		return fmt.Errorf("AI config manager not initialized")
+	}
+	return a.waService.aiConfigManager.SetLimits(id, rpmLimit, tpmLimit, dailyRequestLimit, maxConcurrency)
+}
+
+// GetAIConfigBudgets obtiene el presupuesto restante (RPM/TPM/diario) de cada configuración
+func (a *App) GetAIConfigBudgets() ([]ConfigBudget, error) {
+	if a.messageProcessor == nil {
+		return nil, fmt.Errorf("message processor not initialized")
+	}
+	return a.messageProcessor.aiProviderService.GetBudgets()
+}
+
+// GetPromptTemplates obtiene todas las versiones del prompt con las estadísticas de sus resultados
+func (a *App) GetPromptTemplates() ([]PromptTemplate, error) {
+	if a.messageProcessor == nil {
+		return nil, fmt.Errorf("message processor not initialized")
+	}
+	return a.messageProcessor.promptTemplates.GetTemplates()
+}
+
+// CreatePromptTemplate guarda una nueva versión del prompt (providerName "" = template por defecto)
+func (a *App) CreatePromptTemplate(providerName, systemTemplate, userTemplate, description string, activate bool) (*PromptTemplate, error) {
+	if a.messageProcessor == nil {
+		return nil, fmt.Errorf("message processor not initialized")
+	}
+	return a.messageProcessor.promptTemplates.CreateVersion(providerName, systemTemplate, userTemplate, description, activate)
+}
+
+// ActivatePromptTemplate activa una versión del prompt (también sirve para volver a una versión anterior)
+func (a *App) ActivatePromptTemplate(id int) error {
+	if a.messageProcessor == nil {
+		return fmt.Errorf("message processor not initialized")
+	}
+	return a.messageProcessor.promptTemplates.ActivateVersion(id)
+}
+
+// ReloadSystemPrompt recarga el prompt desde el archivo de contexto sin reiniciar la app
+func (a *App) ReloadSystemPrompt() (PromptReloadResult, error) {
+	if a.messageProcessor == nil {
+		return PromptReloadResult{}, fmt.Errorf("message processor not initialized")
+	}
+	result := a.messageProcessor.promptTemplates.ReloadFromFile(systemPromptFile, "manual")
+	a.emitPromptReload(result)
+	return result, nil
+}
+
+// DeactivatePromptOverride hace que un proveedor vuelva a usar el template por defecto
+func (a *App) DeactivatePromptOverride(providerName string) error {
+	if a.messageProcessor == nil {
+		return fmt.Errorf("message processor not initialized")
+	}
+	return a.messageProcessor.promptTemplates.DeactivateOverride(providerName)
+}
+
 // ToggleAIProvider habilita/deshabilita un proveedor
 func (a *App) ToggleAIProvider(id int, enabled bool) error {
 	if a.waService == nil || a.waService.aiConfigManager == nil {
@@ -666,19 +1225,12 @@ func (a *App) TestAIConfig(configID int) (map[string]interface{}, error) {
 
 	startTime := time.Now()
 
-	// Intentar procesar con el proveedor específico
-	providerService := NewAIProviderService(a.waService.aiConfigManager)
-
-	// Temporalmente activar esta config para la prueba
-	originalActive, _ := a.waService.aiConfigManager.GetActiveConfig()
-	a.waService.aiConfigManager.SetActiveConfig(configID)
+	// Intentar procesar con el proveedor específico (con un prompt de prueba, sin templates de la BD)
+	providerService := NewAIProviderService(a.waService.aiConfigManager, nil)
+	testPrompt := &PromptTemplate{SystemTemplate: "Eres un asistente de prueba.", UserTemplate: defaultUserTemplate}
 
-	_, err = providerService.ProcessMessage("Eres un asistente de prueba.", testMessage, "test")
-
-	// Restaurar configuración original
-	if originalActive != nil {
-		a.waService.aiConfigManager.SetActiveConfig(originalActive.ID)
-	}
+	// Llamar directamente a esta config (sin activarla ni hacer failover)
+	_, err = providerService.CallWithConfig(testConfig, testPrompt, PromptMessage{Content: testMessage, RealPhone: "test"})
 
 	elapsed := time.Since(startTime).Seconds()
 
@@ -822,6 +1374,13 @@ func (a *App) UpdateFacebookAccessToken(accessToken string) error {
 // shutdown se llama cuando la app se cierra
 func (a *App) shutdown(ctx context.Context) {
 	runtime.LogInfo(ctx, "Cerrando aplicación...")
+	a.CancelProcessing()
+	if a.stopPromptWatcher != nil {
+		a.stopPromptWatcher()
+	}
+	if a.stopOutbox != nil {
+		a.stopOutbox()
+	}
 	if a.waService != nil {
 		a.waService.Close()
 	}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formatos soportados por CargaFieldRule.Format
+const (
+	formatDate   = "date"   // dd/mm/aaaa
+	formatNumber = "number" // string numérico >= 0
+	formatPhone  = "phone"  // teléfono con 8 a 15 dígitos
+	formatEmail  = "email"
+)
+
+// CargaFieldRule describe las reglas de validación de un campo de CargaData
+type CargaFieldRule struct {
+	Field       string          // Nombre JSON del campo
+	Required    bool            // Debe estar presente y no vacío
+	Enum        func() []string // Valores válidos (catálogos de Supabase); nil = texto libre
+	Format      string          // formatDate, formatNumber, formatPhone, formatEmail o "" (sin formato)
+	Description string
+}
+
+// cargaSchema es el esquema declarativo de cada carga que devuelve la IA.
+// Debe mantenerse en línea con "CAMPOS OBLIGATORIOS" de contecto_funcionalidad_ia.md
+// (los valores válidos salen de los catálogos sincronizados, igual que {{materiales}} y compañía en el prompt)
+var cargaSchema = []CargaFieldRule{
+	{Field: "material", Required: true, Enum: catalogNames(CatalogMateriales), Description: "Material a transportar"},
+	{Field: "presentacion", Required: true, Enum: catalogNames(CatalogPresentaciones), Description: "Tipo de presentación de la carga"},
+	{Field: "peso", Required: true, Format: formatNumber, Description: "Peso en kilogramos (como string)"},
+	{Field: "tipoEquipo", Required: true, Enum: catalogNames(CatalogTiposEquipo), Description: "Tipo de vehículo necesario"},
+	{Field: "localidadCarga", Required: true, Description: "Ubicación de origen (Ciudad, Provincia, Argentina)"},
+	{Field: "localidadDescarga", Required: true, Description: "Ubicación de destino (Ciudad, Provincia, Argentina)"},
+	{Field: "fechaCarga", Required: true, Format: formatDate, Description: "Fecha de carga (dd/mm/aaaa)"},
+	{Field: "fechaDescarga", Required: true, Format: formatDate, Description: "Fecha de descarga (dd/mm/aaaa)"},
+	{Field: "telefono", Required: true, Format: formatPhone, Description: "Teléfono de contacto (+549XXXXXXXXX)"},
+	{Field: "correo", Format: formatEmail, Description: "Email de contacto"},
+	{Field: "puntoReferencia", Description: "Punto de referencia adicional"},
+	{Field: "precio", Format: formatNumber, Description: "Precio del viaje (como string)"},
+	{Field: "formaDePago", Enum: catalogNames(CatalogFormasPago), Description: "Forma de pago"},
+	{Field: "observaciones", Description: "Texto original completo del mensaje"},
+}
+
+// catalogNames devuelve una función con los nombres (ordenados) del catálogo vigente de ese tipo
+func catalogNames(kind string) func() []string {
+	return func() []string {
+		return catalogs.Names(kind)
+	}
+}
+
+// CargaFieldError es un error de validación de un campo de una carga
+type CargaFieldError struct {
+	Index   int    `json:"index"` // Posición de la carga en el array (desde 1); 0 = error de la respuesta completa
+	Field   string `json:"field"`
+	Value   string `json:"value,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e CargaFieldError) String() string {
+	if e.Index == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("carga %d, %s: %s", e.Index, e.Field, e.Message)
+}
+
+// ValidateCargasSchema valida un array JSON de cargas contra cargaSchema y devuelve los errores por campo
+func ValidateCargasSchema(data []byte) []CargaFieldError {
+	var cargas []map[string]interface{}
+	if err := json.Unmarshal(data, &cargas); err != nil {
+		return []CargaFieldError{{Message: fmt.Sprintf("la respuesta no es un array de cargas: %v", err)}}
+	}
+
+	var fieldErrors []CargaFieldError
+	for i, carga := range cargas {
+		for _, rule := range cargaSchema {
+			if msg, value := rule.validate(carga[rule.Field]); msg != "" {
+				fieldErrors = append(fieldErrors, CargaFieldError{
+					Index:   i + 1,
+					Field:   rule.Field,
+					Value:   value,
+					Message: msg,
+				})
+			}
+		}
+	}
+
+	return fieldErrors
+}
+
+// validate valida el valor crudo de un campo. Devuelve el mensaje de error ("" si es válido) y el valor como texto.
+func (r CargaFieldRule) validate(raw interface{}) (string, string) {
+	if raw == nil {
+		if r.Required {
+			return "campo obligatorio", ""
+		}
+		return "", ""
+	}
+
+	value, ok := raw.(string)
+	if !ok {
+		encoded, _ := json.Marshal(raw)
+		return fmt.Sprintf("debe ser un string (se recibió %s)", jsonTypeName(raw)), string(encoded)
+	}
+
+	if strings.TrimSpace(value) == "" {
+		if r.Required {
+			return "campo obligatorio", value
+		}
+		return "", value
+	}
+
+	if r.Enum != nil {
+		allowed := r.Enum()
+		valid := false
+		for _, option := range allowed {
+			if value == option {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return fmt.Sprintf("valor no válido; valores permitidos: %s", strings.Join(allowed, ", ")), value
+		}
+	}
+
+	switch r.Format {
+	case formatDate:
+		if _, err := time.Parse("02/01/2006", value); err != nil {
+			return "fecha inválida; formato esperado dd/mm/aaaa", value
+		}
+	case formatNumber:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(number) || math.IsInf(number, 0) || number < 0 {
+			return "debe ser un número mayor o igual a 0 (sin unidades ni separadores de miles)", value
+		}
+	case formatPhone:
+		digits := 0
+		for _, ch := range value {
+			switch {
+			case ch >= '0' && ch <= '9':
+				digits++
+			case ch == '+' || ch == ' ' || ch == '-' || ch == '(' || ch == ')':
+			default:
+				return "teléfono inválido; solo dígitos, +, espacios, guiones y paréntesis", value
+			}
+		}
+		if digits < 8 || digits > 15 {
+			return fmt.Sprintf("teléfono inválido; tiene %d dígitos (se esperan entre 8 y 15)", digits), value
+		}
+	case formatEmail:
+		if _, err := mail.ParseAddress(value); err != nil {
+			return "email inválido", value
+		}
+	}
+
+	return "", value
+}
+
+// jsonTypeName devuelve el nombre del tipo JSON de un valor decodificado
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// FormatCargaFieldErrors resume una lista de errores de campo en un solo mensaje
+func FormatCargaFieldErrors(fieldErrors []CargaFieldError) string {
+	parts := make([]string, 0, len(fieldErrors))
+	for _, e := range fieldErrors {
+		parts = append(parts, e.String())
+	}
+	return strings.Join(parts, "; ")
+}
+
+// CargaJSONSchema genera el JSON Schema (array de cargas) a partir de los campos de CargaData y las reglas de cargaSchema.
+// Se envía a los proveedores con structured output (Gemini responseSchema, OpenAI json_schema, Ollama format).
+func CargaJSONSchema() map[string]interface{} {
+	rules := make(map[string]CargaFieldRule, len(cargaSchema))
+	for _, rule := range cargaSchema {
+		rules[rule.Field] = rule
+	}
+
+	properties := make(map[string]interface{})
+	var required []string
+
+	cargaType := reflect.TypeOf(CargaData{})
+	for i := 0; i < cargaType.NumField(); i++ {
+		name := strings.Split(cargaType.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		property := map[string]interface{}{"type": "string"}
+		if rule, ok := rules[name]; ok {
+			if rule.Description != "" {
+				property["description"] = rule.Description
+			}
+			if rule.Enum != nil {
+				property["enum"] = rule.Enum()
+			}
+			if rule.Required {
+				required = append(required, name)
+			}
+		}
+		properties[name] = property
+	}
+
+	return map[string]interface{}{
+		"type": "array",
+		"items": map[string]interface{}{
+			"type":       "object",
+			"properties": properties,
+			"required":   required,
+		},
+	}
+}
+
+// wrapCargasSchema envuelve el schema de un array de cargas en {"cargas": [...]} para las APIs que exigen
+// un objeto en la raíz. La respuesta se desenvuelve con unwrapCargasObject.
+func wrapCargasSchema(arraySchema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"cargas": arraySchema,
+		},
+		"required": []string{"cargas"},
+	}
+}
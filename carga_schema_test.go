@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// validCarga devuelve una carga que cumple cargaSchema
+func validCarga() map[string]interface{} {
+	return map[string]interface{}{
+		"material":          "Fertilizante",
+		"presentacion":      "Granel",
+		"peso":              "30000",
+		"tipoEquipo":        "Batea",
+		"localidadCarga":    "Pergamino, Buenos Aires, Argentina",
+		"localidadDescarga": "Rosario, Santa Fe, Argentina",
+		"fechaCarga":        "10/03/2025",
+		"fechaDescarga":     "11/03/2025",
+		"telefono":          "+5492477123456",
+		"correo":            "",
+		"precio":            "",
+		"formaDePago":       "",
+		"observaciones":     "mensaje original",
+	}
+}
+
+func TestValidateCargasSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(carga map[string]interface{})
+		want   []CargaFieldError
+	}{
+		{name: "carga válida", modify: func(map[string]interface{}) {}},
+		{
+			name: "opcionales completos",
+			modify: func(c map[string]interface{}) {
+				c["correo"] = "dador@ejemplo.com"
+				c["precio"] = "1500.50"
+				c["formaDePago"] = "Cheque"
+			},
+		},
+		{
+			name:   "obligatorio ausente",
+			modify: func(c map[string]interface{}) { delete(c, "material") },
+			want:   []CargaFieldError{{Index: 1, Field: "material", Message: "campo obligatorio"}},
+		},
+		{
+			name:   "obligatorio vacío",
+			modify: func(c map[string]interface{}) { c["localidadDescarga"] = "  " },
+			want:   []CargaFieldError{{Index: 1, Field: "localidadDescarga", Value: "  ", Message: "campo obligatorio"}},
+		},
+		{
+			name:   "tipo incorrecto",
+			modify: func(c map[string]interface{}) { c["peso"] = 30000 },
+			want:   []CargaFieldError{{Index: 1, Field: "peso", Value: "30000", Message: "debe ser un string (se recibió number)"}},
+		},
+		{
+			name:   "número con unidades",
+			modify: func(c map[string]interface{}) { c["peso"] = "30 tn" },
+			want:   []CargaFieldError{{Index: 1, Field: "peso", Value: "30 tn", Message: "debe ser un número mayor o igual a 0 (sin unidades ni separadores de miles)"}},
+		},
+		{
+			name:   "fecha en otro formato",
+			modify: func(c map[string]interface{}) { c["fechaCarga"] = "2025-03-10" },
+			want:   []CargaFieldError{{Index: 1, Field: "fechaCarga", Value: "2025-03-10", Message: "fecha inválida; formato esperado dd/mm/aaaa"}},
+		},
+		{
+			name:   "teléfono corto",
+			modify: func(c map[string]interface{}) { c["telefono"] = "12345" },
+			want:   []CargaFieldError{{Index: 1, Field: "telefono", Value: "12345", Message: "teléfono inválido; tiene 5 dígitos (se esperan entre 8 y 15)"}},
+		},
+		{
+			name:   "teléfono con letras",
+			modify: func(c map[string]interface{}) { c["telefono"] = "+549 tel 123" },
+			want:   []CargaFieldError{{Index: 1, Field: "telefono", Value: "+549 tel 123", Message: "teléfono inválido; solo dígitos, +, espacios, guiones y paréntesis"}},
+		},
+		{
+			name:   "email inválido",
+			modify: func(c map[string]interface{}) { c["correo"] = "sin-arroba" },
+			want:   []CargaFieldError{{Index: 1, Field: "correo", Value: "sin-arroba", Message: "email inválido"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			carga := validCarga()
+			tt.modify(carga)
+			data, _ := json.Marshal([]map[string]interface{}{carga})
+
+			got := ValidateCargasSchema(data)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ValidateCargasSchema = %+v, se esperaba %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateCargasSchemaEnum(t *testing.T) {
+	carga := validCarga()
+	carga["tipoEquipo"] = "Camión"
+	data, _ := json.Marshal([]map[string]interface{}{validCarga(), carga})
+
+	got := ValidateCargasSchema(data)
+	if len(got) != 1 {
+		t.Fatalf("se esperaba 1 error, hay %d: %+v", len(got), got)
+	}
+	if got[0].Index != 2 || got[0].Field != "tipoEquipo" || got[0].Value != "Camión" {
+		t.Errorf("error = %+v, se esperaba carga 2, tipoEquipo, Camión", got[0])
+	}
+}
+
+func TestValidateCargasSchemaNotAnArray(t *testing.T) {
+	for _, body := range []string{`{"material": "Fertilizante"}`, `texto`, ``} {
+		got := ValidateCargasSchema([]byte(body))
+		if len(got) != 1 || got[0].Index != 0 {
+			t.Errorf("%q: se esperaba un único error de la respuesta completa, hay %+v", body, got)
+		}
+	}
+
+	if got := ValidateCargasSchema([]byte(`[]`)); len(got) != 0 {
+		t.Errorf("un array vacío no tiene errores: %+v", got)
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Cómo se encontró el valor del catálogo (CatalogMatch.Method)
+const (
+	catalogMatchExact      = "exact"      // Igual al valor del catálogo
+	catalogMatchNormalized = "normalized" // Igual sin acentos, mayúsculas ni espacios
+	catalogMatchSynonym    = "synonym"    // Está en catalog_synonyms
+	catalogMatchFuzzy      = "fuzzy"      // Por distancia de edición
+	catalogMatchFallback   = "fallback"   // Sin coincidencia: valor por defecto del catálogo
+)
+
+// defaultCatalogMatchThreshold es la confianza mínima (en %) para aceptar una coincidencia aproximada sin revisión
+const defaultCatalogMatchThreshold = 80
+
+// catalogMatchMinConfidence es la confianza por debajo de la cual ni se propone el candidato
+// y se usa el valor por defecto del catálogo (igual queda en revisión)
+const catalogMatchMinConfidence = 0.5
+
+// catalogWordWeight penaliza las coincidencias de una sola palabra ("maíz amarillo" → "Maiz")
+const catalogWordWeight = 0.9
+
+// catalogFields son los campos de CargaData que se mapean a un catálogo
+var catalogFields = []struct {
+	Field string
+	Kind  string
+}{
+	{Field: "material", Kind: CatalogMateriales},
+	{Field: "presentacion", Kind: CatalogPresentaciones},
+	{Field: "tipoEquipo", Kind: CatalogTiposEquipo},
+	{Field: "formaDePago", Kind: CatalogFormasPago},
+}
+
+// CatalogMatch es el mapeo de un valor devuelto por la IA a un valor del catálogo
+type CatalogMatch struct {
+	Index      int     `json:"index"` // Posición de la carga (desde 1)
+	Field      string  `json:"field"`
+	Kind       string  `json:"kind"`
+	Input      string  `json:"input"` // Valor que devolvió la IA
+	Value      string  `json:"value"` // Valor del catálogo ("" = sin candidato)
+	Method     string  `json:"method"`
+	Confidence float64 `json:"confidence"` // 0 a 1
+	Review     bool    `json:"review"`     // Confianza por debajo del umbral: la carga pasa por revisión
+}
+
+func (m CatalogMatch) String() string {
+	return fmt.Sprintf("carga %d, %s: %q → %q (%s %.0f%%)", m.Index, m.Field, m.Input, m.Value, m.Method, m.Confidence*100)
+}
+
+// CatalogSynonym es un sinónimo editable de un valor del catálogo (ej: "bolsones" → "Big Bag")
+type CatalogSynonym struct {
+	ID      int    `json:"id"`
+	Kind    string `json:"kind"`
+	Synonym string `json:"synonym"`
+	Value   string `json:"value"`
+}
+
+// catalogMatchKey normaliza un valor para compararlo: sin acentos, en minúsculas y solo letras y números
+// ("Camión Jaula" → "camionjaula")
+func catalogMatchKey(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(removerAcentos(s)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// catalogMatchWords devuelve las claves de las palabras de un valor (de 3 letras o más)
+func catalogMatchWords(s string) []string {
+	var words []string
+	for _, word := range strings.Fields(s) {
+		if key := catalogMatchKey(word); len([]rune(key)) >= 3 {
+			words = append(words, key)
+		}
+	}
+	return words
+}
+
+// levenshtein calcula la distancia de edición entre dos strings
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// similarity es 1 - distancia / largo del más largo (1 = iguales)
+func similarity(a, b string) float64 {
+	longest := max(len([]rune(a)), len([]rune(b)))
+	if longest == 0 {
+		return 0
+	}
+	return 1 - float64(levenshtein(a, b))/float64(longest)
+}
+
+// catalogFallback devuelve el valor por defecto de un catálogo
+func catalogFallback(kind string) string {
+	for _, src := range catalogSources {
+		if src.Kind == kind {
+			return src.Fallback
+		}
+	}
+	return ""
+}
+
+// Match busca el valor del catálogo que corresponde a value: exacto, normalizado, por sinónimo
+// y, si nada coincide, el más parecido por distancia de edición (Value = "" si el catálogo está vacío)
+func (c *CatalogCache) Match(kind, value string) CatalogMatch {
+	match := CatalogMatch{Kind: kind, Input: value}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry := c.entries[kind]
+	if entry == nil {
+		return match
+	}
+	if _, ok := entry.items[value]; ok {
+		match.Value, match.Method, match.Confidence = value, catalogMatchExact, 1
+		return match
+	}
+
+	key := catalogMatchKey(value)
+	if key == "" {
+		return match
+	}
+	for name := range entry.items {
+		if catalogMatchKey(name) == key {
+			match.Value, match.Method, match.Confidence = name, catalogMatchNormalized, 1
+			return match
+		}
+	}
+	if target, ok := c.synonyms[kind][key]; ok {
+		if _, exists := entry.items[target]; exists {
+			match.Value, match.Method, match.Confidence = target, catalogMatchSynonym, 1
+			return match
+		}
+	}
+
+	// Candidatos: cada valor del catálogo y cada sinónimo, por su clave normalizada
+	type candidate struct{ key, value string }
+	var candidates []candidate
+	for name := range entry.items {
+		candidates = append(candidates, candidate{catalogMatchKey(name), name})
+	}
+	for synonym, target := range c.synonyms[kind] {
+		if _, ok := entry.items[target]; ok {
+			candidates = append(candidates, candidate{synonym, target})
+		}
+	}
+	// Orden estable para que los empates den siempre el mismo resultado
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].key < candidates[j].key })
+
+	words := catalogMatchWords(value)
+	for _, cand := range candidates {
+		score := similarity(key, cand.key)
+		for _, word := range words {
+			if wordScore := similarity(word, cand.key) * catalogWordWeight; wordScore > score {
+				score = wordScore
+			}
+		}
+		if score > match.Confidence {
+			match.Value, match.Method, match.Confidence = cand.value, catalogMatchFuzzy, score
+		}
+	}
+	return match
+}
+
+func (c *CatalogCache) setSynonyms(synonyms map[string]map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.synonyms = synonyms
+}
+
+// matchCatalogValues reemplaza los valores de catálogo de cada carga por el valor del catálogo que les
+// corresponde, antes de validar el esquema. Devuelve la respuesta corregida y los mapeos que no fueron exactos;
+// los que no alcanzan el umbral quedan con Review = true para mandar las cargas a revisión.
+func (p *MessageProcessor) matchCatalogValues(response []byte) ([]byte, []CatalogMatch) {
+	var cargas []map[string]interface{}
+	if err := json.Unmarshal(response, &cargas); err != nil {
+		return response, nil // El esquema informa el error
+	}
+
+	// Sin configuración se usa el umbral por defecto
+	threshold := float64(defaultCatalogMatchThreshold) / 100
+	if p.systemConfigManager != nil {
+		threshold = p.systemConfigManager.GetCatalogMatchThreshold()
+	}
+	var matches []CatalogMatch
+	for i, carga := range cargas {
+		for _, f := range catalogFields {
+			value, ok := carga[f.Field].(string)
+			if !ok || strings.TrimSpace(value) == "" {
+				continue
+			}
+
+			match := catalogs.Match(f.Kind, value)
+			if match.Method == catalogMatchExact {
+				continue
+			}
+			match.Index = i + 1
+			match.Field = f.Field
+
+			if match.Confidence < threshold {
+				match.Review = true
+				if match.Confidence < catalogMatchMinConfidence {
+					fallback := catalogFallback(f.Kind)
+					if _, ok := catalogs.Lookup(f.Kind, fallback); !ok {
+						continue // Sin valor por defecto: el esquema lo rechaza y se pide corrección
+					}
+					match.Value, match.Method = fallback, catalogMatchFallback
+				}
+			}
+			if match.Value == "" {
+				continue
+			}
+
+			carga[f.Field] = match.Value
+			matches = append(matches, match)
+			p.logger.Infof("🔤 %s", match)
+		}
+	}
+
+	if len(matches) == 0 {
+		return response, nil
+	}
+	updated, err := json.Marshal(cargas)
+	if err != nil {
+		return response, nil
+	}
+	return updated, matches
+}
+
+// doubtfulCatalogMatches resume los mapeos que necesitan revisión ("" si no hay)
+func doubtfulCatalogMatches(matches []CatalogMatch) string {
+	var doubtful []string
+	for _, m := range matches {
+		if m.Review {
+			doubtful = append(doubtful, m.String())
+		}
+	}
+	return strings.Join(doubtful, "; ")
+}
+
+// GetCatalogSynonyms obtiene los sinónimos de los catálogos
+func (store *MessageStore) GetCatalogSynonyms() ([]CatalogSynonym, error) {
+	rows, err := store.db.Query(`SELECT id, kind, synonym, value FROM catalog_synonyms ORDER BY kind, synonym`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get catalog synonyms: %v", err)
+	}
+	defer rows.Close()
+
+	synonyms := []CatalogSynonym{}
+	for rows.Next() {
+		var s CatalogSynonym
+		if err := rows.Scan(&s.ID, &s.Kind, &s.Synonym, &s.Value); err != nil {
+			return nil, err
+		}
+		synonyms = append(synonyms, s)
+	}
+	return synonyms, rows.Err()
+}
+
+// SaveCatalogSynonym crea o reemplaza un sinónimo. La clave única es el sinónimo normalizado
+// (catalogMatchKey), la misma que usa Match: "Maíz" y "maiz" son el mismo sinónimo.
+func (store *MessageStore) SaveCatalogSynonym(kind, synonym, value string) error {
+	_, err := store.db.Exec(`
+		INSERT INTO catalog_synonyms (kind, synonym, synonym_key, value)
+		VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE synonym = VALUES(synonym), value = VALUES(value)
+	`, kind, synonym, catalogMatchKey(synonym), value)
+	if err != nil {
+		return fmt.Errorf("failed to save catalog synonym: %v", err)
+	}
+	return nil
+}
+
+// DeleteCatalogSynonym elimina un sinónimo
+func (store *MessageStore) DeleteCatalogSynonym(id int) error {
+	_, err := store.db.Exec(`DELETE FROM catalog_synonyms WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete catalog synonym: %v", err)
+	}
+	return nil
+}
+
+// loadCatalogSynonyms pasa a memoria los sinónimos de MySQL
+func (p *MessageProcessor) loadCatalogSynonyms() {
+	list, err := p.messageStore.GetCatalogSynonyms()
+	if err != nil {
+		p.logger.Warnf("Error leyendo sinónimos de catálogos: %v", err)
+		return
+	}
+
+	synonyms := make(map[string]map[string]string)
+	for _, s := range list {
+		if synonyms[s.Kind] == nil {
+			synonyms[s.Kind] = make(map[string]string)
+		}
+		synonyms[s.Kind][catalogMatchKey(s.Synonym)] = s.Value
+	}
+	catalogs.setSynonyms(synonyms)
+}
+
+// SaveCatalogSynonym valida y guarda un sinónimo; el valor tiene que existir en el catálogo vigente
+func (p *MessageProcessor) SaveCatalogSynonym(kind, synonym, value string) error {
+	synonym = strings.TrimSpace(synonym)
+	if catalogMatchKey(synonym) == "" {
+		return fmt.Errorf("el sinónimo no puede estar vacío")
+	}
+	if _, ok := catalogs.Lookup(kind, value); !ok {
+		return fmt.Errorf("%q no está en el catálogo de %s", value, kind)
+	}
+	if err := p.messageStore.SaveCatalogSynonym(kind, synonym, value); err != nil {
+		return err
+	}
+	p.loadCatalogSynonyms()
+	return nil
+}
+
+// DeleteCatalogSynonym elimina un sinónimo y actualiza los de memoria
+func (p *MessageProcessor) DeleteCatalogSynonym(id int) error {
+	if err := p.messageStore.DeleteCatalogSynonym(id); err != nil {
+		return err
+	}
+	p.loadCatalogSynonyms()
+	return nil
+}
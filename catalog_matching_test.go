@@ -0,0 +1,103 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"soja", "soja", 0},
+		{"kitten", "sitting", 3},
+		{"flaw", "lawn", 2},
+		{"maiz", "maíz", 1}, // Se compara por runa, no por byte
+	}
+
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, se esperaba %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCatalogMatchKey(t *testing.T) {
+	tests := map[string]string{
+		"Camión Jaula":  "camionjaula",
+		"  MAÍZ ":       "maiz",
+		"Big-Bag":       "bigbag",
+		"E-check 30/60": "echeck3060",
+		"¿?":            "",
+	}
+	for input, want := range tests {
+		if got := catalogMatchKey(input); got != want {
+			t.Errorf("catalogMatchKey(%q) = %q, se esperaba %q", input, got, want)
+		}
+	}
+}
+
+// testCatalogCache arma un caché chico e independiente de los catálogos incluidos en la app
+func testCatalogCache() *CatalogCache {
+	c := &CatalogCache{entries: map[string]*catalogEntry{
+		CatalogMateriales: {items: map[string]string{
+			"Maiz": "m1", "Soja": "m2", "Otros cultivos": "m3", "Otras cargas generales": "m4",
+		}},
+		CatalogPresentaciones: {items: map[string]string{
+			"Big Bag": "p1", "Granel": "p2", "Bolsa": "p3",
+		}},
+		CatalogTiposEquipo: {items: map[string]string{}},
+	}}
+	c.setSynonyms(map[string]map[string]string{
+		CatalogMateriales:     {"cebada": "Otros cultivos", "hacienda": "Ganado"}, // "Ganado" no está en este catálogo
+		CatalogPresentaciones: {"bolsones": "Big Bag"},
+	})
+	return c
+}
+
+func TestCatalogCacheMatch(t *testing.T) {
+	c := testCatalogCache()
+
+	tests := []struct {
+		name           string
+		kind, value    string
+		wantValue      string
+		wantMethod     string
+		wantConfidence float64
+	}{
+		{name: "exacto", kind: CatalogMateriales, value: "Soja", wantValue: "Soja", wantMethod: catalogMatchExact, wantConfidence: 1},
+		{name: "sin acentos ni mayúsculas", kind: CatalogMateriales, value: "MAÍZ", wantValue: "Maiz", wantMethod: catalogMatchNormalized, wantConfidence: 1},
+		{name: "espacios y guiones", kind: CatalogPresentaciones, value: "big-bag", wantValue: "Big Bag", wantMethod: catalogMatchNormalized, wantConfidence: 1},
+		{name: "sinónimo", kind: CatalogPresentaciones, value: "Bolsones", wantValue: "Big Bag", wantMethod: catalogMatchSynonym, wantConfidence: 1},
+		{name: "sinónimo normalizado", kind: CatalogMateriales, value: "CEBADA", wantValue: "Otros cultivos", wantMethod: catalogMatchSynonym, wantConfidence: 1},
+		{name: "aproximado", kind: CatalogMateriales, value: "Sojaa", wantValue: "Soja", wantMethod: catalogMatchFuzzy, wantConfidence: 0.8},
+		{name: "una sola palabra", kind: CatalogMateriales, value: "maíz amarillo", wantValue: "Maiz", wantMethod: catalogMatchFuzzy, wantConfidence: 0.9},
+		{name: "catálogo vacío", kind: CatalogTiposEquipo, value: "Batea"},
+		{name: "catálogo inexistente", kind: "otro", value: "Soja"},
+		{name: "sin letras ni números", kind: CatalogMateriales, value: "¿?"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := c.Match(tt.kind, tt.value)
+			if got.Value != tt.wantValue || got.Method != tt.wantMethod || math.Abs(got.Confidence-tt.wantConfidence) > 1e-9 {
+				t.Errorf("Match(%s, %q) = %q (%s %.2f), se esperaba %q (%s %.2f)",
+					tt.kind, tt.value, got.Value, got.Method, got.Confidence, tt.wantValue, tt.wantMethod, tt.wantConfidence)
+			}
+		})
+	}
+}
+
+func TestCatalogCacheMatchSynonymToMissingValue(t *testing.T) {
+	c := testCatalogCache()
+
+	// El sinónimo apunta a un valor que no está en el catálogo vigente: no se usa
+	got := c.Match(CatalogMateriales, "hacienda")
+	if got.Method == catalogMatchSynonym || got.Value == "Ganado" {
+		t.Errorf("Match(hacienda) = %q (%s), no debería usar un sinónimo a un valor inexistente", got.Value, got.Method)
+	}
+}
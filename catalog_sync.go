@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Catálogos de Supabase que usan las cargas (material, presentación, tipo de equipo y forma de pago)
+const (
+	CatalogMateriales     = "materiales"
+	CatalogPresentaciones = "presentaciones"
+	CatalogTiposEquipo    = "tipos_equipo"
+	CatalogFormasPago     = "formas_pago"
+)
+
+// Origen de los ítems de un catálogo en memoria
+const (
+	catalogSourceBuiltin  = "incluido" // Mapas de supabase_service.go (todavía no se sincronizó nunca)
+	catalogSourceMySQL    = "mysql"    // Caché local (supabase_catalogs)
+	catalogSourceSupabase = "supabase" // Recién sincronizado
+)
+
+// defaultCatalogTTLHours es cada cuántas horas se vuelven a pedir los catálogos a Supabase
+const defaultCatalogTTLHours = 24
+
+// catalogSyncRetryInterval evita reintentar en cada lote si Supabase no responde
+const catalogSyncRetryInterval = 5 * time.Minute
+
+// catalogSource describe un catálogo y de qué tabla de Supabase sale
+type catalogSource struct {
+	Kind     string
+	Label    string
+	Table    string            // Tabla de Supabase
+	Fallback string            // Valor que se usa si la carga trae algo fuera del catálogo
+	Builtin  map[string]string // Catálogo incluido en la app
+}
+
+var catalogSources = []catalogSource{
+	{Kind: CatalogMateriales, Label: "Materiales", Table: "materiales", Fallback: "Otras cargas generales", Builtin: materialesCatalogo},
+	{Kind: CatalogPresentaciones, Label: "Presentaciones", Table: "presentaciones", Fallback: "Otros", Builtin: presentacionesCatalogo},
+	{Kind: CatalogTiposEquipo, Label: "Tipos de equipo", Table: "tipos_equipo", Fallback: "Otros", Builtin: tiposEquipoCatalogo},
+	{Kind: CatalogFormasPago, Label: "Formas de pago", Table: "formas_pago", Fallback: "Efectivo", Builtin: formasPagoCatalogo},
+}
+
+// CatalogStatus es el estado de un catálogo para la UI
+type CatalogStatus struct {
+	Kind     string     `json:"kind"`
+	Label    string     `json:"label"`
+	Table    string     `json:"table"`
+	Count    int        `json:"count"`
+	Names    []string   `json:"names"`
+	Source   string     `json:"source"`
+	SyncedAt *time.Time `json:"synced_at"`
+	Error    string     `json:"error,omitempty"` // Último error al sincronizar
+}
+
+// CatalogSettings es el estado de los catálogos, su TTL y los sinónimos para la pantalla de configuración
+type CatalogSettings struct {
+	TTLHours       int              `json:"ttl_hours"`
+	MatchThreshold int              `json:"match_threshold"` // % mínimo para aceptar una coincidencia aproximada
+	Catalogs       []CatalogStatus  `json:"catalogs"`
+	Synonyms       []CatalogSynonym `json:"synonyms"`
+}
+
+type catalogEntry struct {
+	items    map[string]string
+	source   string
+	syncedAt time.Time
+	err      string
+}
+
+// CatalogCache son los catálogos vigentes en memoria. Los usan el esquema de validación,
+// el mapeo a IDs de Supabase y las variables del prompt, así nunca se contradicen.
+type CatalogCache struct {
+	mu          sync.RWMutex
+	entries     map[string]*catalogEntry
+	synonyms    map[string]map[string]string // tipo → sinónimo normalizado → valor del catálogo
+	syncMu      sync.Mutex                   // Una sincronización a la vez
+	lastAttempt time.Time
+}
+
+// catalogs arranca con los catálogos incluidos y se actualiza desde MySQL / Supabase
+var catalogs = newCatalogCache()
+
+func newCatalogCache() *CatalogCache {
+	c := &CatalogCache{entries: make(map[string]*catalogEntry)}
+	for _, src := range catalogSources {
+		c.entries[src.Kind] = &catalogEntry{items: src.Builtin, source: catalogSourceBuiltin}
+	}
+	return c
+}
+
+// Lookup devuelve el ID de Supabase de un valor del catálogo
+func (c *CatalogCache) Lookup(kind, name string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	id, ok := c.entries[kind].items[name]
+	return id, ok
+}
+
+// Resolve devuelve el ID de un valor (tolerando acentos, mayúsculas y sinónimos),
+// o el del valor por defecto del catálogo si no existe
+func (c *CatalogCache) Resolve(kind, name string) (string, error) {
+	if match := c.Match(kind, name); match.Confidence == 1 {
+		if id, ok := c.Lookup(kind, match.Value); ok {
+			return id, nil
+		}
+	}
+	for _, src := range catalogSources {
+		if src.Kind != kind {
+			continue
+		}
+		if id, ok := c.Lookup(kind, src.Fallback); ok {
+			return id, nil
+		}
+		return "", fmt.Errorf("%q no está en el catálogo de %s (ni el valor por defecto %q)", name, src.Table, src.Fallback)
+	}
+	return "", fmt.Errorf("catálogo desconocido: %s", kind)
+}
+
+// Names devuelve los valores válidos de un catálogo, ordenados
+func (c *CatalogCache) Names(kind string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	names := make([]string, 0, len(c.entries[kind].items))
+	for name := range c.entries[kind].items {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *CatalogCache) set(kind string, items map[string]string, source string, syncedAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[kind] = &catalogEntry{items: items, source: source, syncedAt: syncedAt}
+}
+
+func (c *CatalogCache) setError(kind, err string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[kind].err = err
+}
+
+// stale indica si algún catálogo nunca se sincronizó o es más viejo que ttl
+func (c *CatalogCache) stale(ttl time.Duration) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, entry := range c.entries {
+		if entry.source == catalogSourceBuiltin || time.Since(entry.syncedAt) > ttl {
+			return true
+		}
+	}
+	return false
+}
+
+// Status devuelve el estado de cada catálogo en el orden de catalogSources
+func (c *CatalogCache) Status() []CatalogStatus {
+	statuses := make([]CatalogStatus, 0, len(catalogSources))
+	for _, src := range catalogSources {
+		names := c.Names(src.Kind)
+
+		c.mu.RLock()
+		entry := c.entries[src.Kind]
+		status := CatalogStatus{
+			Kind:   src.Kind,
+			Label:  src.Label,
+			Table:  src.Table,
+			Count:  len(names),
+			Names:  names,
+			Source: entry.source,
+			Error:  entry.err,
+		}
+		if !entry.syncedAt.IsZero() {
+			t := entry.syncedAt
+			status.SyncedAt = &t
+		}
+		c.mu.RUnlock()
+
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// catalogPromptList arma la lista de valores de un catálogo para el prompt (- "Valor" por línea)
+func catalogPromptList(kind string) string {
+	names := catalogs.Names(kind)
+	lines := make([]string, len(names))
+	for i, name := range names {
+		lines[i] = fmt.Sprintf("- %q", name)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// catalogPromptReplacements son los pares variable → lista de valores para strings.NewReplacer
+func catalogPromptReplacements() []string {
+	return []string{
+		promptVarMateriales, catalogPromptList(CatalogMateriales),
+		promptVarPresentaciones, catalogPromptList(CatalogPresentaciones),
+		promptVarTiposEquipo, catalogPromptList(CatalogTiposEquipo),
+		promptVarFormasPago, catalogPromptList(CatalogFormasPago),
+	}
+}
+
+// LoadCatalogs lee la caché local de catálogos (tipo → nombre → ID) y cuándo se sincronizó cada uno
+func (store *MessageStore) LoadCatalogs() (map[string]map[string]string, map[string]time.Time, error) {
+	rows, err := store.db.Query(`SELECT kind, name, supabase_id, synced_at FROM supabase_catalogs`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load catalogs: %v", err)
+	}
+	defer rows.Close()
+
+	items := make(map[string]map[string]string)
+	syncedAt := make(map[string]time.Time)
+	for rows.Next() {
+		var kind, name, id string
+		var synced time.Time
+		if err := rows.Scan(&kind, &name, &id, &synced); err != nil {
+			return nil, nil, err
+		}
+		if items[kind] == nil {
+			items[kind] = make(map[string]string)
+		}
+		items[kind][name] = id
+		if synced.After(syncedAt[kind]) {
+			syncedAt[kind] = synced
+		}
+	}
+	return items, syncedAt, rows.Err()
+}
+
+// SaveCatalog reemplaza la caché local de un catálogo
+func (store *MessageStore) SaveCatalog(kind string, items map[string]string, syncedAt time.Time) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin catalog transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM supabase_catalogs WHERE kind = ?`, kind); err != nil {
+		return fmt.Errorf("failed to clear catalog %s: %v", kind, err)
+	}
+	for name, id := range items {
+		_, err := tx.Exec(`
+			INSERT INTO supabase_catalogs (kind, name, supabase_id, synced_at) VALUES (?, ?, ?, ?)
+		`, kind, name, id, syncedAt)
+		if err != nil {
+			return fmt.Errorf("failed to save catalog %s: %v", kind, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// loadCachedCatalogs pasa a memoria los catálogos guardados en MySQL (los que no estén quedan como están)
+// y los sinónimos editables
+func (p *MessageProcessor) loadCachedCatalogs() {
+	p.loadCatalogSynonyms()
+
+	items, syncedAt, err := p.messageStore.LoadCatalogs()
+	if err != nil {
+		p.logger.Warnf("Error leyendo catálogos de MySQL: %v", err)
+		return
+	}
+	for kind, kindItems := range items {
+		catalogs.set(kind, kindItems, catalogSourceMySQL, syncedAt[kind])
+	}
+}
+
+// refreshCatalogsIfStale sincroniza los catálogos si vencieron (como mucho un intento cada catalogSyncRetryInterval)
+func (p *MessageProcessor) refreshCatalogsIfStale() {
+	ttl := p.systemConfigManager.GetCatalogTTL()
+	if !catalogs.stale(ttl) {
+		return
+	}
+
+	// Otra instancia pudo haberlos sincronizado
+	p.loadCachedCatalogs()
+	if !catalogs.stale(ttl) {
+		return
+	}
+
+	catalogs.syncMu.Lock()
+	recent := time.Since(catalogs.lastAttempt) < catalogSyncRetryInterval
+	catalogs.syncMu.Unlock()
+	if recent {
+		return
+	}
+
+	if _, err := p.SyncCatalogs(); err != nil {
+		p.logger.Warnf("📚 %v (se siguen usando los catálogos anteriores)", err)
+	}
+}
+
+// SyncCatalogs pide los catálogos a Supabase y actualiza MySQL y la memoria.
+// Si un catálogo falla se conserva el anterior y el error queda en su estado.
+func (p *MessageProcessor) SyncCatalogs() ([]CatalogStatus, error) {
+	catalogs.syncMu.Lock()
+	defer catalogs.syncMu.Unlock()
+	catalogs.lastAttempt = time.Now()
+
+	var failed []string
+	for _, src := range catalogSources {
+		items, err := p.supabaseService.listarCatalogo(src.Table)
+		if err == nil {
+			now := time.Now()
+			if err = p.messageStore.SaveCatalog(src.Kind, items, now); err == nil {
+				catalogs.set(src.Kind, items, catalogSourceSupabase, now)
+				p.logger.Infof("📚 Catálogo %s sincronizado: %d ítems", src.Table, len(items))
+				continue
+			}
+		}
+
+		catalogs.setError(src.Kind, err.Error())
+		failed = append(failed, fmt.Sprintf("%s: %v", src.Table, err))
+	}
+
+	if len(failed) > 0 {
+		return catalogs.Status(), fmt.Errorf("no se pudieron sincronizar los catálogos: %s", strings.Join(failed, "; "))
+	}
+	return catalogs.Status(), nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// A quién se aplica una asignación de dador (dador_mappings.match_type)
+const (
+	DadorMatchPhone = "phone" // Teléfono real del remitente (phone_associations.real_phone)
+	DadorMatchGroup = "group" // Grupo de WhatsApp (chat_jid)
+)
+
+// DadorMapping asigna un dador de Supabase a un remitente o a un grupo
+type DadorMapping struct {
+	ID         int    `json:"id"`
+	MatchType  string `json:"match_type"`  // phone / group
+	MatchValue string `json:"match_value"` // real_phone o chat_jid
+	MatchName  string `json:"match_name"`  // Nombre del remitente o del grupo (para la UI)
+	DadorID    string `json:"dador_id"`
+	Nombre     string `json:"nombre"`
+}
+
+// DadorSettings es el dador por defecto y las asignaciones para la pantalla de configuración
+type DadorSettings struct {
+	DefaultDadorID string         `json:"default_dador_id"`
+	Mappings       []DadorMapping `json:"mappings"`
+}
+
+// GetDadorMappings obtiene las asignaciones de dador (primero las de teléfono)
+func (store *MessageStore) GetDadorMappings() ([]DadorMapping, error) {
+	rows, err := store.db.Query(`
+		SELECT d.id, d.match_type, d.match_value,
+		       COALESCE(NULLIF(c.name, ''), NULLIF(pa.nombre, ''), pa.display_name, ''),
+		       d.dador_id, COALESCE(d.nombre, '')
+		FROM dador_mappings d
+		LEFT JOIN chats c ON d.match_type = 'group' AND c.jid = d.match_value
+		LEFT JOIN (
+			SELECT real_phone, MAX(nombre) AS nombre, MAX(display_name) AS display_name
+			FROM phone_associations
+			GROUP BY real_phone
+		) pa ON d.match_type = 'phone' AND pa.real_phone = d.match_value
+		ORDER BY d.match_type DESC, d.match_value
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dador mappings: %v", err)
+	}
+	defer rows.Close()
+
+	mappings := []DadorMapping{}
+	for rows.Next() {
+		var m DadorMapping
+		if err := rows.Scan(&m.ID, &m.MatchType, &m.MatchValue, &m.MatchName, &m.DadorID, &m.Nombre); err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings, rows.Err()
+}
+
+// GetMappedDadorID busca el dador asignado a un remitente o, si no tiene, al grupo ("" si ninguno tiene)
+func (store *MessageStore) GetMappedDadorID(realPhone, chatJID string) (string, error) {
+	var dadorID string
+	err := store.db.QueryRow(`
+		SELECT dador_id FROM dador_mappings
+		WHERE (match_type = ? AND match_value = ?) OR (match_type = ? AND match_value = ?)
+		ORDER BY match_type = ? DESC
+		LIMIT 1
+	`, DadorMatchPhone, realPhone, DadorMatchGroup, chatJID, DadorMatchPhone).Scan(&dadorID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get dador mapping: %v", err)
+	}
+	return dadorID, nil
+}
+
+// GetMappedDadorIDs devuelve los dadores distintos que tienen alguna asignación
+func (store *MessageStore) GetMappedDadorIDs() ([]string, error) {
+	rows, err := store.db.Query(`SELECT DISTINCT dador_id FROM dador_mappings`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dador mappings: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// SaveDadorMapping crea o reemplaza la asignación de dador de un remitente o grupo
+func (store *MessageStore) SaveDadorMapping(matchType, matchValue, dadorID, nombre string) error {
+	_, err := store.db.Exec(`
+		INSERT INTO dador_mappings (match_type, match_value, dador_id, nombre)
+		VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE dador_id = VALUES(dador_id), nombre = VALUES(nombre)
+	`, matchType, matchValue, dadorID, nombre)
+	if err != nil {
+		return fmt.Errorf("failed to save dador mapping: %v", err)
+	}
+	return nil
+}
+
+// DeleteDadorMapping elimina una asignación de dador
+func (store *MessageStore) DeleteDadorMapping(id int) error {
+	_, err := store.db.Exec(`DELETE FROM dador_mappings WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete dador mapping: %v", err)
+	}
+	return nil
+}
+
+// getPhoneName devuelve el nombre conocido de un teléfono real (nombre o display_name de phone_associations)
+func (store *MessageStore) getPhoneName(realPhone string) (string, error) {
+	var nombre string
+	err := store.db.QueryRow(`
+		SELECT COALESCE(NULLIF(MAX(nombre), ''), MAX(display_name), '')
+		FROM phone_associations
+		WHERE real_phone = ?
+	`, realPhone).Scan(&nombre)
+	return nombre, err
+}
+
+// resolveDadorID decide a nombre de qué dador se publican las cargas de un mensaje:
+// la asignación del remitente, la del grupo o el dador por defecto
+func (p *MessageProcessor) resolveDadorID(realPhone, chatJID string) (string, error) {
+	dadorID, err := p.messageStore.GetMappedDadorID(realPhone, chatJID)
+	if err != nil {
+		return "", err
+	}
+	if dadorID != "" {
+		return dadorID, nil
+	}
+	return p.systemConfigManager.GetDefaultDadorID(), nil
+}
+
+// validateDadorMapping valida el tipo y el valor de una asignación
+func validateDadorMapping(matchType, matchValue, dadorID string) error {
+	if matchType != DadorMatchPhone && matchType != DadorMatchGroup {
+		return fmt.Errorf("tipo de asignación inválido: %s", matchType)
+	}
+	if strings.TrimSpace(matchValue) == "" {
+		return fmt.Errorf("falta el teléfono o el grupo")
+	}
+	if strings.TrimSpace(dadorID) == "" {
+		return fmt.Errorf("falta el ID del dador")
+	}
+	return nil
+}
+
+// CreateDadorForPhone busca en Supabase el dador con ese teléfono (o lo crea si no existe)
+// y lo asigna al remitente. Sin nombre se usa el de phone_associations.
+func (p *MessageProcessor) CreateDadorForPhone(realPhone, nombre string) (*DadorMapping, error) {
+	realPhone = strings.TrimSpace(realPhone)
+	if realPhone == "" {
+		return nil, fmt.Errorf("falta el teléfono")
+	}
+
+	nombre = strings.TrimSpace(nombre)
+	if nombre == "" {
+		known, err := p.messageStore.getPhoneName(realPhone)
+		if err != nil {
+			return nil, err
+		}
+		nombre = known
+	}
+	if nombre == "" {
+		nombre = realPhone
+	}
+
+	telefono := p.supabaseService.validarTelefono(realPhone)
+	dadorID, err := p.supabaseService.buscarDadorPorTelefono(telefono)
+	if err != nil {
+		return nil, err
+	}
+	if dadorID == "" {
+		dadorID, err = p.supabaseService.crearDador(nombre, telefono)
+		if err != nil {
+			return nil, err
+		}
+		p.logger.Infof("🏢 Dador %s creado en Supabase para %s", dadorID, realPhone)
+	} else {
+		p.logger.Infof("🏢 Dador %s ya existía en Supabase para %s", dadorID, realPhone)
+	}
+
+	if err := p.messageStore.SaveDadorMapping(DadorMatchPhone, realPhone, dadorID, nombre); err != nil {
+		return nil, err
+	}
+	return &DadorMapping{MatchType: DadorMatchPhone, MatchValue: realPhone, MatchName: nombre, DadorID: dadorID, Nombre: nombre}, nil
+}
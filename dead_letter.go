@@ -0,0 +1,308 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Acciones sobre la dead-letter queue (dead_letter_audit.action)
+const (
+	DeadLetterActionRequeue     = "requeue"      // Reencolado con los intentos en 0 (config activa)
+	DeadLetterActionRequeueWith = "requeue_with" // Reencolado fijado a otra configuración de IA
+	DeadLetterActionEdit        = "edit_requeue" // Contenido editado y reencolado
+	DeadLetterActionDiscard     = "discard"      // Descartado con un motivo
+)
+
+// deadLetterCondition filtra los mensajes de la dead-letter queue (alias m): los fallidos sin descartar,
+// más los que agotaron los intentos antes de que existiera failed_at. Recibe maxProcessingAttempts.
+const deadLetterCondition = `m.discarded_at IS NULL
+		  AND (m.failed_at IS NOT NULL OR (m.processed = 0 AND m.processing_attempts >= ?))`
+
+// DeadLetterMessage es un mensaje que agotó sus reintentos o falló con un error permanente
+type DeadLetterMessage struct {
+	ID                 string     `json:"id"`
+	ChatJID            string     `json:"chat_jid"`
+	ChatName           string     `json:"chat_name"`
+	SenderPhone        string     `json:"sender_phone"`
+	SenderName         string     `json:"sender_name"`
+	RealPhone          string     `json:"real_phone"`
+	Content            string     `json:"content"`
+	Timestamp          time.Time  `json:"timestamp"`
+	ProcessingAttempts int        `json:"processing_attempts"`
+	RepairAttempts     int        `json:"repair_attempts"`
+	LastError          string     `json:"last_error"`
+	ErrorClass         string     `json:"error_class"`  // transient / permanent ("" en mensajes anteriores a la clasificación)
+	Category           string     `json:"category"`     // Etapa en la que falló (ver deadLetterCategory)
+	AIConfigID         int        `json:"ai_config_id"` // Config con la que se reencoló la última vez (0 = la activa)
+	FailedAt           *time.Time `json:"failed_at"`
+}
+
+// DeadLetterGroup agrupa los mensajes de la dead-letter queue por clase de error y etapa
+type DeadLetterGroup struct {
+	ErrorClass string              `json:"error_class"`
+	Category   string              `json:"category"`
+	Count      int                 `json:"count"`
+	Messages   []DeadLetterMessage `json:"messages"`
+}
+
+// DeadLetterAuditEntry es una acción registrada sobre un mensaje de la dead-letter queue
+type DeadLetterAuditEntry struct {
+	ID                 int       `json:"id"`
+	MessageID          string    `json:"message_id"`
+	ChatJID            string    `json:"chat_jid"`
+	Action             string    `json:"action"`
+	Reason             string    `json:"reason"`
+	AIConfigID         int       `json:"ai_config_id"`
+	PreviousError      string    `json:"previous_error"`
+	PreviousErrorClass string    `json:"previous_error_class"`
+	PreviousAttempts   int       `json:"previous_attempts"`
+	PreviousContent    string    `json:"previous_content"` // Solo en edit_requeue
+	PerformedBy        string    `json:"performed_by"`     // Instancia que ejecutó la acción
+	CreatedAt          time.Time `json:"created_at"`
+}
+
+// deadLetterCategory deduce la etapa en la que falló el mensaje a partir del prefijo del error (ver processMessage)
+func deadLetterCategory(lastError string) string {
+	switch {
+	case strings.HasPrefix(lastError, "AI processing failed"),
+		strings.HasPrefix(lastError, "No active AI configuration"),
+		strings.HasPrefix(lastError, "No AI service"):
+		return "IA"
+	case strings.HasPrefix(lastError, "Invalid AI response"),
+		strings.HasPrefix(lastError, "Failed to normalize"),
+		strings.HasPrefix(lastError, "Schema validation failed"):
+		return "Validación"
+	case strings.HasPrefix(lastError, "Invalid locations"):
+		return "Ubicaciones"
+	case strings.HasPrefix(lastError, "Empty message content"):
+		return "Contenido vacío"
+	default:
+		return "Otro"
+	}
+}
+
+// GetDeadLetters obtiene los mensajes de la dead-letter queue agrupados por clase de error y etapa (los grupos más grandes primero)
+func (store *MessageStore) GetDeadLetters(limit int) ([]DeadLetterGroup, error) {
+	rows, err := store.db.Query(`
+		SELECT m.id, m.chat_jid, COALESCE(c.name, ''), COALESCE(m.sender_phone, ''), COALESCE(m.sender_name, ''),
+		       COALESCE(pa.real_phone, ''), COALESCE(m.content, ''), m.timestamp,
+		       COALESCE(m.processing_attempts, 0), m.repair_attempts,
+		       COALESCE(m.last_processing_error, ''), COALESCE(m.error_class, ''), COALESCE(m.ai_config_id, 0), m.failed_at
+		FROM messages m
+		LEFT JOIN phone_associations pa ON pa.sender_phone = m.sender_phone
+		LEFT JOIN chats c ON c.jid = m.chat_jid
+		WHERE `+deadLetterCondition+`
+		ORDER BY COALESCE(m.failed_at, m.last_processing_attempt) DESC
+		LIMIT ?
+	`, maxProcessingAttempts, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dead letters: %v", err)
+	}
+	defer rows.Close()
+
+	groups := []DeadLetterGroup{}
+	groupIndex := make(map[string]int)
+	for rows.Next() {
+		var msg DeadLetterMessage
+		var failedAt sql.NullTime
+		err := rows.Scan(&msg.ID, &msg.ChatJID, &msg.ChatName, &msg.SenderPhone, &msg.SenderName,
+			&msg.RealPhone, &msg.Content, &msg.Timestamp,
+			&msg.ProcessingAttempts, &msg.RepairAttempts,
+			&msg.LastError, &msg.ErrorClass, &msg.AIConfigID, &failedAt)
+		if err != nil {
+			return nil, err
+		}
+		if failedAt.Valid {
+			t := failedAt.Time
+			msg.FailedAt = &t
+		}
+		msg.Category = deadLetterCategory(msg.LastError)
+
+		key := msg.ErrorClass + "|" + msg.Category
+		i, ok := groupIndex[key]
+		if !ok {
+			i = len(groups)
+			groupIndex[key] = i
+			groups = append(groups, DeadLetterGroup{ErrorClass: msg.ErrorClass, Category: msg.Category})
+		}
+		groups[i].Messages = append(groups[i].Messages, msg)
+		groups[i].Count++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(groups, func(a, b int) bool {
+		return groups[a].Count > groups[b].Count
+	})
+	return groups, nil
+}
+
+// GetDeadLetterCount cuenta los mensajes de la dead-letter queue
+func (store *MessageStore) GetDeadLetterCount() (int, error) {
+	var count int
+	err := store.db.QueryRow(`SELECT COUNT(*) FROM messages m WHERE `+deadLetterCondition, maxProcessingAttempts).Scan(&count)
+	return count, err
+}
+
+// RequeueDeadLetters devuelve mensajes de la dead-letter queue a la cola con los intentos en 0.
+// Con aiConfigID != 0 el mensaje se procesa con esa configuración, sin failover.
+// Los mensajes que ya no están en la dead-letter queue se ignoran; devuelve cuántos se reencolaron.
+func (store *MessageStore) RequeueDeadLetters(keys []MessageKey, aiConfigID int) (int, error) {
+	action := DeadLetterActionRequeue
+	if aiConfigID != 0 {
+		action = DeadLetterActionRequeueWith
+	}
+
+	return store.applyDeadLetterAction(keys, action, "", aiConfigID, func(tx *sql.Tx, key MessageKey) error {
+		_, err := tx.Exec(`
+			UPDATE messages
+			SET `+resetProcessingColumns+`,
+			    ai_config_id = NULLIF(?, 0)
+			WHERE id = ? AND chat_jid = ?
+		`, aiConfigID, key.ID, key.ChatJID)
+		return err
+	})
+}
+
+// EditAndRequeueDeadLetter corrige el contenido de un mensaje de la dead-letter queue y lo reencola.
+// El contenido anterior queda en la auditoría.
+func (store *MessageStore) EditAndRequeueDeadLetter(key MessageKey, newContent string, aiConfigID int) error {
+	if strings.TrimSpace(newContent) == "" {
+		return fmt.Errorf("el contenido no puede estar vacío")
+	}
+
+	count, err := store.applyDeadLetterAction([]MessageKey{key}, DeadLetterActionEdit, "", aiConfigID, func(tx *sql.Tx, key MessageKey) error {
+		_, err := tx.Exec(`
+			UPDATE messages
+			SET content = ?,
+			    `+resetProcessingColumns+`,
+			    ai_config_id = NULLIF(?, 0)
+			WHERE id = ? AND chat_jid = ?
+		`, newContent, aiConfigID, key.ID, key.ChatJID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("el mensaje ya no está en la dead-letter queue")
+	}
+	return nil
+}
+
+// DiscardDeadLetters saca mensajes de la dead-letter queue sin reprocesarlos (quedan fallidos, con el motivo)
+func (store *MessageStore) DiscardDeadLetters(keys []MessageKey, reason string) (int, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return 0, fmt.Errorf("indica el motivo del descarte")
+	}
+
+	return store.applyDeadLetterAction(keys, DeadLetterActionDiscard, reason, 0, func(tx *sql.Tx, key MessageKey) error {
+		_, err := tx.Exec(`
+			UPDATE messages
+			SET processed = 1,
+			    failed_at = COALESCE(failed_at, NOW()),
+			    next_attempt_at = NULL,
+			    discarded_at = NOW(),
+			    discard_reason = ?
+			WHERE id = ? AND chat_jid = ?
+		`, reason, key.ID, key.ChatJID)
+		return err
+	})
+}
+
+// applyDeadLetterAction aplica una acción a cada mensaje que siga en la dead-letter queue y la registra
+// en dead_letter_audit con el estado anterior, todo en una transacción. Devuelve cuántos mensajes se modificaron.
+func (store *MessageStore) applyDeadLetterAction(keys []MessageKey, action, reason string, aiConfigID int, update func(tx *sql.Tx, key MessageKey) error) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin dead letter transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	applied := 0
+	for _, key := range keys {
+		var previousError, previousClass, previousContent string
+		var previousAttempts int
+		err := tx.QueryRow(`
+			SELECT COALESCE(m.last_processing_error, ''), COALESCE(m.error_class, ''),
+			       COALESCE(m.processing_attempts, 0), COALESCE(m.content, '')
+			FROM messages m
+			WHERE m.id = ? AND m.chat_jid = ?
+			  AND `+deadLetterCondition+`
+			FOR UPDATE
+		`, key.ID, key.ChatJID, maxProcessingAttempts).Scan(&previousError, &previousClass, &previousAttempts, &previousContent)
+		if err == sql.ErrNoRows {
+			continue // Ya salió de la dead-letter queue (otra acción o reprocesamiento manual)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read dead letter %s: %v", key.ID, err)
+		}
+
+		if err := update(tx, key); err != nil {
+			return 0, fmt.Errorf("failed to apply %s to message %s: %v", action, key.ID, err)
+		}
+
+		// El contenido anterior solo se guarda cuando la acción lo cambia
+		var auditContent sql.NullString
+		if action == DeadLetterActionEdit {
+			auditContent = sql.NullString{String: previousContent, Valid: true}
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO dead_letter_audit
+				(message_id, chat_jid, action, reason, ai_config_id, previous_error, previous_error_class,
+				 previous_attempts, previous_content, performed_by)
+			VALUES (?, ?, ?, NULLIF(?, ''), NULLIF(?, 0), ?, NULLIF(?, ''), ?, ?, ?)
+		`, key.ID, key.ChatJID, action, reason, aiConfigID, previousError, previousClass,
+			previousAttempts, auditContent, store.instanceID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to write dead letter audit: %v", err)
+		}
+		applied++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit dead letter action: %v", err)
+	}
+
+	fmt.Printf("🪦 Dead-letter queue: %s aplicado a %d de %d mensaje(s)\n", action, applied, len(keys))
+	return applied, nil
+}
+
+// GetDeadLetterAudit obtiene las últimas acciones sobre la dead-letter queue
+func (store *MessageStore) GetDeadLetterAudit(limit int) ([]DeadLetterAuditEntry, error) {
+	rows, err := store.db.Query(`
+		SELECT id, message_id, chat_jid, action, COALESCE(reason, ''), COALESCE(ai_config_id, 0),
+		       COALESCE(previous_error, ''), COALESCE(previous_error_class, ''), previous_attempts,
+		       COALESCE(previous_content, ''), COALESCE(performed_by, ''), created_at
+		FROM dead_letter_audit
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dead letter audit: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []DeadLetterAuditEntry{}
+	for rows.Next() {
+		var e DeadLetterAuditEntry
+		err := rows.Scan(&e.ID, &e.MessageID, &e.ChatJID, &e.Action, &e.Reason, &e.AIConfigID,
+			&e.PreviousError, &e.PreviousErrorClass, &e.PreviousAttempts,
+			&e.PreviousContent, &e.PerformedBy, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
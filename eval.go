@@ -0,0 +1,595 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// evalDefaultPhone es el teléfono que se usa si el caso no define uno
+const evalDefaultPhone = "+5491100000000"
+
+// evalFields son los campos de CargaData que se comparan contra el resultado esperado
+// (correo, puntoReferencia y observaciones son texto libre y no se evalúan)
+var evalFields = []string{
+	"material", "presentacion", "peso", "tipoEquipo",
+	"localidadCarga", "localidadDescarga", "fechaCarga", "fechaDescarga",
+	"telefono", "precio", "formaDePago",
+}
+
+// EvalCase es un caso del golden dataset (una línea del archivo JSONL)
+type EvalCase struct {
+	ID            string      `json:"id"`
+	Message       string      `json:"message"`
+	Phone         string      `json:"phone,omitempty"`
+	ReferenceTime string      `json:"reference_time,omitempty"` // RFC3339; fecha que el modelo toma como "hoy"
+	Expected      []CargaData `json:"expected"`                 // [] = el mensaje no tiene cargas
+}
+
+// EvalRecording es la respuesta de IA grabada para un caso (modo -record / -replay)
+type EvalRecording struct {
+	ID       string `json:"id"`
+	Response string `json:"response,omitempty"` // Respuesta final de la IA (después de reparaciones)
+	Error    string `json:"error,omitempty"`    // Error de la llamada a la IA (sin respuesta)
+}
+
+// EvalFieldScore es la precisión de un campo sobre todas las cargas esperadas
+type EvalFieldScore struct {
+	Field    string  `json:"field"`
+	Correct  int     `json:"correct"`
+	Total    int     `json:"total"`
+	Accuracy float64 `json:"accuracy"`
+}
+
+// EvalEmptyStats mide la detección de mensajes sin cargas (array vacío), que es lo que
+// baja la confianza del teléfono en UpdatePhoneProfiling. Positivo = "sin cargas".
+type EvalEmptyStats struct {
+	TruePositives  int     `json:"true_positives"`
+	FalsePositives int     `json:"false_positives"` // Tenía cargas y se devolvió []
+	FalseNegatives int     `json:"false_negatives"` // No tenía cargas y se extrajo algo (o falló)
+	TrueNegatives  int     `json:"true_negatives"`
+	Precision      float64 `json:"precision"`
+	Recall         float64 `json:"recall"`
+}
+
+// EvalCaseResult es el resultado de un caso que no coincidió con lo esperado
+type EvalCaseResult struct {
+	ID         string   `json:"id"`
+	Status     string   `json:"status"`
+	Error      string   `json:"error,omitempty"`
+	Expected   int      `json:"expected_cargas"`
+	Actual     int      `json:"actual_cargas"`
+	Mismatches []string `json:"mismatches,omitempty"`
+}
+
+// EvalReport es el reporte completo de una evaluación
+type EvalReport struct {
+	Mode            string           `json:"mode"` // "live", "record" o "replay"
+	Dataset         string           `json:"dataset"`
+	Cases           int              `json:"cases"`
+	Errors          int              `json:"errors"`        // Casos con status error
+	CountMatches    int              `json:"count_matches"` // Casos con la cantidad de cargas esperada
+	Fields          []EvalFieldScore `json:"fields"`
+	OverallAccuracy float64          `json:"overall_accuracy"`
+	Empty           EvalEmptyStats   `json:"empty"`
+	Failures        []EvalCaseResult `json:"failures"`
+	Duration        string           `json:"duration"`
+}
+
+// runEvalCommand ejecuta el subcomando "eval" y devuelve el código de salida.
+//
+//	loader-meow eval -dataset golden.jsonl [-record grabacion.jsonl | -replay grabacion.jsonl] [-json reporte.json] [-min-accuracy 0.9] [-accounting]
+func runEvalCommand(args []string) int {
+	fs := flag.NewFlagSet("eval", flag.ContinueOnError)
+	datasetPath := fs.String("dataset", "", "Archivo JSONL con los casos (id, message, phone, reference_time, expected)")
+	recordPath := fs.String("record", "", "Graba las respuestas de la IA en este archivo JSONL")
+	replayPath := fs.String("replay", "", "Usa las respuestas grabadas de este archivo (offline, sin BD ni proveedores)")
+	jsonPath := fs.String("json", "", "Escribe el reporte en formato JSON en este archivo")
+	minAccuracy := fs.Float64("min-accuracy", 0, "Termina con código 1 si la precisión global es menor (0-1)")
+	accounting := fs.Bool("accounting", false, "Cuenta las llamadas como uso real (ai_call_log, requests_today, éxito/error de la config)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *datasetPath == "" {
+		fmt.Fprintln(os.Stderr, "❌ Falta -dataset")
+		fs.Usage()
+		return 2
+	}
+	if *recordPath != "" && *replayPath != "" {
+		fmt.Fprintln(os.Stderr, "❌ -record y -replay no se pueden usar juntos")
+		return 2
+	}
+
+	cases, err := loadEvalCases(*datasetPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error leyendo dataset: %v\n", err)
+		return 1
+	}
+
+	var report *EvalReport
+	if *replayPath != "" {
+		recordings, err := loadEvalRecordings(*replayPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Error leyendo grabación: %v\n", err)
+			return 1
+		}
+		report, err = runEvalReplay(cases, recordings)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Error inicializando el replay: %v\n", err)
+			return 1
+		}
+	} else {
+		processor, err := newEvalProcessor(*accounting)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Error inicializando el procesador: %v\n", err)
+			return 1
+		}
+
+		var recordings []EvalRecording
+		report, recordings = runEvalLive(processor, cases)
+
+		if *recordPath != "" {
+			report.Mode = "record"
+			if err := saveEvalRecordings(*recordPath, recordings); err != nil {
+				fmt.Fprintf(os.Stderr, "❌ Error guardando grabación: %v\n", err)
+				return 1
+			}
+			fmt.Printf("💾 %d respuestas grabadas en %s\n", len(recordings), *recordPath)
+		}
+	}
+	report.Dataset = *datasetPath
+
+	printEvalReport(report)
+
+	if *jsonPath != "" {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		if err := os.WriteFile(*jsonPath, data, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Error guardando reporte: %v\n", err)
+			return 1
+		}
+	}
+
+	if report.OverallAccuracy < *minAccuracy {
+		fmt.Printf("❌ Precisión global %.1f%% menor al mínimo %.1f%%\n", report.OverallAccuracy*100, *minAccuracy*100)
+		return 1
+	}
+
+	return 0
+}
+
+// newEvalProcessor crea un MessageProcessor conectado a la BD y a los proveedores configurados.
+// Solo se usa SimulateMessage: no se guardan resultados ni se sube nada a Supabase.
+// Sin accounting las llamadas tampoco quedan en ai_call_log ni en los contadores de las configs.
+func newEvalProcessor(accounting bool) (*MessageProcessor, error) {
+	messageStore, err := NewMessageStore()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize message store: %v", err)
+	}
+
+	if err := messageStore.InitAIConfigTables(); err != nil {
+		return nil, fmt.Errorf("failed to initialize AI config tables: %v", err)
+	}
+
+	keysManager, err := NewAPIKeysManager()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create API keys manager: %v", err)
+	}
+
+	processor, err := NewMessageProcessor(messageStore, waLog.Stdout("Eval", "WARN", true), keysManager,
+		NewAIConfigManager(messageStore.db), NewSystemConfigManager(messageStore.db))
+	if err != nil {
+		return nil, err
+	}
+
+	if !accounting {
+		processor.aiProviderService.DisableAccounting()
+	}
+	return processor, nil
+}
+
+// evalSeedFile es el seed de la BD: el modo replay toma de acá la configuración y los sinónimos
+// de fábrica, para validar con los mismos valores que una instalación nueva sin tocar la BD
+const evalSeedFile = "migrations/create_ai_config_tables.sql"
+
+// sqlStringLiteral encuentra los strings entre comillas simples de un INSERT (dos comillas seguidas son una comilla escapada)
+var sqlStringLiteral = regexp.MustCompile(`'((?:[^']|'')*)'`)
+
+// loadEvalSeeds lee del seed SQL los valores de system_configs y los sinónimos de catalog_synonyms
+func loadEvalSeeds(path string) (map[string]string, []CatalogSynonym, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	configs := make(map[string]string)
+	var synonyms []CatalogSynonym
+	for _, stmt := range splitSQLStatements(string(data)) {
+		stmt = strings.TrimSpace(stmt)
+		values := stmt
+		if i := strings.Index(stmt, "VALUES"); i >= 0 {
+			values = stmt[i:]
+		}
+		var fields []string
+		for _, m := range sqlStringLiteral.FindAllStringSubmatch(values, -1) {
+			fields = append(fields, strings.ReplaceAll(m[1], "''", "'"))
+		}
+
+		switch {
+		case strings.HasPrefix(stmt, "INSERT IGNORE INTO system_configs (config_key, config_value, description)"):
+			for i := 0; i+2 < len(fields); i += 3 {
+				configs[fields[i]] = fields[i+1]
+			}
+		case strings.HasPrefix(stmt, "INSERT IGNORE INTO catalog_synonyms (kind, synonym, synonym_key, value)"):
+			for i := 0; i+3 < len(fields); i += 4 {
+				synonyms = append(synonyms, CatalogSynonym{Kind: fields[i], Synonym: fields[i+1], Value: fields[i+3]})
+			}
+		}
+	}
+	return configs, synonyms, nil
+}
+
+// newEvalReplayProcessor crea un MessageProcessor sin BD ni red para validar respuestas grabadas
+// con los mismos pasos que SimulateMessage: la configuración y los sinónimos salen del seed de la BD
+// y los catálogos son los incluidos en la app
+func newEvalReplayProcessor() (*MessageProcessor, error) {
+	configs, synonyms, err := loadEvalSeeds(evalSeedFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read seed %s: %v", evalSeedFile, err)
+	}
+
+	byKind := make(map[string]map[string]string)
+	for _, s := range synonyms {
+		if byKind[s.Kind] == nil {
+			byKind[s.Kind] = make(map[string]string)
+		}
+		byKind[s.Kind][catalogMatchKey(s.Synonym)] = s.Value
+	}
+	catalogs.setSynonyms(byKind)
+
+	gazetteer, err := NewMemoryGazetteer()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load gazetteer: %v", err)
+	}
+
+	return &MessageProcessor{
+		aiProviderService:   NewAIProviderService(nil, nil),
+		systemConfigManager: NewStaticSystemConfigManager(configs),
+		gazetteer:           gazetteer,
+		logger:              waLog.Noop,
+	}, nil
+}
+
+// runEvalLive corre cada caso por SimulateMessage y devuelve el reporte y las respuestas para grabar
+func runEvalLive(processor *MessageProcessor, cases []EvalCase) (*EvalReport, []EvalRecording) {
+	startTime := time.Now()
+	report := &EvalReport{Mode: "live"}
+	recordings := make([]EvalRecording, 0, len(cases))
+
+	for i, c := range cases {
+		fmt.Printf("🧪 [%d/%d] %s\n", i+1, len(cases), c.ID)
+
+		referenceTime, _ := c.referenceTime()
+		result := processor.SimulateMessageAt(c.Message, c.phone(), referenceTime)
+
+		recording := EvalRecording{ID: c.ID, Response: result.AIResponse}
+		if result.Status == "error" && result.AIResponse == "" {
+			recording.Error = result.ErrorMessage
+		}
+		recordings = append(recordings, recording)
+
+		report.addCase(c, result)
+	}
+
+	report.finish(time.Since(startTime))
+	return report, recordings
+}
+
+// runEvalReplay corre cada caso con su respuesta grabada (sin BD, sin red)
+func runEvalReplay(cases []EvalCase, recordings map[string]EvalRecording) (*EvalReport, error) {
+	startTime := time.Now()
+	report := &EvalReport{Mode: "replay"}
+
+	processor, err := newEvalReplayProcessor()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range cases {
+		recording, ok := recordings[c.ID]
+
+		var result ProcessingResult
+		switch {
+		case !ok:
+			result = ProcessingResult{Status: "error", ErrorMessage: "sin respuesta grabada para el caso"}
+		case recording.Response == "" && recording.Error != "":
+			result = ProcessingResult{Status: "error", ErrorMessage: recording.Error}
+		default:
+			result = processor.SimulateRecordedResponse(c.Message, c.phone(), []byte(recording.Response))
+		}
+
+		report.addCase(c, result)
+	}
+
+	report.finish(time.Since(startTime))
+	return report, nil
+}
+
+// addCase compara el resultado de un caso con lo esperado y acumula las métricas
+func (r *EvalReport) addCase(c EvalCase, result ProcessingResult) {
+	r.Cases++
+
+	var actual []CargaData
+	if result.Status == "success" {
+		json.Unmarshal([]byte(result.AIResponse), &actual)
+	} else {
+		r.Errors++
+	}
+
+	caseResult := EvalCaseResult{
+		ID:       c.ID,
+		Status:   result.Status,
+		Expected: len(c.Expected),
+		Actual:   len(actual),
+	}
+	if result.Status != "success" {
+		caseResult.Error = result.ErrorMessage
+	}
+
+	// Detección de mensajes sin cargas (solo cuenta como "vacío" un success con [])
+	expectedEmpty := len(c.Expected) == 0
+	predictedEmpty := result.Status == "success" && len(actual) == 0
+	switch {
+	case expectedEmpty && predictedEmpty:
+		r.Empty.TruePositives++
+	case !expectedEmpty && predictedEmpty:
+		r.Empty.FalsePositives++
+	case expectedEmpty && !predictedEmpty:
+		r.Empty.FalseNegatives++
+	default:
+		r.Empty.TrueNegatives++
+	}
+
+	if len(actual) == len(c.Expected) && result.Status == "success" {
+		r.CountMatches++
+	}
+
+	// Campos: se comparan las cargas por posición; las que faltan cuentan como incorrectas
+	if r.Fields == nil {
+		r.Fields = make([]EvalFieldScore, len(evalFields))
+		for i, field := range evalFields {
+			r.Fields[i].Field = field
+		}
+	}
+	for i, expected := range c.Expected {
+		expectedValues := cargaFieldValues(expected)
+		var actualValues map[string]string
+		if i < len(actual) {
+			actualValues = cargaFieldValues(actual[i])
+		}
+
+		for j, field := range evalFields {
+			r.Fields[j].Total++
+			if actualValues != nil && evalValuesMatch(field, expectedValues[field], actualValues[field]) {
+				r.Fields[j].Correct++
+			} else if actualValues != nil {
+				caseResult.Mismatches = append(caseResult.Mismatches,
+					fmt.Sprintf("carga %d, %s: esperado %q, obtenido %q", i+1, field, expectedValues[field], actualValues[field]))
+			}
+		}
+	}
+
+	if result.Status != "success" || len(actual) != len(c.Expected) || len(caseResult.Mismatches) > 0 {
+		r.Failures = append(r.Failures, caseResult)
+	}
+}
+
+// finish calcula los porcentajes finales del reporte
+func (r *EvalReport) finish(elapsed time.Duration) {
+	var correct, total int
+	for i := range r.Fields {
+		if r.Fields[i].Total > 0 {
+			r.Fields[i].Accuracy = float64(r.Fields[i].Correct) / float64(r.Fields[i].Total)
+		}
+		correct += r.Fields[i].Correct
+		total += r.Fields[i].Total
+	}
+	if total > 0 {
+		r.OverallAccuracy = float64(correct) / float64(total)
+	}
+
+	if predicted := r.Empty.TruePositives + r.Empty.FalsePositives; predicted > 0 {
+		r.Empty.Precision = float64(r.Empty.TruePositives) / float64(predicted)
+	}
+	if expected := r.Empty.TruePositives + r.Empty.FalseNegatives; expected > 0 {
+		r.Empty.Recall = float64(r.Empty.TruePositives) / float64(expected)
+	}
+
+	r.Duration = elapsed.Round(time.Millisecond).String()
+}
+
+// printEvalReport imprime el reporte en formato legible
+func printEvalReport(r *EvalReport) {
+	fmt.Printf("\n📊 EVALUACIÓN (%s) - %s\n", r.Mode, r.Dataset)
+	fmt.Printf("Casos: %d | Errores: %d | Cantidad de cargas correcta: %d/%d | Duración: %s\n\n",
+		r.Cases, r.Errors, r.CountMatches, r.Cases, r.Duration)
+
+	fmt.Printf("%-20s %10s %8s\n", "Campo", "Correctos", "Precisión")
+	for _, f := range r.Fields {
+		fmt.Printf("%-20s %5d/%-4d %7.1f%%\n", f.Field, f.Correct, f.Total, f.Accuracy*100)
+	}
+	fmt.Printf("%-20s %18.1f%%\n\n", "GLOBAL", r.OverallAccuracy*100)
+
+	fmt.Printf("Mensajes sin cargas ([]): precisión %.1f%% | recall %.1f%% (TP %d, FP %d, FN %d, TN %d)\n",
+		r.Empty.Precision*100, r.Empty.Recall*100,
+		r.Empty.TruePositives, r.Empty.FalsePositives, r.Empty.FalseNegatives, r.Empty.TrueNegatives)
+
+	if len(r.Failures) > 0 {
+		fmt.Printf("\n❌ Casos con diferencias (%d):\n", len(r.Failures))
+		for _, f := range r.Failures {
+			fmt.Printf("- %s [%s] cargas esperadas %d, obtenidas %d\n", f.ID, f.Status, f.Expected, f.Actual)
+			if f.Error != "" {
+				fmt.Printf("    %s\n", f.Error)
+			}
+			for _, m := range f.Mismatches {
+				fmt.Printf("    %s\n", m)
+			}
+		}
+	}
+}
+
+// cargaFieldValues devuelve los campos de una carga indexados por su nombre JSON
+func cargaFieldValues(carga CargaData) map[string]string {
+	return map[string]string{
+		"material":          carga.Material,
+		"presentacion":      carga.Presentacion,
+		"peso":              carga.Peso,
+		"tipoEquipo":        carga.TipoEquipo,
+		"localidadCarga":    carga.LocalidadCarga,
+		"localidadDescarga": carga.LocalidadDescarga,
+		"fechaCarga":        carga.FechaCarga,
+		"fechaDescarga":     carga.FechaDescarga,
+		"telefono":          carga.Telefono,
+		"precio":            carga.Precio,
+		"formaDePago":       carga.FormaDePago,
+	}
+}
+
+// evalValuesMatch compara un valor esperado con el obtenido según el tipo de campo
+func evalValuesMatch(field, expected, actual string) bool {
+	switch field {
+	case "peso", "precio":
+		expectedNumber, errExpected := strconv.ParseFloat(strings.TrimSpace(expected), 64)
+		actualNumber, errActual := strconv.ParseFloat(strings.TrimSpace(actual), 64)
+		if errExpected == nil && errActual == nil {
+			return expectedNumber == actualNumber
+		}
+	case "telefono":
+		return onlyDigits(expected) == onlyDigits(actual)
+	}
+	return normalizeEvalText(expected) == normalizeEvalText(actual)
+}
+
+// evalAccentReplacer quita tildes para comparar localidades y catálogos
+var evalAccentReplacer = strings.NewReplacer("á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u", "ü", "u", "ñ", "n")
+
+// normalizeEvalText normaliza un texto (minúsculas, sin tildes, espacios y comas uniformes)
+func normalizeEvalText(value string) string {
+	value = evalAccentReplacer.Replace(strings.ToLower(value))
+	parts := strings.Split(value, ",")
+	for i, part := range parts {
+		parts[i] = strings.Join(strings.Fields(part), " ")
+	}
+	return strings.Trim(strings.Join(parts, ","), ". ")
+}
+
+// onlyDigits devuelve solo los dígitos de un texto
+func onlyDigits(value string) string {
+	var b strings.Builder
+	for _, ch := range value {
+		if ch >= '0' && ch <= '9' {
+			b.WriteRune(ch)
+		}
+	}
+	return b.String()
+}
+
+// phone devuelve el teléfono del caso o el teléfono por defecto
+func (c EvalCase) phone() string {
+	if c.Phone != "" {
+		return c.Phone
+	}
+	return evalDefaultPhone
+}
+
+// referenceTime interpreta reference_time (cero si no está definido)
+func (c EvalCase) referenceTime() (time.Time, error) {
+	if c.ReferenceTime == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, c.ReferenceTime)
+}
+
+// loadEvalCases lee el golden dataset (una línea JSON por caso; se ignoran líneas vacías y comentarios #)
+func loadEvalCases(path string) ([]EvalCase, error) {
+	var cases []EvalCase
+	err := readJSONLines(path, func(line int, data []byte) error {
+		var c EvalCase
+		if err := json.Unmarshal(data, &c); err != nil {
+			return fmt.Errorf("línea %d: %v", line, err)
+		}
+		if c.ID == "" {
+			c.ID = fmt.Sprintf("linea-%d", line)
+		}
+		if strings.TrimSpace(c.Message) == "" {
+			return fmt.Errorf("línea %d (%s): message vacío", line, c.ID)
+		}
+		if _, err := c.referenceTime(); err != nil {
+			return fmt.Errorf("línea %d (%s): reference_time inválido: %v", line, c.ID, err)
+		}
+		cases = append(cases, c)
+		return nil
+	})
+	return cases, err
+}
+
+// loadEvalRecordings lee las respuestas grabadas indexadas por ID de caso
+func loadEvalRecordings(path string) (map[string]EvalRecording, error) {
+	recordings := make(map[string]EvalRecording)
+	err := readJSONLines(path, func(line int, data []byte) error {
+		var r EvalRecording
+		if err := json.Unmarshal(data, &r); err != nil {
+			return fmt.Errorf("línea %d: %v", line, err)
+		}
+		recordings[r.ID] = r
+		return nil
+	})
+	return recordings, err
+}
+
+// saveEvalRecordings guarda las respuestas grabadas en formato JSONL
+func saveEvalRecordings(path string, recordings []EvalRecording) error {
+	var b strings.Builder
+	for _, r := range recordings {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		b.Write(data)
+		b.WriteString("\n")
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// readJSONLines recorre un archivo JSONL llamando a fn por cada línea no vacía
+func readJSONLines(path string, fn func(line int, data []byte) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := fn(line, []byte(text)); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
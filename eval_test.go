@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEvalValuesMatch(t *testing.T) {
+	tests := []struct {
+		field    string
+		expected string
+		actual   string
+		want     bool
+	}{
+		{"peso", "30000", "30000.0", true},
+		{"peso", "30000", "28000", false},
+		{"precio", "", "", true},
+		{"precio", "1800000", "1.800.000", false},
+		{"telefono", "+5493415551234", "+54 9 341 555-1234", true},
+		{"telefono", "+5493415551234", "+5493415551235", false},
+		{"localidadCarga", "Junín, Buenos Aires, Argentina", "junin,  Buenos Aires ,Argentina", true},
+		{"localidadDescarga", "Bahía Blanca, Buenos Aires, Argentina", "Bahia Blanca, Buenos Aires, Argentina.", true},
+		{"localidadDescarga", "Rosario, Santa Fe, Argentina", "Santa Fe, Santa Fe, Argentina", false},
+		{"material", "Maíz", "MAIZ", true},
+		{"fechaDescarga", "12/03/2025", "11/03/2025", false},
+	}
+
+	for _, tt := range tests {
+		if got := evalValuesMatch(tt.field, tt.expected, tt.actual); got != tt.want {
+			t.Errorf("evalValuesMatch(%s, %q, %q) = %v, se esperaba %v", tt.field, tt.expected, tt.actual, got, tt.want)
+		}
+	}
+}
+
+// evalSuccess arma un ProcessingResult exitoso con las cargas dadas
+func evalSuccess(t *testing.T, cargas ...CargaData) ProcessingResult {
+	t.Helper()
+	data, err := json.Marshal(cargas)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	if cargas == nil {
+		data = []byte("[]")
+	}
+	return ProcessingResult{Status: "success", AIResponse: string(data)}
+}
+
+func TestEvalReportScoring(t *testing.T) {
+	soja := CargaData{Material: "Soja", Peso: "30000", FechaCarga: "11/03/2025", FechaDescarga: "12/03/2025", Telefono: "+5493415551234"}
+	sojaMismaFecha := soja
+	sojaMismaFecha.FechaDescarga = "11/03/2025"
+
+	report := &EvalReport{}
+	report.addCase(EvalCase{ID: "exacto", Expected: []CargaData{soja}}, evalSuccess(t, soja))
+	report.addCase(EvalCase{ID: "fecha-mal", Expected: []CargaData{soja}}, evalSuccess(t, sojaMismaFecha))
+	report.addCase(EvalCase{ID: "vacio-ok", Expected: []CargaData{}}, evalSuccess(t))
+	report.addCase(EvalCase{ID: "vacio-de-mas", Expected: []CargaData{soja}}, evalSuccess(t))
+	report.addCase(EvalCase{ID: "error", Expected: []CargaData{}}, ProcessingResult{Status: "error", ErrorMessage: "AI processing failed"})
+	report.finish(time.Second)
+
+	if report.Cases != 5 || report.Errors != 1 || report.CountMatches != 3 {
+		t.Errorf("casos/errores/cantidad = %d/%d/%d, se esperaba 5/1/3", report.Cases, report.Errors, report.CountMatches)
+	}
+
+	scores := make(map[string]EvalFieldScore)
+	for _, f := range report.Fields {
+		scores[f.Field] = f
+	}
+	// 3 cargas esperadas: una exacta, una con la fecha de descarga mal y una que falta
+	if got := scores["fechaDescarga"]; got.Correct != 1 || got.Total != 3 {
+		t.Errorf("fechaDescarga = %d/%d, se esperaba 1/3", got.Correct, got.Total)
+	}
+	if got := scores["material"]; got.Correct != 2 || got.Total != 3 {
+		t.Errorf("material = %d/%d, se esperaba 2/3", got.Correct, got.Total)
+	}
+	wantOverall := float64(2*len(evalFields)-1) / float64(3*len(evalFields))
+	if report.OverallAccuracy != wantOverall {
+		t.Errorf("OverallAccuracy = %f, se esperaba %f", report.OverallAccuracy, wantOverall)
+	}
+
+	// Sin cargas: TP vacio-ok, FP vacio-de-mas, FN error, TN exacto y fecha-mal
+	empty := report.Empty
+	if empty.TruePositives != 1 || empty.FalsePositives != 1 || empty.FalseNegatives != 1 || empty.TrueNegatives != 2 {
+		t.Errorf("Empty = %+v, se esperaba TP 1, FP 1, FN 1, TN 2", empty)
+	}
+	if empty.Precision != 0.5 || empty.Recall != 0.5 {
+		t.Errorf("precisión/recall = %f/%f, se esperaba 0.5/0.5", empty.Precision, empty.Recall)
+	}
+
+	failed := make([]string, 0, len(report.Failures))
+	for _, f := range report.Failures {
+		failed = append(failed, f.ID)
+	}
+	if len(failed) != 3 || failed[0] != "fecha-mal" || failed[1] != "vacio-de-mas" || failed[2] != "error" {
+		t.Errorf("Failures = %v, se esperaba [fecha-mal vacio-de-mas error]", failed)
+	}
+	if len(report.Failures[0].Mismatches) != 1 {
+		t.Errorf("fecha-mal debería tener una sola diferencia: %v", report.Failures[0].Mismatches)
+	}
+}
+
+func TestLoadEvalCasesSkipsComments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "golden.jsonl")
+	content := "# comentario\n\n" +
+		`{"id": "a", "message": "Cargo soja", "expected": []}` + "\n" +
+		"   # comentario con sangría\n" +
+		`{"message": "Cargo maíz", "reference_time": "2025-03-10T09:00:00-03:00", "expected": []}` + "\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases, err := loadEvalCases(path)
+	if err != nil {
+		t.Fatalf("loadEvalCases: %v", err)
+	}
+	if len(cases) != 2 || cases[0].ID != "a" || cases[1].ID != "linea-5" {
+		t.Errorf("casos = %+v, se esperaban a y linea-5", cases)
+	}
+	if cases[1].phone() != evalDefaultPhone {
+		t.Errorf("phone = %s, se esperaba el teléfono por defecto", cases[1].phone())
+	}
+}
+
+// TestEvalReplayGolden corre el golden dataset con sus respuestas grabadas: las diferencias de campos son
+// errores del modelo, pero ningún caso debería fallar en el pipeline (validación, ubicaciones, catálogos)
+func TestEvalReplayGolden(t *testing.T) {
+	cases, err := loadEvalCases("evaluation/golden.jsonl")
+	if err != nil {
+		t.Fatalf("loadEvalCases: %v", err)
+	}
+	recordings, err := loadEvalRecordings("evaluation/golden.recorded.jsonl")
+	if err != nil {
+		t.Fatalf("loadEvalRecordings: %v", err)
+	}
+
+	report, err := runEvalReplay(cases, recordings)
+	if err != nil {
+		t.Fatalf("runEvalReplay: %v", err)
+	}
+	if report.Errors != 0 || report.CountMatches != report.Cases {
+		t.Errorf("errores %d, cantidad correcta %d/%d; fallas: %+v", report.Errors, report.CountMatches, report.Cases, report.Failures)
+	}
+}
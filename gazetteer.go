@@ -0,0 +1,653 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	_ "embed"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gazetteerDataset es el nomenclador incluido en la app: las 24 provincias y las localidades
+// más frecuentes en las cargas. Para cubrir todo el país se importa el export de georef (ver Gazetteer.Load).
+//
+//go:embed data/gazetteer_ar.csv
+var gazetteerDataset []byte
+
+// errGazetteerNotFound indica que la dirección no corresponde a ningún lugar del nomenclador
+var errGazetteerNotFound = errors.New("no está en el nomenclador de localidades")
+
+// Tipos de lugar del nomenclador (gazetteer.kind)
+const (
+	GazetteerProvincia    = "provincia"
+	GazetteerDepartamento = "departamento"
+	GazetteerLocalidad    = "localidad"
+)
+
+// gazetteerInsertBatch es la cantidad de lugares por INSERT al cargar el nomenclador
+const gazetteerInsertBatch = 500
+
+// gazetteerProvinceAliases son otras formas de escribir una provincia (por clave normalizada)
+var gazetteerProvinceAliases = map[string]string{
+	"bsas":                   "buenosaires",
+	"pba":                    "buenosaires",
+	"provinciadebsas":        "buenosaires",
+	"provinciadebuenosaires": "buenosaires",
+	"caba":                   "ciudadautonomadebuenosaires",
+	"capitalfederal":         "ciudadautonomadebuenosaires",
+	"ciudaddebuenosaires":    "ciudadautonomadebuenosaires",
+	"tierradelfuego":         "tierradelfuegoantartidaeislasdelatlanticosur",
+	"stafe":                  "santafe",
+	"sgodelestero":           "santiagodelestero",
+}
+
+// gazetteerPlaceAliases son nombres de uso común de localidades cuyo nombre oficial es otro
+var gazetteerPlaceAliases = map[string]string{
+	"catamarca":         "sanfernandodelvalledecatamarca",
+	"tucuman":           "sanmigueldetucuman",
+	"jujuy":             "sansalvadordejujuy",
+	"bariloche":         "sancarlosdebariloche",
+	"bolivar":           "sancarlosdebolivar",
+	"sannicolas":        "sannicolasdelosarroyos",
+	"oran":              "sanramondelanuevaoran",
+	"metan":             "sanjosedemetan",
+	"saenzpena":         "presidenciaroquesaenzpena",
+	"escobar":           "belendeescobar",
+	"brandsen":          "coronelbrandsen",
+	"patagones":         "carmendepatagones",
+	"nuevedejulio":      "9dejulio",
+	"veinticincodemayo": "25demayo",
+}
+
+// GazetteerPlace es una provincia, departamento o localidad con su centroide
+type GazetteerPlace struct {
+	Kind         string  `json:"kind"`
+	Nombre       string  `json:"nombre"`
+	Departamento string  `json:"departamento"`
+	Provincia    string  `json:"provincia"`
+	Lat          float64 `json:"lat"`
+	Lng          float64 `json:"lng"`
+}
+
+// String arma el nombre completo del lugar ("Pergamino, Pergamino, Buenos Aires")
+func (p GazetteerPlace) String() string {
+	parts := []string{p.Nombre}
+	if p.Departamento != "" && p.Kind == GazetteerLocalidad {
+		parts = append(parts, p.Departamento)
+	}
+	if p.Kind != GazetteerProvincia {
+		parts = append(parts, p.Provincia)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// GazetteerStatus resume el nomenclador cargado para la UI
+type GazetteerStatus struct {
+	Provincias    int        `json:"provincias"`
+	Departamentos int        `json:"departamentos"`
+	Localidades   int        `json:"localidades"`
+	Path          string     `json:"path"` // "" = dataset incluido en la app
+	Validation    bool       `json:"validation"`
+	LoadedAt      *time.Time `json:"loaded_at"`
+}
+
+// Gazetteer es el nomenclador de Argentina en MySQL: resuelve localidades sin salir a la red
+type Gazetteer struct {
+	db                  *sql.DB
+	systemConfigManager *SystemConfigManager
+	places              []GazetteerPlace // Sin base de datos: lugares en memoria (ver NewMemoryGazetteer)
+}
+
+// NewGazetteer crea el nomenclador sobre la base de la configuración del sistema
+func NewGazetteer(systemConfigManager *SystemConfigManager) *Gazetteer {
+	return &Gazetteer{db: systemConfigManager.db, systemConfigManager: systemConfigManager}
+}
+
+// NewMemoryGazetteer crea un nomenclador sin base de datos con el dataset incluido en la app,
+// para resolver igual que en vivo donde no hay MySQL (eval replay, tests)
+func NewMemoryGazetteer() (*Gazetteer, error) {
+	places, err := parseGazetteerCSV("gazetteer_ar.csv", gazetteerDataset)
+	if err != nil {
+		return nil, err
+	}
+	return &Gazetteer{places: deriveGazetteerDepartments(places)}, nil
+}
+
+// gazetteerKey normaliza un nombre para compararlo (igual que los catálogos: "Río Cuarto" → "riocuarto")
+func gazetteerKey(name string) string {
+	return catalogMatchKey(name)
+}
+
+// gazetteerAddressParts separa una dirección por comas, sin "Argentina" ni partes vacías
+func gazetteerAddressParts(direccion string) []string {
+	var parts []string
+	for _, part := range strings.Split(direccion, ",") {
+		part = strings.TrimSpace(part)
+		key := gazetteerKey(part)
+		if key == "" || key == "argentina" || key == "republicaargentina" {
+			continue
+		}
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+// Loaded indica si el nomenclador tiene lugares cargados
+func (g *Gazetteer) Loaded() bool {
+	if g.db == nil {
+		return len(g.places) > 0
+	}
+
+	var exists bool
+	err := g.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM gazetteer)`).Scan(&exists)
+	return err == nil && exists
+}
+
+// Resolve busca una dirección con formato "Localidad, Provincia, Argentina".
+// Devuelve el lugar encontrado (localidad, departamento o, si solo viene la provincia, la provincia);
+// nil sin error si el nombre existe en varias provincias y no se indicó cuál;
+// y un error que envuelve errGazetteerNotFound si no existe.
+func (g *Gazetteer) Resolve(direccion string) (*GazetteerPlace, error) {
+	parts := gazetteerAddressParts(direccion)
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("%w: dirección vacía", errGazetteerNotFound)
+	}
+
+	provincia, err := g.findProvincia(parts[len(parts)-1])
+	if err != nil {
+		return nil, err
+	}
+	if provincia != nil {
+		parts = parts[:len(parts)-1]
+		if len(parts) == 0 {
+			return provincia, nil
+		}
+	}
+
+	// La primera parte que sea una localidad o un departamento ("Ruta 8 km 220, Pergamino" → Pergamino)
+	for _, part := range parts {
+		place, ambiguous, err := g.findPlace(part, provincia)
+		if err != nil {
+			return nil, err
+		}
+		if place != nil || ambiguous {
+			return place, nil
+		}
+		// "Tucumán, Tucumán": la provincia repetida como localidad
+		if provincia != nil && gazetteerKey(part) == gazetteerKey(provincia.Nombre) {
+			return provincia, nil
+		}
+	}
+
+	if provincia != nil {
+		return nil, fmt.Errorf("%w: %q no es una localidad de %s", errGazetteerNotFound, parts[0], provincia.Nombre)
+	}
+	return nil, fmt.Errorf("%w: %q", errGazetteerNotFound, strings.Join(parts, ", "))
+}
+
+// findProvincia busca una provincia por nombre o alias (nil si no es una provincia)
+func (g *Gazetteer) findProvincia(name string) (*GazetteerPlace, error) {
+	key := gazetteerKey(name)
+	if alias, ok := gazetteerProvinceAliases[key]; ok {
+		key = alias
+	}
+
+	if g.db == nil {
+		for _, p := range g.places {
+			if p.Kind == GazetteerProvincia && gazetteerKey(p.Nombre) == key {
+				return &p, nil
+			}
+		}
+		return nil, nil
+	}
+
+	var p GazetteerPlace
+	err := g.db.QueryRow(`
+		SELECT kind, nombre, departamento, provincia, lat, lng
+		FROM gazetteer
+		WHERE kind = ? AND name_key = ?
+		LIMIT 1
+	`, GazetteerProvincia, key).Scan(&p.Kind, &p.Nombre, &p.Departamento, &p.Provincia, &p.Lat, &p.Lng)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query gazetteer: %v", err)
+	}
+	return &p, nil
+}
+
+// findPlace busca una localidad (o si no, un departamento) por nombre, dentro de la provincia si se conoce.
+// Sin provincia, ambiguous indica que el nombre existe en más de una provincia.
+func (g *Gazetteer) findPlace(name string, provincia *GazetteerPlace) (place *GazetteerPlace, ambiguous bool, err error) {
+	key := gazetteerKey(name)
+	alias := key
+	if a, ok := gazetteerPlaceAliases[key]; ok {
+		alias = a
+	}
+
+	places, err := g.lookupPlaces(key, alias, provincia)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(places) == 0 {
+		return nil, false, nil
+	}
+
+	provincias := make(map[string]bool)
+	for _, p := range places {
+		provincias[p.Provincia] = true
+	}
+	if len(provincias) > 1 {
+		return nil, true, nil
+	}
+	return &places[0], false, nil
+}
+
+// lookupPlaces devuelve las localidades y departamentos con alguna de las dos claves, dentro de la
+// provincia si se conoce; primero las localidades y después en el orden del dataset
+func (g *Gazetteer) lookupPlaces(key, alias string, provincia *GazetteerPlace) ([]GazetteerPlace, error) {
+	if g.db == nil {
+		var localidades, departamentos []GazetteerPlace
+		for _, p := range g.places {
+			if p.Kind == GazetteerProvincia {
+				continue
+			}
+			if nameKey := gazetteerKey(p.Nombre); nameKey != key && nameKey != alias {
+				continue
+			}
+			if provincia != nil && gazetteerKey(p.Provincia) != gazetteerKey(provincia.Nombre) {
+				continue
+			}
+			if p.Kind == GazetteerLocalidad {
+				localidades = append(localidades, p)
+			} else {
+				departamentos = append(departamentos, p)
+			}
+		}
+		return append(localidades, departamentos...), nil
+	}
+
+	query := `
+		SELECT kind, nombre, departamento, provincia, lat, lng
+		FROM gazetteer
+		WHERE kind IN (?, ?) AND name_key IN (?, ?)
+	`
+	args := []interface{}{GazetteerLocalidad, GazetteerDepartamento, key, alias}
+	if provincia != nil {
+		query += ` AND provincia_key = ?`
+		args = append(args, gazetteerKey(provincia.Nombre))
+	}
+	query += ` ORDER BY kind = ? DESC, id`
+	args = append(args, GazetteerLocalidad)
+
+	rows, err := g.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query gazetteer: %v", err)
+	}
+	defer rows.Close()
+
+	var places []GazetteerPlace
+	for rows.Next() {
+		var p GazetteerPlace
+		if err := rows.Scan(&p.Kind, &p.Nombre, &p.Departamento, &p.Provincia, &p.Lat, &p.Lng); err != nil {
+			return nil, err
+		}
+		places = append(places, p)
+	}
+	return places, rows.Err()
+}
+
+// Status cuenta los lugares cargados por tipo
+func (g *Gazetteer) Status() (*GazetteerStatus, error) {
+	status := &GazetteerStatus{
+		Path:       g.systemConfigManager.GetGazetteerPath(),
+		Validation: g.systemConfigManager.GetGazetteerValidation(),
+	}
+
+	var loadedAt sql.NullTime
+	err := g.db.QueryRow(`
+		SELECT
+			COALESCE(SUM(kind = ?), 0),
+			COALESCE(SUM(kind = ?), 0),
+			COALESCE(SUM(kind = ?), 0),
+			MAX(created_at)
+		FROM gazetteer
+	`, GazetteerProvincia, GazetteerDepartamento, GazetteerLocalidad).Scan(&status.Provincias, &status.Departamentos, &status.Localidades, &loadedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get gazetteer status: %v", err)
+	}
+	if loadedAt.Valid {
+		status.LoadedAt = &loadedAt.Time
+	}
+	return status, nil
+}
+
+// gazetteerFiles lee los CSV del nomenclador: el dataset incluido o, si gazetteer_path está configurado,
+// ese archivo o todos los .csv de ese directorio (por ejemplo provincias.csv, departamentos.csv y
+// localidades.csv del export de georef)
+func gazetteerFiles(path string) (map[string][]byte, error) {
+	if path == "" {
+		return map[string][]byte{"gazetteer_ar.csv": gazetteerDataset}, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo leer el nomenclador: %v", err)
+	}
+	paths := []string{path}
+	if info.IsDir() {
+		paths, err = filepath.Glob(filepath.Join(path, "*.csv"))
+		if err != nil {
+			return nil, err
+		}
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("no hay archivos .csv en %s", path)
+		}
+	}
+
+	files := make(map[string][]byte, len(paths))
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("no se pudo leer el nomenclador: %v", err)
+		}
+		files[filepath.Base(p)] = data
+	}
+	return files, nil
+}
+
+// parseGazetteerCSV lee un CSV del nomenclador. Acepta el formato del dataset incluido (con columna tipo)
+// y los exports de georef: el tipo se deduce de las columnas (con departamento_nombre son localidades,
+// con provincia_nombre departamentos y si no, provincias).
+func parseGazetteerCSV(name string, data []byte) ([]GazetteerPlace, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: no se pudo leer el encabezado: %v", name, err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, col := range header {
+		columns[strings.ToLower(strings.TrimSpace(col))] = i
+	}
+	column := func(names ...string) int {
+		for _, n := range names {
+			if i, ok := columns[n]; ok {
+				return i
+			}
+		}
+		return -1
+	}
+
+	nombreCol := column("nombre")
+	latCol := column("centroide_lat", "lat", "latitud")
+	lngCol := column("centroide_lon", "lon", "lng", "longitud")
+	if nombreCol < 0 || latCol < 0 || lngCol < 0 {
+		return nil, fmt.Errorf("%s: faltan las columnas nombre, centroide_lat o centroide_lon", name)
+	}
+	tipoCol := column("tipo")
+	deptoCol := column("departamento_nombre", "departamento")
+	provCol := column("provincia_nombre", "provincia")
+
+	defaultKind := GazetteerProvincia
+	switch {
+	case deptoCol >= 0:
+		defaultKind = GazetteerLocalidad
+	case provCol >= 0:
+		defaultKind = GazetteerDepartamento
+	}
+
+	field := func(record []string, i int) string {
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var places []GazetteerPlace
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s, línea %d: %v", name, line, err)
+		}
+
+		lat, latErr := strconv.ParseFloat(field(record, latCol), 64)
+		lng, lngErr := strconv.ParseFloat(field(record, lngCol), 64)
+		p := GazetteerPlace{
+			Kind:         strings.ToLower(field(record, tipoCol)),
+			Nombre:       field(record, nombreCol),
+			Departamento: field(record, deptoCol),
+			Provincia:    field(record, provCol),
+			Lat:          lat,
+			Lng:          lng,
+		}
+		if p.Kind == "" {
+			p.Kind = defaultKind
+		}
+		if p.Kind == GazetteerProvincia {
+			p.Provincia = p.Nombre
+		}
+		if p.Nombre == "" || latErr != nil || lngErr != nil {
+			continue // Sin nombre o sin centroide no sirve ni para validar ni para geocodificar
+		}
+		if p.Kind != GazetteerProvincia && p.Kind != GazetteerDepartamento && p.Kind != GazetteerLocalidad {
+			return nil, fmt.Errorf("%s, línea %d: tipo desconocido %q", name, line, p.Kind)
+		}
+		places = append(places, p)
+	}
+	return places, nil
+}
+
+// deriveGazetteerDepartments agrega los departamentos que solo aparecen en las localidades,
+// con el promedio de sus centroides (así "General López, Santa Fe" se resuelve aunque no haya departamentos.csv)
+func deriveGazetteerDepartments(places []GazetteerPlace) []GazetteerPlace {
+	type deptKey struct{ provincia, departamento string }
+	explicit := make(map[deptKey]bool)
+	sums := make(map[deptKey]*GazetteerPlace)
+	counts := make(map[deptKey]int)
+	var order []deptKey
+
+	for _, p := range places {
+		switch p.Kind {
+		case GazetteerDepartamento:
+			explicit[deptKey{gazetteerKey(p.Provincia), gazetteerKey(p.Nombre)}] = true
+		case GazetteerLocalidad:
+			if p.Departamento == "" {
+				continue
+			}
+			k := deptKey{gazetteerKey(p.Provincia), gazetteerKey(p.Departamento)}
+			if sums[k] == nil {
+				sums[k] = &GazetteerPlace{Kind: GazetteerDepartamento, Nombre: p.Departamento, Provincia: p.Provincia}
+				order = append(order, k)
+			}
+			sums[k].Lat += p.Lat
+			sums[k].Lng += p.Lng
+			counts[k]++
+		}
+	}
+
+	for _, k := range order {
+		if explicit[k] {
+			continue
+		}
+		dept := *sums[k]
+		dept.Lat /= float64(counts[k])
+		dept.Lng /= float64(counts[k])
+		places = append(places, dept)
+	}
+	return places
+}
+
+// Load carga el nomenclador en MySQL si cambió el dataset (o siempre, con force).
+// Reemplaza la tabla entera en una transacción: mientras tanto se sigue usando el anterior.
+func (g *Gazetteer) Load(force bool) (*GazetteerStatus, error) {
+	path := g.systemConfigManager.GetGazetteerPath()
+	files, err := gazetteerFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hash := sha256.New()
+	hash.Write([]byte(path))
+	for _, name := range names {
+		hash.Write([]byte(name))
+		hash.Write(files[name])
+	}
+	version := hex.EncodeToString(hash.Sum(nil))
+
+	if !force && g.Loaded() {
+		if current, err := g.systemConfigManager.GetConfig("gazetteer_version"); err == nil && current == version {
+			return g.Status()
+		}
+	}
+
+	var places []GazetteerPlace
+	for _, name := range names {
+		parsed, err := parseGazetteerCSV(name, files[name])
+		if err != nil {
+			return nil, err
+		}
+		places = append(places, parsed...)
+	}
+	places = deriveGazetteerDepartments(places)
+	if len(places) == 0 {
+		return nil, fmt.Errorf("el nomenclador no tiene lugares")
+	}
+
+	if err := g.replace(places); err != nil {
+		return nil, err
+	}
+	if err := g.systemConfigManager.SetConfig("gazetteer_version", version); err != nil {
+		return nil, fmt.Errorf("failed to save gazetteer version: %v", err)
+	}
+	return g.Status()
+}
+
+// replace reemplaza los lugares del nomenclador, de a gazetteerInsertBatch por INSERT
+func (g *Gazetteer) replace(places []GazetteerPlace) error {
+	tx, err := g.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin gazetteer transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM gazetteer`); err != nil {
+		return fmt.Errorf("failed to clear gazetteer: %v", err)
+	}
+
+	for start := 0; start < len(places); start += gazetteerInsertBatch {
+		batch := places[start:min(start+gazetteerInsertBatch, len(places))]
+		values := make([]string, len(batch))
+		args := make([]interface{}, 0, len(batch)*8)
+		for i, p := range batch {
+			values[i] = "(?, ?, ?, ?, ?, ?, ?, ?)"
+			args = append(args, p.Kind, p.Nombre, gazetteerKey(p.Nombre), p.Departamento,
+				p.Provincia, gazetteerKey(p.Provincia), p.Lat, p.Lng)
+		}
+		_, err := tx.Exec(`
+			INSERT INTO gazetteer (kind, nombre, name_key, departamento, provincia, provincia_key, lat, lng)
+			VALUES `+strings.Join(values, ", "), args...)
+		if err != nil {
+			return fmt.Errorf("failed to load gazetteer: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// buscarEnNomenclador obtiene las coordenadas de una localidad o departamento del nomenclador local.
+// Las provincias no se usan: su centroide es demasiado impreciso para una carga.
+func (s *SupabaseService) buscarEnNomenclador(direccion string) *Coordenadas {
+	if s.gazetteer == nil {
+		return nil
+	}
+	place, err := s.gazetteer.Resolve(direccion)
+	if err != nil || place == nil || place.Kind == GazetteerProvincia {
+		return nil
+	}
+	return &Coordenadas{
+		Lat:              place.Lat,
+		Lng:              place.Lng,
+		Provider:         GeocodeProviderGazetteer,
+		FormattedAddress: place.String(),
+	}
+}
+
+// checkGazetteer rechaza una localidad que no existe. El nomenclador incluido no tiene todas las localidades
+// del país, así que si no la encuentra se confirma con geocoding antes de rechazarla.
+// No valida nada si la validación está desactivada o el nomenclador está vacío.
+func (p *MessageProcessor) checkGazetteer(localidad string) error {
+	if p.gazetteer == nil || !p.systemConfigManager.GetGazetteerValidation() || !p.gazetteer.Loaded() {
+		return nil
+	}
+
+	_, err := p.gazetteer.Resolve(localidad)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, errGazetteerNotFound) {
+		// Un error de la base no rechaza la carga: el geocoding la valida después
+		p.logger.Warnf("⚠️ No se pudo consultar el nomenclador: %v", err)
+		return nil
+	}
+
+	// Sin geocoding (eval replay) no hay cómo confirmar que no existe: se deja pasar
+	if p.supabaseService == nil {
+		return nil
+	}
+	geoErr := p.supabaseService.verificarDireccion(localidad)
+	if errors.Is(geoErr, errGeocodeNotFound) {
+		return fmt.Errorf("%v y tampoco se pudo geocodificar", err)
+	}
+	if geoErr != nil {
+		p.logger.Warnf("⚠️ No se pudo confirmar '%s' con geocoding: %v", localidad, geoErr)
+	}
+	return nil
+}
+
+// verificarDireccion confirma con geocoding (y su caché) que una dirección existe.
+// Devuelve un error que envuelve errGeocodeNotFound si ningún proveedor la encuentra;
+// las coordenadas quedan en caché para cuando se cree la ubicación.
+func (s *SupabaseService) verificarDireccion(direccion string) error {
+	unlock := s.lockUbicacion(direccion)
+	defer unlock()
+
+	if cached := s.buscarEnCacheGeocoding(direccion); cached != nil {
+		if !cached.Found {
+			return fmt.Errorf("%w (en caché): %s", errGeocodeNotFound, cached.LastError)
+		}
+		return nil
+	}
+
+	coords, err := s.obtenerCoordenadas(direccion)
+	if err != nil {
+		if errors.Is(err, errGeocodeNotFound) {
+			s.guardarNegativoGeocoding(direccion, err)
+		}
+		return err
+	}
+	s.guardarEnCacheGeocoding(direccion, coords, "")
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+func TestGazetteerResolve(t *testing.T) {
+	g, err := NewMemoryGazetteer()
+	if err != nil {
+		t.Fatalf("NewMemoryGazetteer: %v", err)
+	}
+
+	tests := []struct {
+		direccion string
+		wantKind  string // "" = sin lugar
+		wantName  string
+		notFound  bool
+	}{
+		{direccion: "Pergamino, Buenos Aires, Argentina", wantKind: GazetteerLocalidad, wantName: "Pergamino"},
+		{direccion: "PERGAMINO, bs as", wantKind: GazetteerLocalidad, wantName: "Pergamino"},
+		{direccion: "Ruta 8 km 220, Pergamino, Buenos Aires, Argentina", wantKind: GazetteerLocalidad, wantName: "Pergamino"},
+		{direccion: "Bariloche, Río Negro, Argentina", wantKind: GazetteerLocalidad, wantName: "San Carlos de Bariloche"},
+		{direccion: "Tucumán, Tucumán, Argentina", wantKind: GazetteerLocalidad, wantName: "San Miguel de Tucumán"},
+		{direccion: "Veinticinco de Mayo, Buenos Aires", wantKind: GazetteerLocalidad, wantName: "25 de Mayo"},
+		{direccion: "General López, Santa Fe, Argentina", wantKind: GazetteerDepartamento, wantName: "General López"},
+		{direccion: "Santa Fe, Argentina", wantKind: GazetteerProvincia, wantName: "Santa Fe"},
+		{direccion: "25 de Mayo, Argentina"}, // Existe en Buenos Aires y en La Pampa
+		{direccion: "Pergamino, Córdoba, Argentina", notFound: true},
+		{direccion: "Villa Inexistente, Santa Fe, Argentina", notFound: true},
+		{direccion: "Argentina", notFound: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.direccion, func(t *testing.T) {
+			place, err := g.Resolve(tt.direccion)
+			if tt.notFound {
+				if !errors.Is(err, errGazetteerNotFound) {
+					t.Fatalf("Resolve() = %+v, %v; se esperaba errGazetteerNotFound", place, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve() error: %v", err)
+			}
+			if tt.wantKind == "" {
+				if place != nil {
+					t.Errorf("Resolve() = %+v, se esperaba nil (nombre ambiguo)", place)
+				}
+				return
+			}
+			if place == nil || place.Kind != tt.wantKind || place.Nombre != tt.wantName {
+				t.Errorf("Resolve() = %+v, se esperaba %s %q", place, tt.wantKind, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestCheckGazetteer(t *testing.T) {
+	g, err := NewMemoryGazetteer()
+	if err != nil {
+		t.Fatalf("NewMemoryGazetteer: %v", err)
+	}
+
+	// Sin gazetteer_validation no se valida nada
+	p := &MessageProcessor{gazetteer: g, systemConfigManager: NewStaticSystemConfigManager(nil), logger: waLog.Noop}
+	if err := p.checkGazetteer("Villa Inexistente, Santa Fe, Argentina"); err != nil {
+		t.Errorf("validación desactivada por defecto: %v", err)
+	}
+
+	// Con la validación activa pero sin geocoding para confirmar, una localidad fuera del nomenclador pasa
+	p.systemConfigManager = NewStaticSystemConfigManager(map[string]string{"gazetteer_validation": "true"})
+	for _, localidad := range []string{"Pergamino, Buenos Aires, Argentina", "Villa Inexistente, Santa Fe, Argentina"} {
+		if err := p.checkGazetteer(localidad); err != nil {
+			t.Errorf("checkGazetteer(%q): %v", localidad, err)
+		}
+	}
+}
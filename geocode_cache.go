@@ -0,0 +1,263 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// errGeocodeNotFound indica que ningún proveedor encontró la dirección (no es un error de red)
+var errGeocodeNotFound = errors.New("no geocoding results")
+
+// Origen de una entrada de geocode_cache
+const (
+	GeocodeProviderSupabase  = "supabase" // La ubicación ya existía en Supabase
+	GeocodeProviderNominatim = "nominatim"
+	GeocodeProviderGoogle    = "google"
+	GeocodeProviderGazetteer = "gazetteer" // Nomenclador local (gazetteer.go)
+)
+
+// defaultGeocodeNegativeTTLHours es cuánto se recuerda que una dirección no se pudo geocodificar
+const defaultGeocodeNegativeTTLHours = 24
+
+// GeocodeCacheEntry es una dirección ya resuelta (o que no se pudo resolver)
+type GeocodeCacheEntry struct {
+	AddressKey       string     `json:"address_key"` // Dirección normalizada (clave)
+	Address          string     `json:"address"`     // Dirección tal como llegó la primera vez
+	Found            bool       `json:"found"`
+	Lat              *float64   `json:"lat"`
+	Lng              *float64   `json:"lng"`
+	Provider         string     `json:"provider"`
+	FormattedAddress string     `json:"formatted_address"`
+	UbicacionID      string     `json:"ubicacion_id"` // ID de la ubicación en Supabase
+	LastError        string     `json:"last_error"`
+	Hits             int        `json:"hits"`
+	ExpiresAt        *time.Time `json:"expires_at"` // Solo los negativos vencen
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// GeocodeCacheStats resume la caché para la UI
+type GeocodeCacheStats struct {
+	Found       int `json:"found"`
+	NotFound    int `json:"not_found"` // Negativos vigentes
+	Expired     int `json:"expired"`   // Negativos vencidos (se vuelven a geocodificar)
+	Hits        int `json:"hits"`
+	TTLHours    int `json:"ttl_hours"`
+	Unpublished int `json:"unpublished"` // Con coordenadas pero sin ubicación en Supabase
+}
+
+// GeocodeCache guarda en MySQL las direcciones ya geocodificadas, así no se vuelven a pedir
+type GeocodeCache struct {
+	db                  *sql.DB
+	systemConfigManager *SystemConfigManager
+}
+
+// NewGeocodeCache crea la caché de geocoding sobre la base de la configuración del sistema
+func NewGeocodeCache(systemConfigManager *SystemConfigManager) *GeocodeCache {
+	return &GeocodeCache{db: systemConfigManager.db, systemConfigManager: systemConfigManager}
+}
+
+// geocodeAddressKey normaliza una dirección: sin acentos, en minúsculas, sin espacios de más
+// ("Pergamino ,  Buenos Aires, Argentina" → "pergamino, buenos aires, argentina")
+func geocodeAddressKey(direccion string) string {
+	parts := strings.Split(strings.ToLower(removerAcentos(direccion)), ",")
+	for i, part := range parts {
+		parts[i] = strings.Join(strings.Fields(part), " ")
+	}
+	return strings.Join(parts, ", ")
+}
+
+const geocodeCacheSelectQuery = `
+	SELECT address_key, address, found, lat, lng, COALESCE(provider, ''), COALESCE(formatted_address, ''),
+	       COALESCE(ubicacion_id, ''), COALESCE(last_error, ''), hits, expires_at, updated_at
+	FROM geocode_cache
+`
+
+func scanGeocodeCacheEntry(row rowScanner) (*GeocodeCacheEntry, error) {
+	var e GeocodeCacheEntry
+	var lat, lng sql.NullFloat64
+	var expiresAt sql.NullTime
+	err := row.Scan(&e.AddressKey, &e.Address, &e.Found, &lat, &lng, &e.Provider, &e.FormattedAddress,
+		&e.UbicacionID, &e.LastError, &e.Hits, &expiresAt, &e.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if lat.Valid && lng.Valid {
+		e.Lat, e.Lng = &lat.Float64, &lng.Float64
+	}
+	if expiresAt.Valid {
+		e.ExpiresAt = &expiresAt.Time
+	}
+	return &e, nil
+}
+
+// Get busca una dirección en la caché. Devuelve nil si no está o si es un negativo vencido.
+func (c *GeocodeCache) Get(direccion string) (*GeocodeCacheEntry, error) {
+	key := geocodeAddressKey(direccion)
+	entry, err := scanGeocodeCacheEntry(c.db.QueryRow(geocodeCacheSelectQuery+`
+		WHERE address_key = ? AND (expires_at IS NULL OR expires_at > NOW())
+	`, key))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read geocode cache: %v", err)
+	}
+
+	if _, err := c.db.Exec(`UPDATE geocode_cache SET hits = hits + 1 WHERE address_key = ?`, key); err != nil {
+		fmt.Printf("⚠️ Error actualizando hits de geocode_cache: %v\n", err)
+	}
+	return entry, nil
+}
+
+// SaveFound guarda una dirección resuelta. coords puede ser nil (ubicación encontrada en Supabase)
+// y ubicacionID vacío (coordenadas obtenidas pero la ubicación todavía no se creó).
+// El proveedor y las coordenadas de antes se conservan si ahora no vienen
+// (el orden de las asignaciones importa: MySQL las aplica de izquierda a derecha).
+func (c *GeocodeCache) SaveFound(direccion string, coords *Coordenadas, ubicacionID string) error {
+	var lat, lng sql.NullFloat64
+	provider, formatted := GeocodeProviderSupabase, ""
+	if coords != nil {
+		lat = sql.NullFloat64{Float64: coords.Lat, Valid: true}
+		lng = sql.NullFloat64{Float64: coords.Lng, Valid: true}
+		provider, formatted = coords.Provider, coords.FormattedAddress
+	}
+
+	_, err := c.db.Exec(`
+		INSERT INTO geocode_cache
+			(address_key, address, found, lat, lng, provider, formatted_address, ubicacion_id, last_error, expires_at)
+		VALUES (?, ?, TRUE, ?, ?, ?, ?, NULLIF(?, ''), NULL, NULL)
+		ON DUPLICATE KEY UPDATE
+			provider = IF(VALUES(lat) IS NULL AND lat IS NOT NULL, provider, VALUES(provider)),
+			formatted_address = IF(VALUES(formatted_address) = '', formatted_address, VALUES(formatted_address)),
+			lat = COALESCE(VALUES(lat), lat),
+			lng = COALESCE(VALUES(lng), lng),
+			found = TRUE,
+			ubicacion_id = COALESCE(VALUES(ubicacion_id), ubicacion_id),
+			last_error = NULL,
+			expires_at = NULL
+	`, geocodeAddressKey(direccion), direccion, lat, lng, provider, formatted, ubicacionID)
+	if err != nil {
+		return fmt.Errorf("failed to save geocode cache: %v", err)
+	}
+	return nil
+}
+
+// SaveNotFound recuerda que una dirección no se pudo geocodificar durante el TTL de negativos
+func (c *GeocodeCache) SaveNotFound(direccion string, lookupErr error) error {
+	hours := int(c.systemConfigManager.GetGeocodeNegativeTTL().Hours())
+	_, err := c.db.Exec(`
+		INSERT INTO geocode_cache (address_key, address, found, last_error, expires_at)
+		VALUES (?, ?, FALSE, ?, DATE_ADD(NOW(), INTERVAL ? HOUR))
+		ON DUPLICATE KEY UPDATE
+			found = FALSE, lat = NULL, lng = NULL, provider = NULL, formatted_address = NULL,
+			ubicacion_id = NULL, last_error = VALUES(last_error), expires_at = VALUES(expires_at)
+	`, geocodeAddressKey(direccion), direccion, truncateGeocodeError(lookupErr), hours)
+	if err != nil {
+		return fmt.Errorf("failed to save geocode cache: %v", err)
+	}
+	return nil
+}
+
+// truncateGeocodeError recorta el error a lo que entra en geocode_cache.last_error
+func truncateGeocodeError(err error) string {
+	msg := []rune(err.Error())
+	if len(msg) > 500 {
+		msg = msg[:500]
+	}
+	return string(msg)
+}
+
+// List devuelve las entradas de la caché (las más usadas primero), filtrando por dirección
+func (c *GeocodeCache) List(search string, limit int) ([]GeocodeCacheEntry, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	rows, err := c.db.Query(geocodeCacheSelectQuery+`
+		WHERE address_key LIKE ?
+		ORDER BY hits DESC, updated_at DESC
+		LIMIT ?
+	`, "%"+geocodeAddressKey(search)+"%", limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list geocode cache: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []GeocodeCacheEntry{}
+	for rows.Next() {
+		entry, err := scanGeocodeCacheEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+	return entries, rows.Err()
+}
+
+// Stats cuenta las entradas de la caché
+func (c *GeocodeCache) Stats() (*GeocodeCacheStats, error) {
+	stats := &GeocodeCacheStats{TTLHours: int(c.systemConfigManager.GetGeocodeNegativeTTL().Hours())}
+	err := c.db.QueryRow(`
+		SELECT
+			COALESCE(SUM(found), 0),
+			COALESCE(SUM(NOT found AND expires_at > NOW()), 0),
+			COALESCE(SUM(NOT found AND expires_at <= NOW()), 0),
+			COALESCE(SUM(hits), 0),
+			COALESCE(SUM(found AND ubicacion_id IS NULL), 0)
+		FROM geocode_cache
+	`).Scan(&stats.Found, &stats.NotFound, &stats.Expired, &stats.Hits, &stats.Unpublished)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get geocode cache stats: %v", err)
+	}
+	return stats, nil
+}
+
+// Delete borra una dirección de la caché (la próxima vez se vuelve a buscar)
+func (c *GeocodeCache) Delete(addressKey string) error {
+	_, err := c.db.Exec(`DELETE FROM geocode_cache WHERE address_key = ?`, addressKey)
+	return err
+}
+
+// ClearNotFound borra los negativos para que se vuelvan a intentar ya; devuelve cuántos borró
+func (c *GeocodeCache) ClearNotFound() (int64, error) {
+	res, err := c.db.Exec(`DELETE FROM geocode_cache WHERE found = FALSE`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// buscarEnCacheGeocoding busca la dirección en la caché local (nil si no está o no hay caché)
+func (s *SupabaseService) buscarEnCacheGeocoding(direccion string) *GeocodeCacheEntry {
+	if s.geocodeCache == nil {
+		return nil
+	}
+	entry, err := s.geocodeCache.Get(direccion)
+	if err != nil {
+		fmt.Printf("⚠️ %v\n", err)
+		return nil
+	}
+	return entry
+}
+
+// guardarEnCacheGeocoding guarda una dirección resuelta; un error de la caché no frena la publicación
+func (s *SupabaseService) guardarEnCacheGeocoding(direccion string, coords *Coordenadas, ubicacionID string) {
+	if s.geocodeCache == nil {
+		return
+	}
+	if err := s.geocodeCache.SaveFound(direccion, coords, ubicacionID); err != nil {
+		fmt.Printf("⚠️ %v\n", err)
+	}
+}
+
+// guardarNegativoGeocoding recuerda que la dirección no tiene resultados
+func (s *SupabaseService) guardarNegativoGeocoding(direccion string, lookupErr error) {
+	if s.geocodeCache == nil {
+		return
+	}
+	if err := s.geocodeCache.SaveNotFound(direccion, lookupErr); err != nil {
+		fmt.Printf("⚠️ %v\n", err)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"embed"
 	"log"
+	"os"
 
 	"github.com/wailsapp/wails/v2"
 	"github.com/wailsapp/wails/v2/pkg/options"
@@ -13,6 +14,15 @@ import (
 var assets embed.FS
 
 func main() {
+	// Subcomando de evaluación sobre el golden dataset (no abre la ventana): loader-meow eval -dataset ...
+	if len(os.Args) > 1 && os.Args[1] == "eval" {
+		os.Exit(runEvalCommand(os.Args[2:]))
+	}
+	// Conciliación de cargas publicadas contra Supabase: loader-meow reconcile -days 30
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		os.Exit(runReconcileCommand(os.Args[2:]))
+	}
+
 	// Create an instance of the app structure
 	app := NewApp()
 
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// messageClaimLease es cuánto dura el claim de un mensaje si la instancia que lo tomó deja de renovarlo
+const messageClaimLease = 5 * time.Minute
+
+// messageClaimRenewInterval es cada cuánto se renuevan los claims de los mensajes en curso
+const messageClaimRenewInterval = time.Minute
+
+// MessageKey identifica un mensaje (PRIMARY KEY id + chat_jid)
+type MessageKey struct {
+	ID      string `json:"id"`
+	ChatJID string `json:"chat_jid"`
+}
+
+// newInstanceID identifica a esta instancia en messages.claimed_by (host:pid)
+func newInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "loader-meow"
+	}
+	return fmt.Sprintf("%s:%d", hostname, os.Getpid())
+}
+
+// serverVersionPattern extrae major.minor.patch de SELECT VERSION() (ej: "10.11.6-MariaDB", "8.0.36")
+var serverVersionPattern = regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)`)
+
+// detectSkipLocked verifica si el servidor soporta FOR UPDATE SKIP LOCKED (MySQL 8.0.1+ / MariaDB 10.6+)
+func detectSkipLocked(db *sql.DB) bool {
+	var version string
+	if err := db.QueryRow("SELECT VERSION()").Scan(&version); err != nil {
+		return false
+	}
+
+	match := serverVersionPattern.FindStringSubmatch(version)
+	if match == nil {
+		return false
+	}
+	major, _ := strconv.Atoi(match[1])
+	minor, _ := strconv.Atoi(match[2])
+	patch, _ := strconv.Atoi(match[3])
+
+	if strings.Contains(strings.ToLower(version), "mariadb") {
+		return major > 10 || (major == 10 && minor >= 6)
+	}
+	return major > 8 || (major == 8 && (minor > 0 || patch >= 1))
+}
+
+// ClaimProcessableMessages toma hasta limit mensajes procesables para esta instancia (claimed_by / claimed_until).
+// Los mensajes con un claim vigente de otra instancia se saltean; los claims vencidos se vuelven a tomar.
+// Con SKIP LOCKED dos instancias no se bloquean entre sí; sin soporte se usa FOR UPDATE y el UPDATE
+// condicional garantiza igual que cada mensaje lo tome una sola instancia.
+func (store *MessageStore) ClaimProcessableMessages(limit int) ([]ProcessableMessage, error) {
+	lockClause := "FOR UPDATE"
+	if store.skipLocked {
+		lockClause = "FOR UPDATE SKIP LOCKED"
+	}
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin claim transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Solo se bloquean filas de messages (las subconsultas no toman locks)
+	rows, err := tx.Query(`
+		SELECT m.id, m.chat_jid
+		FROM messages m
+		WHERE m.processed = 0
+		  AND m.content IS NOT NULL
+		  AND m.content != ''
+		  AND (m.processing_attempts < ? OR m.processing_attempts IS NULL)
+		  AND (m.next_attempt_at IS NULL OR m.next_attempt_at <= NOW())
+		  AND (m.claimed_until IS NULL OR m.claimed_until < NOW())
+		  AND EXISTS (
+		       SELECT 1 FROM phone_associations pa
+		       WHERE pa.sender_phone = m.sender_phone
+		         AND pa.real_phone IS NOT NULL
+		         AND pa.real_phone != ''
+		  )
+		  -- Mismo filtro de mensajes de texto cortos que GetProcessableMessages
+		  AND (
+		       (m.media_type IS NOT NULL AND m.media_type != '')
+		       OR
+		       (m.media_type IS NULL OR m.media_type = '') AND LENGTH(m.content) >= 20
+		  )
+		ORDER BY m.timestamp ASC
+		LIMIT ?
+		`+lockClause, maxProcessingAttempts, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select messages to claim: %v", err)
+	}
+
+	var candidates []MessageKey
+	for rows.Next() {
+		var key MessageKey
+		if err := rows.Scan(&key.ID, &key.ChatJID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		candidates = append(candidates, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var claimed []MessageKey
+	for _, key := range candidates {
+		// El UPDATE vuelve a verificar el claim: si otra instancia lo tomó mientras tanto, no afecta filas
+		res, err := tx.Exec(`
+			UPDATE messages
+			SET claimed_by = ?,
+			    claimed_until = NOW() + INTERVAL ? SECOND
+			WHERE id = ? AND chat_jid = ?
+			  AND processed = 0
+			  AND (claimed_until IS NULL OR claimed_until < NOW())
+		`, store.instanceID, int(messageClaimLease.Seconds()), key.ID, key.ChatJID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to claim message %s: %v", key.ID, err)
+		}
+		if affected, _ := res.RowsAffected(); affected == 1 {
+			claimed = append(claimed, key)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit claim: %v", err)
+	}
+
+	if len(claimed) == 0 {
+		return []ProcessableMessage{}, nil
+	}
+
+	return store.getClaimedMessages(claimed)
+}
+
+// getClaimedMessages lee los datos completos de los mensajes que esta instancia acaba de tomar
+func (store *MessageStore) getClaimedMessages(keys []MessageKey) ([]ProcessableMessage, error) {
+	placeholders := make([]string, len(keys))
+	args := []interface{}{store.instanceID}
+	for i, key := range keys {
+		placeholders[i] = "(?, ?)"
+		args = append(args, key.ID, key.ChatJID)
+	}
+
+	rows, err := store.db.Query(`
+		SELECT m.id, m.chat_jid, m.sender_phone, m.sender_name, m.content,
+		       m.timestamp, m.is_from_me, m.media_type, m.filename, m.processed,
+		       pa.real_phone, COALESCE(c.name, '') as chat_name, COALESCE(m.ai_config_id, 0)
+		FROM messages m
+		INNER JOIN phone_associations pa ON m.sender_phone = pa.sender_phone
+		LEFT JOIN chats c ON c.jid = m.chat_jid
+		WHERE m.claimed_by = ?
+		  AND (m.id, m.chat_jid) IN (`+strings.Join(placeholders, ", ")+`)
+		ORDER BY m.timestamp ASC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []ProcessableMessage
+	for rows.Next() {
+		var msg ProcessableMessage
+		err := rows.Scan(&msg.ID, &msg.ChatJID, &msg.SenderPhone, &msg.SenderName, &msg.Content,
+			&msg.Timestamp, &msg.IsFromMe, &msg.MediaType, &msg.Filename, &msg.Processed, &msg.RealPhone, &msg.ChatName, &msg.AIConfigID)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// ClaimMessage toma un mensaje puntual para esta instancia (reprocesamiento manual), aunque ya esté procesado.
+// Falla si tiene un claim vigente (de otra instancia o de un lote en curso de esta).
+func (store *MessageStore) ClaimMessage(messageID, chatJID string) error {
+	res, err := store.db.Exec(`
+		UPDATE messages
+		SET claimed_by = ?,
+		    claimed_until = NOW() + INTERVAL ? SECOND
+		WHERE id = ? AND chat_jid = ?
+		  AND (claimed_until IS NULL OR claimed_until < NOW())
+	`, store.instanceID, int(messageClaimLease.Seconds()), messageID, chatJID)
+	if err != nil {
+		return fmt.Errorf("failed to claim message: %v", err)
+	}
+
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		var claimedBy sql.NullString
+		store.db.QueryRow(`SELECT claimed_by FROM messages WHERE id = ? AND chat_jid = ?`, messageID, chatJID).Scan(&claimedBy)
+		if claimedBy.Valid {
+			return fmt.Errorf("el mensaje ya se está procesando (%s)", claimedBy.String)
+		}
+		return fmt.Errorf("message not found")
+	}
+	return nil
+}
+
+// RenewClaims extiende el claim de los mensajes que esta instancia sigue procesando
+func (store *MessageStore) RenewClaims() error {
+	_, err := store.db.Exec(`
+		UPDATE messages
+		SET claimed_until = NOW() + INTERVAL ? SECOND
+		WHERE claimed_by = ? AND claimed_until >= NOW()
+	`, int(messageClaimLease.Seconds()), store.instanceID)
+	return err
+}
+
+// ReleaseClaim libera el claim de un mensaje de esta instancia (al terminar o si no llegó a procesarse)
+func (store *MessageStore) ReleaseClaim(messageID, chatJID string) error {
+	_, err := store.db.Exec(`
+		UPDATE messages
+		SET claimed_by = NULL,
+		    claimed_until = NULL
+		WHERE id = ? AND chat_jid = ? AND claimed_by = ?
+	`, messageID, chatJID, store.instanceID)
+	return err
+}
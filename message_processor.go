@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	waLog "go.mau.fi/whatsmeow/util/log"
@@ -12,13 +15,17 @@ import (
 
 // MessageProcessor orquesta el procesamiento de mensajes con IA
 type MessageProcessor struct {
-	messageStore      *MessageStore
-	aiService         *AIService // Mantener por compatibilidad
-	aiProviderService *AIProviderService // Nuevo servicio multi-proveedor
-	aiConfigManager   *AIConfigManager
-	supabaseService   *SupabaseService
-	systemPrompt      string
-	logger            waLog.Logger
+	messageStore        *MessageStore
+	aiService           *AIService // Mantener por compatibilidad
+	aiProviderService   *AIProviderService // Nuevo servicio multi-proveedor
+	aiConfigManager     *AIConfigManager
+	supabaseService     *SupabaseService
+	promptTemplates     *PromptTemplateManager // Versiones del prompt (prompt_templates)
+	systemConfigManager *SystemConfigManager   // Modo revisión y otras configuraciones generales
+	gazetteer           *Gazetteer             // Nomenclador para validar localidades (en memoria en eval replay)
+	outboxWake          chan struct{}          // Despierta al dispatcher del outbox apenas hay cargas nuevas
+	outboxMu            sync.Mutex             // Una sola pasada del dispatcher a la vez por instancia
+	logger              waLog.Logger
 }
 
 // ProcessingResult representa el resultado del procesamiento de un mensaje
@@ -35,6 +42,14 @@ type ProcessingResult struct {
 	SupabaseIDs        []string  `json:"supabase_ids"`
 	ProcessedAt        time.Time `json:"processed_at"`
 	ProcessingAttempts int       `json:"processing_attempts"`
+	FailoverChain      []FailoverStep `json:"failover_chain"` // Configs que dieron rate limit antes de la respuesta final
+	ValidationErrors   []CargaFieldError `json:"validation_errors"` // Errores por campo del esquema de CargaData
+	RepairAttempts     int            `json:"repair_attempts"`   // Veces que se pidió al modelo corregir su respuesta
+	PromptTemplateID   int            `json:"prompt_template_id"` // prompt_templates.id usado (0 = prompt del archivo)
+	PromptVersion      string         `json:"prompt_version"`     // Etiqueta de la versión (ej: "v3", "gemini v2")
+	ErrorClass         string         `json:"error_class"`        // transient / permanent (vacío si no hubo error)
+	NextAttemptAt      *time.Time     `json:"next_attempt_at"`    // Cuándo se reintenta (nil = no se reintenta)
+	CatalogMatches     []CatalogMatch `json:"catalog_matches"`    // Valores de catálogo corregidos (acentos, sinónimos, aproximados)
 }
 
 // NewMessageProcessor crea una nueva instancia del procesador de mensajes
@@ -52,29 +67,62 @@ func NewMessageProcessor(messageStore *MessageStore, logger waLog.Logger, keysMa
 		logger.Warnf("AI service (legacy) not available: %v", err)
 	}
 	
+	// Templates del prompt versionados: si la tabla está vacía se importa el archivo como versión 1
+	promptTemplates := NewPromptTemplateManager(messageStore.db, systemPrompt)
+	if err := promptTemplates.EnsureDefaultTemplate(); err != nil {
+		logger.Warnf("Failed to import default prompt template: %v", err)
+	}
+	
 	// Inicializar nuevo servicio multi-proveedor
-	aiProviderService := NewAIProviderService(aiConfigManager)
+	aiProviderService := NewAIProviderService(aiConfigManager, promptTemplates)
 	
 	// Inicializar servicio de Supabase con system config manager
 	supabaseService := NewSupabaseService(systemConfigManager)
 	
-	return &MessageProcessor{
-		messageStore:      messageStore,
-		aiService:         aiService,
-		aiProviderService: aiProviderService,
-		aiConfigManager:   aiConfigManager,
-		supabaseService:   supabaseService,
-		systemPrompt:      systemPrompt,
-		logger:            logger,
-	}, nil
+	p := &MessageProcessor{
+		messageStore:        messageStore,
+		aiService:           aiService,
+		aiProviderService:   aiProviderService,
+		aiConfigManager:     aiConfigManager,
+		supabaseService:     supabaseService,
+		promptTemplates:     promptTemplates,
+		systemConfigManager: systemConfigManager,
+		gazetteer:           supabaseService.gazetteer,
+		outboxWake:          make(chan struct{}, 1),
+		logger:              logger,
+	}
+	
+	// Catálogos: la caché de MySQL ya sirve; si venció se sincroniza con Supabase en background
+	p.loadCachedCatalogs()
+	go p.refreshCatalogsIfStale()
+	
+	// Nomenclador: solo se recarga si cambió el dataset
+	if p.gazetteer != nil {
+		if status, err := p.gazetteer.Load(false); err != nil {
+			logger.Warnf("Error cargando el nomenclador de localidades: %v", err)
+		} else {
+			logger.Infof("📖 Nomenclador: %d provincias, %d departamentos, %d localidades", status.Provincias, status.Departamentos, status.Localidades)
+		}
+	}
+	
+	return p, nil
 }
 
-// ProcessPendingMessages procesa mensajes pendientes
-func (p *MessageProcessor) ProcessPendingMessages(limit int) ([]ProcessingResult, error) {
-	// Obtener mensajes procesables
-	messages, err := p.messageStore.GetProcessableMessages(limit)
+// maxWorkerPoolSize es el tope de mensajes procesados en paralelo, sin importar lo configurado en la key
+const maxWorkerPoolSize = 16
+
+// ProcessPendingMessages procesa mensajes pendientes con un pool de workers.
+// El tamaño del pool sale de max_concurrency de la config activa (el limitador RPM/TPM sigue aplicando por llamada).
+// Si se cancela ctx, los workers no toman mensajes nuevos; los que ya están en curso terminan y se guardan.
+// Los resultados se devuelven en el mismo orden que los mensajes.
+func (p *MessageProcessor) ProcessPendingMessages(ctx context.Context, limit int) ([]ProcessingResult, error) {
+	// Antes de tomar los mensajes, así una sincronización lenta no retiene los claims
+	p.refreshCatalogsIfStale()
+	
+	// Tomar mensajes procesables para esta instancia (otras instancias sobre la misma BD no los ven)
+	messages, err := p.messageStore.ClaimProcessableMessages(limit)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get processable messages: %v", err)
+		return nil, fmt.Errorf("failed to claim processable messages: %v", err)
 	}
 	
 	if len(messages) == 0 {
@@ -82,44 +130,155 @@ func (p *MessageProcessor) ProcessPendingMessages(limit int) ([]ProcessingResult
 		return []ProcessingResult{}, nil
 	}
 	
-	p.logger.Infof("Procesando %d mensajes", len(messages))
+	workers := p.workerPoolSize(len(messages))
+	p.logger.Infof("Procesando %d mensajes con %d worker(s)", len(messages), workers)
+	
+	results := make([]ProcessingResult, len(messages))
+	done := make([]bool, len(messages))
+	jobs := make(chan int)
+	
+	// Renovar los claims mientras dure el lote, para que otra instancia no los tome por vencidos
+	stopRenew := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(messageClaimRenewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopRenew:
+				return
+			case <-ticker.C:
+				if err := p.messageStore.RenewClaims(); err != nil {
+					p.logger.Warnf("Error renovando claims: %v", err)
+				}
+			}
+		}
+	}()
+	
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				p.logger.Infof("Procesando mensaje %d/%d: %s", i+1, len(messages), messages[i].ID)
+				results[i] = p.processAndRecord(messages[i])
+				done[i] = true
+			}
+		}()
+	}
 	
-	var results []ProcessingResult
+	// Repartir los mensajes hasta terminar o hasta que se cancele el contexto
+	canceled := false
+dispatch:
+	for i := range messages {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			canceled = true
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	close(stopRenew)
 	
+	// Juntar los resultados en orden; los mensajes que no llegaron a procesarse se liberan para otra corrida
+	ordered := make([]ProcessingResult, 0, len(messages))
 	for i, msg := range messages {
-		p.logger.Infof("Procesando mensaje %d/%d: %s", i+1, len(messages), msg.ID)
-		
-		// Procesar mensaje individual
-		result := p.processMessage(msg)
-		results = append(results, result)
-		
-		// Guardar resultado en la base de datos
-		if err := p.saveProcessingResult(result); err != nil {
-			p.logger.Errorf("Error guardando resultado: %v", err)
+		if done[i] {
+			ordered = append(ordered, results[i])
+			continue
 		}
-		
-		// Manejar el resultado según el estado
-		switch result.Status {
-		case "success":
-			// Marcar como procesado exitosamente
-			if err := p.messageStore.MarkMessageAsProcessed(msg.ID, msg.ChatJID); err != nil {
-				p.logger.Errorf("Error marcando mensaje como procesado: %v", err)
-			}
-		case "error":
-			// Incrementar contador de intentos y registrar error
-			if err := p.messageStore.IncrementProcessingAttempt(msg.ID, msg.ChatJID, result.ErrorMessage); err != nil {
-				p.logger.Errorf("Error incrementando intentos: %v", err)
-			} else {
-				p.logger.Warnf("Intento fallido para mensaje %s. Error: %s", msg.ID, result.ErrorMessage)
-			}
+		if err := p.messageStore.ReleaseClaim(msg.ID, msg.ChatJID); err != nil {
+			p.logger.Warnf("Error liberando claim de %s: %v", msg.ID, err)
 		}
-		
-		// Pequeña pausa entre mensajes
-		time.Sleep(500 * time.Millisecond)
 	}
 	
-	p.logger.Infof("Procesamiento completado: %d resultados", len(results))
-	return results, nil
+	if canceled {
+		p.logger.Warnf("Procesamiento cancelado: %d de %d mensajes procesados", len(ordered), len(messages))
+		return ordered, ctx.Err()
+	}
+	
+	p.logger.Infof("Procesamiento completado: %d resultados", len(ordered))
+	return ordered, nil
+}
+
+// workerPoolSize calcula cuántos workers usar según la config activa
+func (p *MessageProcessor) workerPoolSize(pending int) int {
+	activeConfig, err := p.aiConfigManager.GetActiveConfig()
+	if err != nil || activeConfig == nil {
+		// El servicio legacy rota su API key sin sincronización: procesar de a uno
+		return 1
+	}
+	
+	workers := activeConfig.MaxConcurrency
+	// No tiene sentido tener más workers que requests por minuto permitidos
+	if activeConfig.RPMLimit > 0 && workers > activeConfig.RPMLimit {
+		workers = activeConfig.RPMLimit
+	}
+	if workers > maxWorkerPoolSize {
+		workers = maxWorkerPoolSize
+	}
+	if workers > pending {
+		workers = pending
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	return workers
+}
+
+// processAndRecord procesa un mensaje, guarda el resultado (processed / intento fallido) y libera su claim
+func (p *MessageProcessor) processAndRecord(msg ProcessableMessage) ProcessingResult {
+	defer func() {
+		if err := p.messageStore.ReleaseClaim(msg.ID, msg.ChatJID); err != nil {
+			p.logger.Warnf("Error liberando claim de %s: %v", msg.ID, err)
+		}
+	}()
+	
+	result := p.processMessage(msg)
+	
+	// Guardar resultado en la base de datos
+	if err := p.saveProcessingResult(result); err != nil {
+		p.logger.Errorf("Error guardando resultado: %v", err)
+	}
+	
+	// Manejar el resultado según el estado
+	switch result.Status {
+	case "success", PendingCargaNeedsReview, ProcessingStatusExtracted:
+		// Marcar como procesado exitosamente (las cargas en revisión o en el outbox ya no dependen del mensaje)
+		if err := p.messageStore.MarkMessageAsProcessed(msg.ID, msg.ChatJID); err != nil {
+			p.logger.Errorf("Error marcando mensaje como procesado: %v", err)
+		}
+		if result.Status == ProcessingStatusExtracted {
+			p.wakeOutbox()
+		}
+	case "error":
+		p.recordFailure(&result, msg.ID, msg.ChatJID)
+	}
+	
+	return result
+}
+
+// recordFailure registra el intento fallido (backoff o fallido según la clase de error) y anota en el resultado cuándo se reintenta
+func (p *MessageProcessor) recordFailure(result *ProcessingResult, messageID, chatJID string) {
+	if result.ErrorClass == "" {
+		result.ErrorClass = ErrorClassTransient
+	}
+	
+	nextAttemptAt, err := p.messageStore.RecordProcessingFailure(messageID, chatJID, result.ErrorMessage, result.ErrorClass)
+	if err != nil {
+		p.logger.Errorf("Error registrando intento fallido: %v", err)
+		return
+	}
+	
+	result.NextAttemptAt = nextAttemptAt
+	if nextAttemptAt != nil {
+		p.logger.Warnf("Intento fallido para mensaje %s (%s), se reintenta a las %s. Error: %s",
+			messageID, result.ErrorClass, nextAttemptAt.Format("15:04:05"), result.ErrorMessage)
+	} else {
+		p.logger.Warnf("Mensaje %s marcado como fallido (%s). Error: %s", messageID, result.ErrorClass, result.ErrorMessage)
+	}
 }
 
 // processMessage procesa un mensaje individual
@@ -134,13 +293,22 @@ func (p *MessageProcessor) processMessage(msg ProcessableMessage) ProcessingResu
 		ProcessedAt: time.Now(),
 	}
 	
+	// Un mensaje vacío nunca va a producir cargas: no tiene sentido reintentarlo
+	if strings.TrimSpace(msg.Content) == "" {
+		result.Status = "error"
+		result.ErrorMessage = "Empty message content"
+		result.ErrorClass = ErrorClassPermanent
+		return result
+	}
+	
 	// Verificar si hay configuración activa de IA
 	activeConfig, err := p.aiConfigManager.GetActiveConfig()
-	if err != nil {
+	if err != nil && msg.AIConfigID == 0 {
 		// Intentar usar el servicio legacy si está disponible
 		if p.aiService == nil {
 			result.Status = "error"
 			result.ErrorMessage = "No active AI configuration found. Please configure AI settings."
+			result.ErrorClass = ErrorClassTransient
 			p.logger.Errorf("No AI service available")
 			return result
 		}
@@ -148,13 +316,28 @@ func (p *MessageProcessor) processMessage(msg ProcessableMessage) ProcessingResu
 	}
 	
 	var aiResponse []byte
+	var callConfig *AIConfigDB     // Config que produjo la respuesta (para la auto-reparación)
+	var callPrompt *PromptTemplate // Versión del prompt que produjo la respuesta
+	promptMsg := PromptMessage{Content: msg.Content, RealPhone: msg.RealPhone, GroupName: msg.ChatName}
 	
 	// 1. Procesar con IA usando el nuevo sistema multi-proveedor
 	p.logger.Infof("Llamando a IA para mensaje %s", msg.ID)
 	
-	if activeConfig != nil {
-		// Usar nuevo sistema multi-proveedor
-		aiResponse, err = p.aiProviderService.ProcessMessage(p.systemPrompt, msg.Content, msg.RealPhone)
+	if msg.AIConfigID != 0 || activeConfig != nil {
+		// Usar nuevo sistema multi-proveedor (con failover automático ante rate limits)
+		var callResult *AICallResult
+		if msg.AIConfigID != 0 {
+			// Reencolado desde la dead-letter queue con una config elegida: sin failover
+			p.logger.Infof("Mensaje %s fijado a la configuración %d", msg.ID, msg.AIConfigID)
+			callResult, err = p.aiProviderService.ProcessMessageWithConfig(msg.AIConfigID, promptMsg)
+		} else {
+			callResult, err = p.aiProviderService.ProcessMessage(promptMsg)
+		}
+		aiResponse = callResult.Response
+		callConfig = callResult.Config
+		callPrompt = callResult.Prompt
+		result.FailoverChain = callResult.FailoverChain
+		setPromptVersion(&result, callPrompt)
 	} else {
 		// Usar sistema legacy
 		aiResponse, err = p.aiService.ProcessMessage(msg.Content, msg.RealPhone)
@@ -163,6 +346,7 @@ func (p *MessageProcessor) processMessage(msg ProcessableMessage) ProcessingResu
 	if err != nil {
 		result.Status = "error"
 		result.ErrorMessage = fmt.Sprintf("AI processing failed: %v", err)
+		result.ErrorClass = classifyAIError(err)
 		p.logger.Errorf("Error en procesamiento IA: %v", err)
 		p.logger.Errorf("🔴 PROCESAMIENTO FINALIZADO CON ERROR para mensaje %s", msg.ID)
 		return result
@@ -171,29 +355,37 @@ func (p *MessageProcessor) processMessage(msg ProcessableMessage) ProcessingResu
 	result.AIResponse = string(aiResponse)
 	p.logger.Infof("IA respondió para mensaje %s", msg.ID)
 	
-	// 2. Validar respuesta de IA
-	if err := p.aiProviderService.ValidateResponse(aiResponse); err != nil {
-		result.Status = "error"
-		result.ErrorMessage = fmt.Sprintf("Invalid AI response: %v", err)
-		p.logger.Errorf("Respuesta de IA inválida: %v", err)
-		return result
+	// 2. Validar, normalizar y verificar el esquema (con auto-reparación por el mismo modelo si falla)
+	normalizedResponse, err := p.validateWithRepair(&result, callConfig, callPrompt, promptMsg, aiResponse)
+	
+	// Las reparaciones se cuentan aparte de los intentos por errores de transporte
+	if result.RepairAttempts > 0 {
+		if repairErr := p.messageStore.AddRepairAttempts(msg.ID, msg.ChatJID, result.RepairAttempts); repairErr != nil {
+			p.logger.Warnf("Error registrando intentos de reparación: %v", repairErr)
+		}
 	}
 	
-	// 2.5. Normalizar respuesta (convertir objeto único a array si es necesario)
-	normalizedResponse, err := p.aiProviderService.NormalizeResponse(aiResponse)
 	if err != nil {
 		result.Status = "error"
-		result.ErrorMessage = fmt.Sprintf("Failed to normalize AI response: %v", err)
-		p.logger.Errorf("Error normalizando respuesta: %v", err)
+		result.ErrorMessage = err.Error()
+		result.ErrorClass = ErrorClassPermanent // El modelo respondió con un esquema inválido y ya tuvo sus intentos de reparación
+		
+		// Si el pedido de corrección no llegó a responder (presupuesto, timeout, red), se clasifica como error de la llamada
+		var callErr *repairCallError
+		if errors.As(err, &callErr) {
+			result.ErrorClass = classifyAIError(callErr.cause)
+		}
+		p.logger.Errorf("Respuesta de IA inválida para mensaje %s: %v", msg.ID, err)
 		return result
 	}
 	
-	// Actualizar AIResponse con la versión normalizada
-	result.AIResponse = string(normalizedResponse)
-	
 	// 2.6. Verificar si el array está vacío (mensaje sin información suficiente)
 	var cargasTemp []map[string]interface{}
-	json.Unmarshal(normalizedResponse, &cargasTemp)
+	if err := json.Unmarshal(normalizedResponse, &cargasTemp); err != nil {
+		rejectBySchema(&result, normalizedResponse, err)
+		p.logger.Errorf("Respuesta de IA inválida para mensaje %s: %v", msg.ID, err)
+		return result
+	}
 	
 	if len(cargasTemp) == 0 {
 		result.Status = "success"
@@ -214,63 +406,65 @@ func (p *MessageProcessor) processMessage(msg ProcessableMessage) ProcessingResu
 	if err := p.validateLocations(normalizedResponse); err != nil {
 		result.Status = "error"
 		result.ErrorMessage = fmt.Sprintf("Invalid locations: %v", err)
+		result.ErrorClass = ErrorClassPermanent
 		result.AIResponse = string(normalizedResponse)
 		p.logger.Warnf("Mensaje rechazado por ubicaciones inválidas: %v", err)
 		return result
 	}
 	
-	// 3. Subir a Supabase
-	p.logger.Infof("Subiendo a Supabase para mensaje %s", msg.ID)
-	p.logger.Infof("JSON de IA para Supabase: %s", string(normalizedResponse))
-	supabaseIDs, err := p.supabaseService.CrearCargasDesdeJSON(normalizedResponse)
+	// 2.8. Modo revisión: las cargas quedan en pending_cargas hasta que un operador las apruebe
+	reason, err := p.reviewReason(msg, result.CatalogMatches)
+	if err != nil {
+		p.logger.Warnf("Error evaluando modo revisión, se revisa por las dudas: %v", err)
+		reason = "no se pudo evaluar la confianza del remitente"
+	}
+	if reason != "" {
+		var cargas []CargaData
+		json.Unmarshal(normalizedResponse, &cargas)
+		result.AIResponse = string(normalizedResponse)
+		
+		if err := p.messageStore.AddPendingCargas(msg, cargas, reason); err != nil {
+			result.Status = "error"
+			result.ErrorMessage = fmt.Sprintf("Failed to queue cargas for review: %v", err)
+			result.ErrorClass = ErrorClassTransient
+			return result
+		}
+		
+		result.Status = PendingCargaNeedsReview
+		result.ErrorMessage = fmt.Sprintf("%d carga(s) en revisión: %s", len(cargas), reason)
+		p.logger.Infof("👀 Mensaje %s: %d carga(s) en revisión (%s)", msg.ID, len(cargas), reason)
+		return result
+	}
+	
+	// 3. Guardar las cargas en el outbox: el dispatcher las publica en Supabase en background,
+	// así un corte de Supabase no obliga a pagar otra llamada a la IA
+	result.AIResponse = string(normalizedResponse)
+	outboxID, err := p.messageStore.EnqueueOutbox(msg, normalizedResponse)
 	if err != nil {
 		result.Status = "error"
-		result.ErrorMessage = fmt.Sprintf("Supabase upload failed: %v", err)
-		result.AIResponse = string(normalizedResponse) // Guardar respuesta normalizada
-		p.logger.Errorf("Error subiendo a Supabase: %v", err)
-		p.logger.Errorf("JSON que causó el error: %s", string(normalizedResponse))
+		result.ErrorMessage = fmt.Sprintf("Failed to queue cargas for publishing: %v", err)
+		result.ErrorClass = ErrorClassTransient
+		p.logger.Errorf("Error guardando cargas en el outbox: %v", err)
 		return result
 	}
 	
-	result.SupabaseIDs = supabaseIDs
-	result.Status = "success"
-	p.logger.Infof("Mensaje %s procesado exitosamente: %d cargas creadas", msg.ID, len(supabaseIDs))
+	result.Status = ProcessingStatusExtracted
+	result.ErrorMessage = fmt.Sprintf("%d carga(s) en cola de publicación", len(cargasTemp))
+	p.logger.Infof("📤 Mensaje %s: %d carga(s) en el outbox (entrada %d)", msg.ID, len(cargasTemp), outboxID)
 	p.logger.Infof("🟢 PROCESAMIENTO FINALIZADO EXITOSAMENTE para mensaje %s", msg.ID)
 	
-	// Actualizar perfil: carga válida procesada exitosamente (+1 confianza)
-	if err := p.messageStore.UpdatePhoneProfiling(msg.RealPhone, true); err != nil {
-		p.logger.Warnf("Error actualizando perfil para %s: %v", msg.RealPhone, err)
-	} else {
-		p.logger.Infof("📈 Perfil actualizado: %s (+1 confianza, loader confirmado)", msg.RealPhone)
-	}
-	
 	return result
 }
 
 // SimulateMessage procesa un mensaje sin guardarlo en la base de datos (para simulador)
 func (p *MessageProcessor) SimulateMessage(messageContent, realPhone string) ProcessingResult {
-	// Crear un mensaje simulado
-	simulatedMsg := ProcessableMessage{
-		ChatMessage: ChatMessage{
-			ID:          "simulation-" + fmt.Sprintf("%d", time.Now().Unix()),
-			ChatJID:     "simulation@chat",
-			Content:     messageContent,
-			SenderPhone: "simulation-sender",
-			IsFromMe:    false,
-		},
-		RealPhone: realPhone,
-	}
-	
-	// Procesar el mensaje (sin guardar en BD ni subir a Supabase)
-	result := ProcessingResult{
-		MessageID:   simulatedMsg.ID,
-		ChatJID:     simulatedMsg.ChatJID,
-		Content:     simulatedMsg.Content,
-		SenderPhone: simulatedMsg.SenderPhone,
-		RealPhone:   simulatedMsg.RealPhone,
-		Status:      "processing",
-		ProcessedAt: time.Now(),
-	}
+	return p.SimulateMessageAt(messageContent, realPhone, time.Time{})
+}
+
+// SimulateMessageAt es SimulateMessage con una fecha de referencia para {{fecha}} (cero = ahora).
+// Permite que los casos de evaluación con "hoy"/"mañana" sean reproducibles.
+func (p *MessageProcessor) SimulateMessageAt(messageContent, realPhone string, referenceTime time.Time) ProcessingResult {
+	result := newSimulationResult(messageContent, realPhone)
 	
 	// Verificar si hay configuración activa de IA
 	activeConfig, err := p.aiConfigManager.GetActiveConfig()
@@ -285,12 +479,21 @@ func (p *MessageProcessor) SimulateMessage(messageContent, realPhone string) Pro
 	}
 	
 	var aiResponse []byte
+	var callConfig *AIConfigDB
+	var callPrompt *PromptTemplate
+	promptMsg := PromptMessage{Content: messageContent, RealPhone: realPhone, Now: referenceTime}
 	
 	// Procesar con IA
 	p.logger.Infof("Simulando procesamiento de mensaje")
 	
 	if activeConfig != nil {
-		aiResponse, err = p.aiProviderService.ProcessMessage(p.systemPrompt, messageContent, realPhone)
+		var callResult *AICallResult
+		callResult, err = p.aiProviderService.ProcessMessage(promptMsg)
+		aiResponse = callResult.Response
+		callConfig = callResult.Config
+		callPrompt = callResult.Prompt
+		result.FailoverChain = callResult.FailoverChain
+		setPromptVersion(&result, callPrompt)
 	} else {
 		if p.aiService == nil {
 			result.Status = "error"
@@ -307,30 +510,52 @@ func (p *MessageProcessor) SimulateMessage(messageContent, realPhone string) Pro
 		return result
 	}
 	
-	result.AIResponse = string(aiResponse)
-	
-	// Validar respuesta de IA
-	if err := p.aiProviderService.ValidateResponse(aiResponse); err != nil {
-		result.Status = "error"
-		result.ErrorMessage = fmt.Sprintf("Invalid AI response: %v", err)
-		p.logger.Errorf("Respuesta de IA inválida (simulación): %v", err)
-		return result
+	return p.finishSimulation(result, callConfig, callPrompt, promptMsg, aiResponse)
+}
+
+// SimulateRecordedResponse corre el camino de SimulateMessage con una respuesta de IA ya grabada,
+// sin llamar a ningún proveedor ni tocar la base de datos (evaluación offline)
+func (p *MessageProcessor) SimulateRecordedResponse(messageContent, realPhone string, aiResponse []byte) ProcessingResult {
+	result := newSimulationResult(messageContent, realPhone)
+	promptMsg := PromptMessage{Content: messageContent, RealPhone: realPhone}
+	
+	// Sin config no hay auto-reparación: la respuesta grabada ya es la final
+	return p.finishSimulation(result, nil, nil, promptMsg, aiResponse)
+}
+
+// newSimulationResult crea el resultado de un mensaje simulado
+func newSimulationResult(messageContent, realPhone string) ProcessingResult {
+	return ProcessingResult{
+		MessageID:   "simulation-" + fmt.Sprintf("%d", time.Now().Unix()),
+		ChatJID:     "simulation@chat",
+		Content:     messageContent,
+		SenderPhone: "simulation-sender",
+		RealPhone:   realPhone,
+		Status:      "processing",
+		ProcessedAt: time.Now(),
 	}
+}
+
+// finishSimulation valida la respuesta de IA de un mensaje simulado (sin guardar en BD ni subir a Supabase)
+func (p *MessageProcessor) finishSimulation(result ProcessingResult, callConfig *AIConfigDB, callPrompt *PromptTemplate, promptMsg PromptMessage, aiResponse []byte) ProcessingResult {
+	result.AIResponse = string(aiResponse)
 	
-	// Normalizar respuesta
-	normalizedResponse, err := p.aiProviderService.NormalizeResponse(aiResponse)
+	// Validar, normalizar y verificar esquema (con auto-reparación)
+	normalizedResponse, err := p.validateWithRepair(&result, callConfig, callPrompt, promptMsg, aiResponse)
 	if err != nil {
 		result.Status = "error"
-		result.ErrorMessage = fmt.Sprintf("Failed to normalize AI response: %v", err)
-		p.logger.Errorf("Error normalizando respuesta (simulación): %v", err)
+		result.ErrorMessage = err.Error()
+		p.logger.Errorf("Respuesta de IA inválida (simulación): %v", err)
 		return result
 	}
 	
-	result.AIResponse = string(normalizedResponse)
-	
 	// Verificar si el array está vacío
 	var cargasTemp []map[string]interface{}
-	json.Unmarshal(normalizedResponse, &cargasTemp)
+	if err := json.Unmarshal(normalizedResponse, &cargasTemp); err != nil {
+		rejectBySchema(&result, normalizedResponse, err)
+		p.logger.Errorf("Respuesta de IA inválida (simulación): %v", err)
+		return result
+	}
 	
 	if len(cargasTemp) == 0 {
 		result.Status = "success"
@@ -349,18 +574,151 @@ func (p *MessageProcessor) SimulateMessage(messageContent, realPhone string) Pro
 	// En simulación, no subimos a Supabase, solo devolvemos el resultado
 	result.Status = "success"
 	result.ErrorMessage = fmt.Sprintf("Simulación exitosa: %d carga(s) detectada(s)", len(cargasTemp))
+	if doubtful := doubtfulCatalogMatches(result.CatalogMatches); doubtful != "" {
+		result.ErrorMessage += fmt.Sprintf(" (irían a revisión por mapeo dudoso de catálogo: %s)", doubtful)
+	}
 	
 	return result
 }
 
+// checkAIResponse valida el JSON, lo normaliza a array, mapea los valores de catálogo y verifica el esquema de cada carga.
+// Devuelve la respuesta normalizada, los mapeos de catálogo, los errores de esquema por campo y el error (nil si es válida).
+func (p *MessageProcessor) checkAIResponse(aiResponse []byte) ([]byte, []CatalogMatch, []CargaFieldError, error) {
+	if err := p.aiProviderService.ValidateResponse(aiResponse); err != nil {
+		return nil, nil, nil, fmt.Errorf("Invalid AI response: %v", err)
+	}
+	
+	// Normalizar respuesta (convertir objeto único a array si es necesario)
+	normalizedResponse, err := p.aiProviderService.NormalizeResponse(aiResponse)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("Failed to normalize AI response: %v", err)
+	}
+	
+	// Llevar "maíz", "bolsones", "jaula" y compañía al valor del catálogo antes de validar
+	normalizedResponse, matches := p.matchCatalogValues(normalizedResponse)
+	
+	// Validar cada carga contra el esquema (obligatorios, catálogos, fechas, números)
+	if fieldErrors := ValidateCargasSchema(normalizedResponse); len(fieldErrors) > 0 {
+		return normalizedResponse, matches, fieldErrors, fmt.Errorf("Schema validation failed: %s", FormatCargaFieldErrors(fieldErrors))
+	}
+	
+	return normalizedResponse, matches, nil, nil
+}
+
+// repairCallError indica que la respuesta era inválida pero el pedido de corrección falló antes de que el modelo
+// respondiera (presupuesto, timeout, red): no es un error del esquema sino de la llamada a la IA
+type repairCallError struct {
+	validationErr error
+	cause         error
+}
+
+func (e *repairCallError) Error() string {
+	return fmt.Sprintf("%v (no se pudo pedir la corrección: %v)", e.validationErr, e.cause)
+}
+
+func (e *repairCallError) Unwrap() error {
+	return e.cause
+}
+
+// validateWithRepair valida la respuesta de IA y, si es inválida, le pide al mismo modelo que la corrija
+// (hasta maxRepairAttempts veces). Actualiza AIResponse, ValidationErrors y RepairAttempts del resultado.
+func (p *MessageProcessor) validateWithRepair(result *ProcessingResult, config *AIConfigDB, prompt *PromptTemplate, msg PromptMessage, aiResponse []byte) ([]byte, error) {
+	normalizedResponse, matches, fieldErrors, err := p.checkAIResponse(aiResponse)
+	
+	// Sin config (servicio legacy) no hay a quién pedirle la corrección
+	for err != nil && config != nil && prompt != nil && result.RepairAttempts < maxRepairAttempts {
+		result.RepairAttempts++
+		p.logger.Warnf("🔧 Respuesta inválida, pidiendo corrección al modelo (%d/%d): %v", result.RepairAttempts, maxRepairAttempts, err)
+		
+		repaired, repairErr := p.aiProviderService.RepairResponse(config, prompt, msg, aiResponse, err)
+		if repairErr != nil {
+			p.logger.Warnf("Error pidiendo corrección: %v", repairErr)
+			err = &repairCallError{validationErr: err, cause: repairErr}
+			break
+		}
+		
+		aiResponse = repaired
+		normalizedResponse, matches, fieldErrors, err = p.checkAIResponse(aiResponse)
+	}
+	
+	result.AIResponse = string(aiResponse)
+	if normalizedResponse != nil {
+		result.AIResponse = string(normalizedResponse)
+	}
+	result.ValidationErrors = fieldErrors
+	result.CatalogMatches = matches
+	
+	if err != nil {
+		return nil, err
+	}
+	
+	if result.RepairAttempts > 0 {
+		p.logger.Infof("✅ Respuesta corregida por el modelo después de %d intento(s) de reparación", result.RepairAttempts)
+	}
+	return normalizedResponse, nil
+}
+
+// rejectBySchema marca el resultado como rechazado por el esquema cuando la respuesta normalizada
+// no se puede decodificar como cargas (mismo tratamiento que un error de ValidateCargasSchema)
+func rejectBySchema(result *ProcessingResult, normalizedResponse []byte, err error) {
+	result.Status = "error"
+	result.ErrorMessage = fmt.Sprintf("Schema validation failed: la respuesta no es un array de cargas: %v", err)
+	result.ErrorClass = ErrorClassPermanent
+	result.AIResponse = string(normalizedResponse)
+	result.ValidationErrors = []CargaFieldError{{Message: fmt.Sprintf("la respuesta no es un array de cargas: %v", err)}}
+}
+
+// setPromptVersion registra en el resultado qué versión del prompt produjo la respuesta
+func setPromptVersion(result *ProcessingResult, prompt *PromptTemplate) {
+	if prompt == nil {
+		return
+	}
+	result.PromptTemplateID = prompt.ID
+	result.PromptVersion = prompt.Label()
+}
+
 // saveProcessingResult guarda el resultado del procesamiento en la base de datos
 func (p *MessageProcessor) saveProcessingResult(result ProcessingResult) error {
 	supabaseIDsJSON, _ := json.Marshal(result.SupabaseIDs)
 	
+	// La cadena de failover solo se guarda si hubo al menos un cambio de config
+	var failoverChainJSON sql.NullString
+	if len(result.FailoverChain) > 0 {
+		if data, err := json.Marshal(result.FailoverChain); err == nil {
+			failoverChainJSON = sql.NullString{String: string(data), Valid: true}
+		}
+	}
+	
+	// Errores de esquema por campo (solo si la extracción fue rechazada por el esquema)
+	var validationErrorsJSON sql.NullString
+	if len(result.ValidationErrors) > 0 {
+		if data, err := json.Marshal(result.ValidationErrors); err == nil {
+			validationErrorsJSON = sql.NullString{String: string(data), Valid: true}
+		}
+	}
+	
+	// Valores de catálogo corregidos por aproximación (solo si hubo alguno)
+	var catalogMatchesJSON sql.NullString
+	if len(result.CatalogMatches) > 0 {
+		if data, err := json.Marshal(result.CatalogMatches); err == nil {
+			catalogMatchesJSON = sql.NullString{String: string(data), Valid: true}
+		}
+	}
+	
+	// Versión del prompt (NULL si se usó el servicio legacy o el prompt del archivo)
+	var promptTemplateID sql.NullInt64
+	if result.PromptTemplateID > 0 {
+		promptTemplateID = sql.NullInt64{Int64: int64(result.PromptTemplateID), Valid: true}
+	}
+	var promptVersion sql.NullString
+	if result.PromptVersion != "" {
+		promptVersion = sql.NullString{String: result.PromptVersion, Valid: true}
+	}
+	
 	query := `
 		INSERT INTO ai_processing_results 
-		(message_id, chat_jid, content, sender_phone, real_phone, ai_response, status, error_message, supabase_ids, processed_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		(message_id, chat_jid, content, sender_phone, real_phone, ai_response, status, error_message, supabase_ids, failover_chain, validation_errors, catalog_matches, repair_attempts, prompt_template_id, prompt_version, processed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 	
 	_, err := p.messageStore.db.Exec(query,
@@ -373,6 +731,12 @@ func (p *MessageProcessor) saveProcessingResult(result ProcessingResult) error {
 		result.Status,
 		result.ErrorMessage,
 		string(supabaseIDsJSON),
+		failoverChainJSON,
+		validationErrorsJSON,
+		catalogMatchesJSON,
+		result.RepairAttempts,
+		promptTemplateID,
+		promptVersion,
 		result.ProcessedAt,
 	)
 	
@@ -383,7 +747,8 @@ func (p *MessageProcessor) saveProcessingResult(result ProcessingResult) error {
 func (p *MessageProcessor) GetProcessingResults(limit int) ([]ProcessingResult, error) {
 	query := `
 		SELECT apr.id, apr.message_id, apr.chat_jid, apr.content, apr.sender_phone, apr.real_phone, 
-		       apr.ai_response, apr.status, apr.error_message, apr.supabase_ids, apr.processed_at,
+		       apr.ai_response, apr.status, apr.error_message, apr.supabase_ids, apr.failover_chain, apr.validation_errors, apr.catalog_matches, apr.repair_attempts,
+		       COALESCE(apr.prompt_template_id, 0), COALESCE(apr.prompt_version, ''), apr.processed_at,
 		       COALESCE(m.processing_attempts, 0) as processing_attempts
 		FROM ai_processing_results apr
 		LEFT JOIN messages m ON apr.message_id = m.id AND apr.chat_jid = m.chat_jid
@@ -400,7 +765,7 @@ func (p *MessageProcessor) GetProcessingResults(limit int) ([]ProcessingResult,
 	var results []ProcessingResult
 	for rows.Next() {
 		var result ProcessingResult
-		var supabaseIDsJSON sql.NullString
+		var supabaseIDsJSON, failoverChainJSON, validationErrorsJSON, catalogMatchesJSON sql.NullString
 		
 		err := rows.Scan(
 			&result.ID,
@@ -413,6 +778,12 @@ func (p *MessageProcessor) GetProcessingResults(limit int) ([]ProcessingResult,
 			&result.Status,
 			&result.ErrorMessage,
 			&supabaseIDsJSON,
+			&failoverChainJSON,
+			&validationErrorsJSON,
+			&catalogMatchesJSON,
+			&result.RepairAttempts,
+			&result.PromptTemplateID,
+			&result.PromptVersion,
 			&result.ProcessedAt,
 			&result.ProcessingAttempts,
 		)
@@ -424,6 +795,15 @@ func (p *MessageProcessor) GetProcessingResults(limit int) ([]ProcessingResult,
 		if supabaseIDsJSON.Valid {
 			json.Unmarshal([]byte(supabaseIDsJSON.String), &result.SupabaseIDs)
 		}
+		if failoverChainJSON.Valid {
+			json.Unmarshal([]byte(failoverChainJSON.String), &result.FailoverChain)
+		}
+		if validationErrorsJSON.Valid {
+			json.Unmarshal([]byte(validationErrorsJSON.String), &result.ValidationErrors)
+		}
+		if catalogMatchesJSON.Valid {
+			json.Unmarshal([]byte(catalogMatchesJSON.String), &result.CatalogMatches)
+		}
 		
 		results = append(results, result)
 	}
@@ -467,9 +847,14 @@ func (p *MessageProcessor) GetProcessingStats() (map[string]interface{}, error)
 	}
 	
 	stats["status_counts"] = statusCounts
-	stats["total_processed_today"] = statusCounts["success"] + statusCounts["error"]
+	stats["total_processed_today"] = statusCounts["success"] + statusCounts["error"] +
+		statusCounts[ProcessingStatusExtracted] + statusCounts[ProcessingStatusPublishFailed] +
+		statusCounts[PendingCargaNeedsReview] + statusCounts[PendingCargaRejected]
 	stats["success_count"] = statusCounts["success"]
-	stats["error_count"] = statusCounts["error"]
+	stats["error_count"] = statusCounts["error"] + statusCounts[ProcessingStatusPublishFailed]
+	stats["pending_publication_count"] = statusCounts[ProcessingStatusExtracted]
+	stats["review_count"] = statusCounts[PendingCargaNeedsReview]
+	stats["rejected_count"] = statusCounts[PendingCargaRejected]
 	
 	// Contar total de cargas subidas hoy
 	cargasQuery := `
@@ -493,6 +878,16 @@ func (p *MessageProcessor) GetProcessingStats() (map[string]interface{}, error)
 		stats["total_cargas"] = 0
 	}
 	
+	// Costos de IA (por día, por carga y por proveedor) de los últimos 30 días
+	costStats, err := getCostStats(p.messageStore.db, 30)
+	if err != nil {
+		p.logger.Warnf("Error calculando costos de IA: %v", err)
+	} else {
+		for key, value := range costStats {
+			stats[key] = value
+		}
+	}
+	
 	return stats, nil
 }
 
@@ -503,6 +898,8 @@ func (p *MessageProcessor) GetProcessableMessagesCount() (int, error) {
 
 // ProcessSingleMessage procesa un solo mensaje por ID
 func (p *MessageProcessor) ProcessSingleMessage(messageID, chatJID string) (ProcessingResult, error) {
+	p.refreshCatalogsIfStale()
+	
 	// Buscar el mensaje directamente por ID, sin importar su estado
 	// Esto permite reprocesar mensajes ya procesados o con errores
 	targetMessage, err := p.messageStore.GetMessageByID(messageID, chatJID)
@@ -514,6 +911,16 @@ func (p *MessageProcessor) ProcessSingleMessage(messageID, chatJID string) (Proc
 		return ProcessingResult{}, fmt.Errorf("message not found")
 	}
 	
+	// Evitar que otra instancia (o el procesamiento por lotes) lo procese al mismo tiempo
+	if err := p.messageStore.ClaimMessage(targetMessage.ID, targetMessage.ChatJID); err != nil {
+		return ProcessingResult{}, err
+	}
+	defer func() {
+		if err := p.messageStore.ReleaseClaim(targetMessage.ID, targetMessage.ChatJID); err != nil {
+			p.logger.Warnf("Error liberando claim de %s: %v", targetMessage.ID, err)
+		}
+	}()
+	
 	p.logger.Infof("Procesando mensaje individual: %s (permitir reprocesar)", messageID)
 	
 	// Procesar el mensaje
@@ -526,14 +933,15 @@ func (p *MessageProcessor) ProcessSingleMessage(messageID, chatJID string) (Proc
 	
 	// Manejar el resultado según el estado
 	switch result.Status {
-	case "success":
+	case "success", PendingCargaNeedsReview, ProcessingStatusExtracted:
 		if err := p.messageStore.MarkMessageAsProcessed(targetMessage.ID, targetMessage.ChatJID); err != nil {
 			p.logger.Errorf("Error marcando mensaje como procesado: %v", err)
 		}
-	case "error":
-		if err := p.messageStore.IncrementProcessingAttempt(targetMessage.ID, targetMessage.ChatJID, result.ErrorMessage); err != nil {
-			p.logger.Errorf("Error incrementando intentos: %v", err)
+		if result.Status == ProcessingStatusExtracted {
+			p.wakeOutbox()
 		}
+	case "error":
+		p.recordFailure(&result, targetMessage.ID, targetMessage.ChatJID)
 	}
 	
 	p.logger.Infof("🏁 ProcessSingleMessage finalizando - devolviendo resultado con status: %s", result.Status)
@@ -623,6 +1031,11 @@ func (p *MessageProcessor) validateLocations(jsonData []byte) error {
 			}
 		}
 		
+		// Verificar que la localidad exista (nomenclador local y, si no está, geocoding)
+		if err := p.checkGazetteer(localidadCarga); err != nil {
+			return fmt.Errorf("carga %d: localidadCarga '%s' %v", i+1, localidadCarga, err)
+		}
+		
 		// Validar localidad de descarga
 		localidadDescarga, _ := carga["localidadDescarga"].(string)
 		if localidadDescarga == "" {
@@ -660,25 +1073,30 @@ func (p *MessageProcessor) validateLocations(jsonData []byte) error {
 				return fmt.Errorf("carga %d: localidadDescarga contiene '%s' - el mensaje no tiene información de ubicación válida", i+1, term)
 			}
 		}
+		
+		// Verificar que la localidad exista (nomenclador local y, si no está, geocoding)
+		if err := p.checkGazetteer(localidadDescarga); err != nil {
+			return fmt.Errorf("carga %d: localidadDescarga '%s' %v", i+1, localidadDescarga, err)
+		}
 	}
 	
 	return nil
 }
 
-// GetProcessedToday obtiene mensajes procesados exitosamente hoy
+// GetProcessedToday obtiene mensajes procesados hoy sin error: publicados, en revisión o rechazados por el operador
 func (p *MessageProcessor) GetProcessedToday(limit int) ([]ProcessingResult, error) {
 	query := `
 		SELECT 
 			id, message_id, chat_jid, content, sender_phone, real_phone, 
 			ai_response, status, error_message, supabase_ids, processed_at
 		FROM ai_processing_results
-		WHERE status = 'success' 
+		WHERE status IN ('success', ?, ?) 
 		  AND DATE(processed_at) = CURDATE()
 		ORDER BY processed_at DESC
 		LIMIT ?
 	`
 	
-	rows, err := p.messageStore.db.Query(query, limit)
+	rows, err := p.messageStore.db.Query(query, PendingCargaNeedsReview, PendingCargaRejected, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -716,19 +1134,20 @@ func (p *MessageProcessor) GetProcessedToday(limit int) ([]ProcessingResult, err
 	return results, nil
 }
 
-// GetMessagesWithErrors obtiene mensajes que tuvieron errores
+// GetMessagesWithErrors obtiene mensajes que tuvieron errores, incluidos los que la IA extrajo
+// pero el outbox no pudo publicar (publish_failed)
 func (p *MessageProcessor) GetMessagesWithErrors(limit int) ([]ProcessingResult, error) {
 	query := `
 		SELECT 
 			id, message_id, chat_jid, content, sender_phone, real_phone, 
 			ai_response, status, error_message, supabase_ids, processed_at
 		FROM ai_processing_results
-		WHERE status = 'error'
+		WHERE status IN ('error', ?)
 		ORDER BY processed_at DESC
 		LIMIT ?
 	`
 	
-	rows, err := p.messageStore.db.Query(query, limit)
+	rows, err := p.messageStore.db.Query(query, ProcessingStatusPublishFailed, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxComparedConfigs limita cuántas configuraciones se comparan a la vez (cada una es una llamada real)
+const maxComparedConfigs = 6
+
+// ModelComparisonResult es el resultado de una configuración en la comparación de modelos
+type ModelComparisonResult struct {
+	ConfigID         int               `json:"config_id"`
+	ConfigName       string            `json:"config_name"`
+	Provider         string            `json:"provider"`
+	Model            string            `json:"model"`
+	PromptVersion    string            `json:"prompt_version"`
+	Status           string            `json:"status"` // "success" o "error"
+	ErrorMessage     string            `json:"error_message,omitempty"`
+	LatencyMs        int64             `json:"latency_ms"`
+	Usage            TokenUsage        `json:"usage"`
+	EstimatedCost    float64           `json:"estimated_cost"` // USD
+	ValidationErrors []CargaFieldError `json:"validation_errors,omitempty"`
+	LocationError    string            `json:"location_error,omitempty"`
+	AIResponse       string            `json:"ai_response"`
+	Cargas           []CargaData       `json:"cargas"`
+}
+
+// ComparisonFieldDiff es un campo de una carga en el que los modelos no coinciden
+type ComparisonFieldDiff struct {
+	Index  int      `json:"index"` // Posición de la carga (desde 1)
+	Field  string   `json:"field"`
+	Values []string `json:"values"` // Un valor por resultado, en el mismo orden que Results ("" si no extrajo esa carga)
+}
+
+// ModelComparison es la comparación lado a lado de un mismo mensaje procesado por varias configuraciones
+type ModelComparison struct {
+	Message    string                  `json:"message"`
+	Results    []ModelComparisonResult `json:"results"`
+	FieldDiffs []ComparisonFieldDiff   `json:"field_diffs"`
+	Agreement  float64                 `json:"agreement"` // Porcentaje de campos en los que coinciden todos los modelos que respondieron
+}
+
+// CompareModels envía el mismo mensaje a varias configuraciones de IA en paralelo, sin failover ni auto-reparación
+// (para ver la calidad real de cada modelo), y no guarda nada en la base de datos ni en Supabase.
+// Las llamadas sí cuentan en el presupuesto y en ai_call_log, como cualquier otra.
+func (p *MessageProcessor) CompareModels(messageContent, realPhone string, configIDs []int) (*ModelComparison, error) {
+	if len(configIDs) < 2 {
+		return nil, fmt.Errorf("selecciona al menos 2 configuraciones para comparar")
+	}
+	if len(configIDs) > maxComparedConfigs {
+		return nil, fmt.Errorf("se pueden comparar hasta %d configuraciones a la vez", maxComparedConfigs)
+	}
+
+	allConfigs, err := p.aiConfigManager.GetAllConfigs()
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo configuraciones: %v", err)
+	}
+
+	configsByID := make(map[int]*AIConfigDB, len(allConfigs))
+	for i := range allConfigs {
+		configsByID[allConfigs[i].ID] = &allConfigs[i]
+	}
+
+	configs := make([]*AIConfigDB, 0, len(configIDs))
+	for _, id := range configIDs {
+		config, ok := configsByID[id]
+		if !ok {
+			return nil, fmt.Errorf("configuración %d no encontrada", id)
+		}
+		configs = append(configs, config)
+	}
+
+	promptMsg := PromptMessage{Content: messageContent, RealPhone: realPhone}
+	comparison := &ModelComparison{
+		Message: messageContent,
+		Results: make([]ModelComparisonResult, len(configs)),
+	}
+
+	p.logger.Infof("⚖️ Comparando %d configuraciones de IA", len(configs))
+
+	var wg sync.WaitGroup
+	for i, config := range configs {
+		wg.Add(1)
+		go func(i int, config *AIConfigDB) {
+			defer wg.Done()
+			comparison.Results[i] = p.compareConfig(config, promptMsg)
+		}(i, config)
+	}
+	wg.Wait()
+
+	comparison.FieldDiffs, comparison.Agreement = diffComparisonCargas(comparison.Results)
+	return comparison, nil
+}
+
+// compareConfig procesa el mensaje con una configuración y valida la respuesta tal como la devolvió el modelo
+func (p *MessageProcessor) compareConfig(config *AIConfigDB, promptMsg PromptMessage) ModelComparisonResult {
+	result := ModelComparisonResult{
+		ConfigID:   config.ID,
+		ConfigName: config.Name,
+		Provider:   config.ProviderDisplay,
+		Model:      config.ModelDisplay,
+		Status:     "error",
+	}
+
+	startTime := time.Now()
+	aiResponse, prompt, entry, err := p.aiProviderService.CompareWithConfig(config, promptMsg)
+	result.LatencyMs = time.Since(startTime).Milliseconds()
+	if entry.Latency > 0 {
+		result.LatencyMs = entry.Latency.Milliseconds()
+	}
+	result.Usage = entry.Usage
+	result.EstimatedCost = entry.EstimatedCost
+	if prompt != nil {
+		result.PromptVersion = prompt.Label()
+	}
+
+	if err != nil {
+		result.ErrorMessage = fmt.Sprintf("AI processing failed: %v", err)
+		return result
+	}
+	result.AIResponse = string(aiResponse)
+
+	normalizedResponse, _, fieldErrors, err := p.checkAIResponse(aiResponse)
+	result.ValidationErrors = fieldErrors
+	if normalizedResponse != nil {
+		result.AIResponse = string(normalizedResponse)
+		// Las cargas se muestran aunque no pasen el esquema, para poder compararlas
+		json.Unmarshal(normalizedResponse, &result.Cargas)
+	}
+	if err != nil {
+		result.ErrorMessage = err.Error()
+		return result
+	}
+
+	if len(result.Cargas) > 0 {
+		if err := p.validateLocations(normalizedResponse); err != nil {
+			result.LocationError = err.Error()
+		}
+	}
+
+	result.Status = "success"
+	return result
+}
+
+// diffComparisonCargas compara campo a campo las cargas de los resultados (por posición) y devuelve
+// los campos en los que no coinciden todos, junto con el porcentaje de coincidencia.
+// Los resultados con error de llamada no participan del acuerdo, pero muestran su columna vacía.
+func diffComparisonCargas(results []ModelComparisonResult) ([]ComparisonFieldDiff, float64) {
+	maxCargas := 0
+	responded := 0
+	for _, r := range results {
+		if r.AIResponse == "" {
+			continue
+		}
+		responded++
+		if len(r.Cargas) > maxCargas {
+			maxCargas = len(r.Cargas)
+		}
+	}
+
+	diffs := []ComparisonFieldDiff{}
+	if responded < 2 {
+		return diffs, 0
+	}
+	if maxCargas == 0 {
+		// Todos respondieron un array vacío: coinciden por completo
+		return diffs, 100
+	}
+
+	totalFields, matchingFields := 0, 0
+	for index := 0; index < maxCargas; index++ {
+		values := make([]map[string]string, len(results))
+		for i, r := range results {
+			if index < len(r.Cargas) {
+				values[i] = cargaFieldValues(r.Cargas[index])
+			}
+		}
+
+		for _, field := range evalFields {
+			diff := ComparisonFieldDiff{Index: index + 1, Field: field, Values: make([]string, len(results))}
+			var reference *string
+			match := true
+
+			for i, r := range results {
+				diff.Values[i] = values[i][field]
+				if r.AIResponse == "" {
+					continue
+				}
+				if reference == nil {
+					reference = &diff.Values[i]
+					continue
+				}
+				if !evalValuesMatch(field, *reference, diff.Values[i]) {
+					match = false
+				}
+			}
+			// Una carga que falta en un modelo cuenta como diferencia en todos sus campos
+			for i, r := range results {
+				if r.AIResponse != "" && values[i] == nil {
+					match = false
+				}
+			}
+
+			totalFields++
+			if match {
+				matchingFields++
+			} else {
+				diffs = append(diffs, diff)
+			}
+		}
+	}
+
+	return diffs, float64(matchingFields) / float64(totalFields) * 100
+}
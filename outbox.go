@@ -0,0 +1,501 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Estados de ai_processing_results mientras las cargas esperan en el outbox
+const (
+	ProcessingStatusExtracted     = "extracted"      // La IA extrajo las cargas; falta publicarlas en Supabase
+	ProcessingStatusPublishFailed = "publish_failed" // El outbox agotó los intentos de publicación
+)
+
+// Estados de una entrada de supabase_outbox
+const (
+	OutboxPending    = "pending"    // Esperando al dispatcher (o a su próximo reintento)
+	OutboxPublished  = "published"  // Todas las cargas quedaron en Supabase
+	OutboxFailed     = "failed"     // Error permanente o sin más reintentos: requiere acción del operador
+	OutboxDiscarded  = "discarded"  // Descartada por el operador
+	OutboxSuperseded = "superseded" // El mensaje se volvió a procesar y tiene una entrada más nueva
+)
+
+// Reintentos de publicación: Supabase suele volver rápido, así que el backoff es más corto que el de la IA
+const (
+	outboxMaxAttempts      = 8
+	outboxBaseDelay        = 30 * time.Second
+	outboxMaxDelay         = 30 * time.Minute
+	outboxClaimLease       = 5 * time.Minute
+	outboxDispatchInterval = 30 * time.Second
+	outboxBatchSize        = 10
+)
+
+// errOutboxClaimLost indica que la entrada ya no está reclamada por esta instancia: se la reemplazó
+// (el mensaje se volvió a procesar) o la lease venció y la tomó otro dispatcher
+var errOutboxClaimLost = errors.New("la entrada del outbox ya no está reclamada por esta instancia")
+
+// OutboxEntry es la respuesta de la IA de un mensaje esperando a publicarse en Supabase
+type OutboxEntry struct {
+	ID            int        `json:"id"`
+	MessageID     string     `json:"message_id"`
+	ChatJID       string     `json:"chat_jid"`
+	ChatName      string     `json:"chat_name"`
+	RealPhone     string     `json:"real_phone"`
+	Content       string     `json:"content"`
+	Payload       string     `json:"payload"` // JSON normalizado de las cargas
+	CargaCount    int        `json:"carga_count"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `json:"next_attempt_at"`
+	LastError     string     `json:"last_error"`
+	ErrorClass    string     `json:"error_class"`
+	SupabaseIDs   []string   `json:"supabase_ids"`
+	CreatedAt     time.Time  `json:"created_at"`
+	PublishedAt   *time.Time `json:"published_at"`
+}
+
+// EnqueueOutbox guarda las cargas extraídas de un mensaje para publicarlas después.
+// Las entradas anteriores del mismo mensaje que no se publicaron quedan reemplazadas, salvo la que un
+// dispatcher esté publicando en este momento: esa termina, y la nueva se publica después modificando
+// las cargas que cambiaron (ver publishCarga).
+func (store *MessageStore) EnqueueOutbox(msg ProcessableMessage, payload []byte) (int, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin outbox transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE supabase_outbox SET status = ?
+		WHERE message_id = ? AND chat_jid = ? AND status IN (?, ?)
+		  AND (claimed_until IS NULL OR claimed_until < NOW())
+	`, OutboxSuperseded, msg.ID, msg.ChatJID, OutboxPending, OutboxFailed)
+	if err != nil {
+		return 0, fmt.Errorf("failed to supersede outbox entries: %v", err)
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO supabase_outbox (message_id, chat_jid, real_phone, payload, status)
+		VALUES (?, ?, ?, ?, ?)
+	`, msg.ID, msg.ChatJID, msg.RealPhone, string(payload), OutboxPending)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert outbox entry: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit outbox entry: %v", err)
+	}
+
+	id, _ := res.LastInsertId()
+	return int(id), nil
+}
+
+const outboxSelectQuery = `
+		SELECT o.id, o.message_id, o.chat_jid, COALESCE(c.name, ''), COALESCE(o.real_phone, ''),
+		       COALESCE(m.content, ''), o.payload, o.status, o.attempts, o.next_attempt_at,
+		       COALESCE(o.last_error, ''), COALESCE(o.error_class, ''), COALESCE(o.supabase_ids, ''),
+		       o.created_at, o.published_at
+		FROM supabase_outbox o
+		LEFT JOIN messages m ON m.id = o.message_id AND m.chat_jid = o.chat_jid
+		LEFT JOIN chats c ON c.jid = o.chat_jid
+`
+
+// scanOutboxEntry lee una fila de outboxSelectQuery
+func scanOutboxEntry(row rowScanner) (*OutboxEntry, error) {
+	var e OutboxEntry
+	var nextAttemptAt, publishedAt sql.NullTime
+	var supabaseIDs string
+
+	err := row.Scan(&e.ID, &e.MessageID, &e.ChatJID, &e.ChatName, &e.RealPhone,
+		&e.Content, &e.Payload, &e.Status, &e.Attempts, &nextAttemptAt,
+		&e.LastError, &e.ErrorClass, &supabaseIDs,
+		&e.CreatedAt, &publishedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	var cargas []json.RawMessage
+	json.Unmarshal([]byte(e.Payload), &cargas)
+	e.CargaCount = len(cargas)
+	if supabaseIDs != "" {
+		json.Unmarshal([]byte(supabaseIDs), &e.SupabaseIDs)
+	}
+	if nextAttemptAt.Valid {
+		t := nextAttemptAt.Time
+		e.NextAttemptAt = &t
+	}
+	if publishedAt.Valid {
+		t := publishedAt.Time
+		e.PublishedAt = &t
+	}
+	return &e, nil
+}
+
+// GetOutboxEntries obtiene las entradas del outbox con un estado (las publicadas más recientes primero)
+func (store *MessageStore) GetOutboxEntries(status string, limit int) ([]OutboxEntry, error) {
+	order := "o.id ASC"
+	if status == OutboxPublished {
+		order = "o.published_at DESC"
+	}
+
+	rows, err := store.db.Query(outboxSelectQuery+`
+		WHERE o.status = ?
+		ORDER BY `+order+`
+		LIMIT ?
+	`, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get outbox entries: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []OutboxEntry{}
+	for rows.Next() {
+		e, err := scanOutboxEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *e)
+	}
+	return entries, rows.Err()
+}
+
+// GetOutboxCount cuenta las entradas que todavía no se publicaron (pendientes y fallidas)
+func (store *MessageStore) GetOutboxCount() (int, error) {
+	var count int
+	err := store.db.QueryRow(`
+		SELECT COUNT(*) FROM supabase_outbox WHERE status IN (?, ?)
+	`, OutboxPending, OutboxFailed).Scan(&count)
+	return count, err
+}
+
+// RetryOutboxEntry vuelve a poner en cola una entrada fallida, con los intentos en cero
+func (store *MessageStore) RetryOutboxEntry(id int) error {
+	res, err := store.db.Exec(`
+		UPDATE supabase_outbox
+		SET status = ?, attempts = 0, next_attempt_at = NULL, error_class = NULL
+		WHERE id = ? AND status = ?
+	`, OutboxPending, id, OutboxFailed)
+	if err != nil {
+		return fmt.Errorf("failed to retry outbox entry: %v", err)
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return fmt.Errorf("la entrada %d no está fallida", id)
+	}
+	return nil
+}
+
+// RetryFailedPublication vuelve a poner en cola la última entrada fallida de un mensaje (desde la vista de errores)
+func (store *MessageStore) RetryFailedPublication(messageID, chatJID string) error {
+	var id int
+	err := store.db.QueryRow(`
+		SELECT id FROM supabase_outbox
+		WHERE message_id = ? AND chat_jid = ? AND status = ?
+		ORDER BY id DESC
+		LIMIT 1
+	`, messageID, chatJID, OutboxFailed).Scan(&id)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("el mensaje %s no tiene publicaciones fallidas", messageID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get failed outbox entry: %v", err)
+	}
+	return store.RetryOutboxEntry(id)
+}
+
+// DiscardOutboxEntry descarta una entrada fallida (sus cargas no se publican)
+func (store *MessageStore) DiscardOutboxEntry(id int) error {
+	res, err := store.db.Exec(`
+		UPDATE supabase_outbox SET status = ? WHERE id = ? AND status = ?
+	`, OutboxDiscarded, id, OutboxFailed)
+	if err != nil {
+		return fmt.Errorf("failed to discard outbox entry: %v", err)
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return fmt.Errorf("la entrada %d no está fallida", id)
+	}
+	return nil
+}
+
+// claimOutboxEntries toma hasta limit entradas listas para publicar, con el mismo esquema de claims que los mensajes.
+// Solo se toman entradas de mensajes ya marcados como procesados: así el resultado del
+// procesamiento ya está guardado cuando el dispatcher lo actualiza.
+func (store *MessageStore) claimOutboxEntries(limit int) ([]OutboxEntry, error) {
+	lockClause := "FOR UPDATE"
+	if store.skipLocked {
+		lockClause = "FOR UPDATE SKIP LOCKED"
+	}
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin outbox claim transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT o.id
+		FROM supabase_outbox o
+		WHERE o.status = ?
+		  AND (o.next_attempt_at IS NULL OR o.next_attempt_at <= NOW())
+		  AND (o.claimed_until IS NULL OR o.claimed_until < NOW())
+		  AND EXISTS (
+		       SELECT 1 FROM messages m
+		       WHERE m.id = o.message_id AND m.chat_jid = o.chat_jid AND m.processed = 1
+		  )
+		ORDER BY o.id ASC
+		LIMIT ?
+		`+lockClause, OutboxPending, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select outbox entries to claim: %v", err)
+	}
+
+	var candidates []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		candidates = append(candidates, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var claimed []interface{}
+	for _, id := range candidates {
+		res, err := tx.Exec(`
+			UPDATE supabase_outbox
+			SET claimed_by = ?, claimed_until = NOW() + INTERVAL ? SECOND
+			WHERE id = ? AND status = ?
+			  AND (claimed_until IS NULL OR claimed_until < NOW())
+		`, store.instanceID, int(outboxClaimLease.Seconds()), id, OutboxPending)
+		if err != nil {
+			return nil, fmt.Errorf("failed to claim outbox entry %d: %v", id, err)
+		}
+		if affected, _ := res.RowsAffected(); affected == 1 {
+			claimed = append(claimed, id)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit outbox claim: %v", err)
+	}
+
+	entries := []OutboxEntry{}
+	if len(claimed) == 0 {
+		return entries, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(claimed)), ", ")
+	entryRows, err := store.db.Query(outboxSelectQuery+`
+		WHERE o.id IN (`+placeholders+`)
+		ORDER BY o.id ASC
+	`, claimed...)
+	if err != nil {
+		return nil, err
+	}
+	defer entryRows.Close()
+
+	for entryRows.Next() {
+		e, err := scanOutboxEntry(entryRows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *e)
+	}
+	return entries, entryRows.Err()
+}
+
+// markOutboxPublished registra que todas las cargas de la entrada quedaron en Supabase.
+// Solo si la entrada sigue pendiente y reclamada por esta instancia (si no, devuelve errOutboxClaimLost).
+func (store *MessageStore) markOutboxPublished(id int, supabaseIDs []string) error {
+	supabaseIDsJSON, _ := json.Marshal(supabaseIDs)
+	res, err := store.db.Exec(`
+		UPDATE supabase_outbox
+		SET status = ?, supabase_ids = ?, last_error = NULL, error_class = NULL,
+		    next_attempt_at = NULL, claimed_by = NULL, claimed_until = NULL, published_at = NOW()
+		WHERE id = ? AND claimed_by = ? AND status = ?
+	`, OutboxPublished, string(supabaseIDsJSON), id, store.instanceID, OutboxPending)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return errOutboxClaimLost
+	}
+	return nil
+}
+
+// recordOutboxFailure registra un intento de publicación fallido. Devuelve cuándo se reintenta
+// (nil si la entrada quedó fallida: error permanente o sin más intentos).
+// Como markOutboxPublished, solo actualiza la entrada si esta instancia la sigue teniendo reclamada.
+func (store *MessageStore) recordOutboxFailure(id, attempts int, errorMessage, errorClass string) (*time.Time, error) {
+	attempts++
+	status := OutboxPending
+	var nextAttemptAt *time.Time
+	if errorClass == ErrorClassPermanent || attempts >= outboxMaxAttempts {
+		status = OutboxFailed
+	} else {
+		t := time.Now().Add(backoffDelay(attempts, outboxBaseDelay, outboxMaxDelay))
+		nextAttemptAt = &t
+	}
+
+	res, err := store.db.Exec(`
+		UPDATE supabase_outbox
+		SET status = ?, attempts = ?, next_attempt_at = ?, last_error = ?, error_class = ?,
+		    claimed_by = NULL, claimed_until = NULL
+		WHERE id = ? AND claimed_by = ? AND status = ?
+	`, status, attempts, nextAttemptAt, errorMessage, errorClass, id, store.instanceID, OutboxPending)
+	if err != nil {
+		return nil, err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return nil, errOutboxClaimLost
+	}
+	return nextAttemptAt, nil
+}
+
+// wakeOutbox avisa al dispatcher que hay entradas nuevas (sin bloquear si ya estaba avisado)
+func (p *MessageProcessor) wakeOutbox() {
+	select {
+	case p.outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// StartOutboxDispatcher publica en background las entradas del outbox cada outboxDispatchInterval
+// (o apenas se procesa un mensaje), y en cada pasada recupera las aprobaciones de revisión interrumpidas.
+// Devuelve una función para detenerlo.
+func (p *MessageProcessor) StartOutboxDispatcher() func() {
+	stop := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(outboxDispatchInterval)
+		defer ticker.Stop()
+
+		p.logger.Infof("📤 Dispatcher del outbox iniciado (cada %s)", outboxDispatchInterval)
+
+		for {
+			// Aprobaciones de revisión que quedaron a mitad de camino (incluye las de antes de un reinicio)
+			if recovered, err := p.RecoverInterruptedReviews(); err != nil {
+				p.logger.Errorf("Error recuperando cargas en publicación: %v", err)
+			} else if recovered > 0 {
+				p.logger.Infof("👀 %d carga(s) en publicación interrumpida recuperada(s)", recovered)
+			}
+
+			if _, err := p.DispatchOutbox(outboxBatchSize); err != nil {
+				p.logger.Errorf("Error en el dispatcher del outbox: %v", err)
+			}
+
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			case <-p.outboxWake:
+			}
+		}
+	}()
+
+	return func() { close(stop) }
+}
+
+// DispatchOutbox publica hasta limit entradas pendientes y devuelve cuántas se publicaron
+func (p *MessageProcessor) DispatchOutbox(limit int) (int, error) {
+	p.outboxMu.Lock()
+	defer p.outboxMu.Unlock()
+
+	entries, err := p.messageStore.claimOutboxEntries(limit)
+	if err != nil {
+		return 0, err
+	}
+
+	published := 0
+	for _, entry := range entries {
+		if p.publishOutboxEntry(entry) {
+			published++
+		}
+	}
+	return published, nil
+}
+
+// publishOutboxEntry sube las cargas de una entrada (retomando desde la primera sin publicar) y
+// actualiza el resultado del procesamiento del mensaje
+func (p *MessageProcessor) publishOutboxEntry(entry OutboxEntry) bool {
+	var cargas []CargaData
+	err := json.Unmarshal([]byte(entry.Payload), &cargas)
+	errorClass := ErrorClassPermanent
+
+	var supabaseIDs []string
+	if err == nil {
+		msg := ProcessableMessage{RealPhone: entry.RealPhone}
+		msg.ID, msg.ChatJID = entry.MessageID, entry.ChatJID
+		supabaseIDs, err = p.publishCargas(msg, cargas)
+		if err != nil && !errors.Is(err, errCargaNotRecorded) {
+			errorClass = classifySupabaseError(err)
+		}
+	}
+
+	if err != nil {
+		errorMessage := fmt.Sprintf("Supabase upload failed: %v", err)
+		nextAttemptAt, dbErr := p.messageStore.recordOutboxFailure(entry.ID, entry.Attempts, errorMessage, errorClass)
+		if errors.Is(dbErr, errOutboxClaimLost) {
+			p.logger.Warnf("📤 Publicación del mensaje %s falló, pero la entrada %d ya fue reemplazada o tomada por otra instancia: %v", entry.MessageID, entry.ID, err)
+			return false
+		}
+		if dbErr != nil {
+			p.logger.Errorf("Error registrando fallo del outbox %d: %v", entry.ID, dbErr)
+			return false
+		}
+
+		if nextAttemptAt != nil {
+			p.logger.Warnf("📤 Publicación del mensaje %s falló (%s), se reintenta a las %s: %v",
+				entry.MessageID, errorClass, nextAttemptAt.Format("15:04:05"), err)
+			return false
+		}
+
+		p.logger.Errorf("📤 Publicación del mensaje %s fallida (%s): %v", entry.MessageID, errorClass, err)
+		p.updateOutboxResult(entry, ProcessingStatusPublishFailed, []string{}, errorMessage)
+		return false
+	}
+
+	if err := p.messageStore.markOutboxPublished(entry.ID, supabaseIDs); errors.Is(err, errOutboxClaimLost) {
+		// Las cargas quedaron en published_cargas; el resultado lo cierra la entrada que la reemplazó
+		p.logger.Warnf("📤 Mensaje %s publicado, pero la entrada %d ya fue reemplazada o tomada por otra instancia", entry.MessageID, entry.ID)
+		return false
+	} else if err != nil {
+		// Las cargas ya están registradas en published_cargas: el próximo intento no las duplica
+		p.logger.Errorf("Error marcando outbox %d como publicado: %v", entry.ID, err)
+		return false
+	}
+
+	p.updateOutboxResult(entry, "success", supabaseIDs, "")
+	p.logger.Infof("📤 Mensaje %s publicado: %d cargas creadas", entry.MessageID, len(supabaseIDs))
+
+	// Actualizar perfil: carga válida publicada (+1 confianza)
+	if entry.RealPhone != "" {
+		if err := p.messageStore.UpdatePhoneProfiling(entry.RealPhone, true); err != nil {
+			p.logger.Warnf("Error actualizando perfil para %s: %v", entry.RealPhone, err)
+		}
+	}
+	return true
+}
+
+// updateOutboxResult pasa el último resultado del mensaje que esperaba publicación al estado final
+func (p *MessageProcessor) updateOutboxResult(entry OutboxEntry, status string, supabaseIDs []string, errorMessage string) {
+	supabaseIDsJSON, _ := json.Marshal(supabaseIDs)
+	_, err := p.messageStore.db.Exec(`
+		UPDATE ai_processing_results
+		SET status = ?, supabase_ids = ?, error_message = NULLIF(?, '')
+		WHERE message_id = ? AND chat_jid = ? AND status IN (?, ?)
+		ORDER BY id DESC
+		LIMIT 1
+	`, status, string(supabaseIDsJSON), errorMessage, entry.MessageID, entry.ChatJID,
+		ProcessingStatusExtracted, ProcessingStatusPublishFailed)
+	if err != nil {
+		p.logger.Warnf("Error actualizando resultado de %s después de publicar: %v", entry.MessageID, err)
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Variables disponibles en los templates del prompt
+const (
+	promptVarFecha     = "{{fecha}}"      // DD/MM/YYYY (Argentina)
+	promptVarFechaHora = "{{fecha_hora}}" // DD/MM/YYYY HH:MM (Argentina)
+	promptVarTelefono  = "{{telefono}}"   // Teléfono real del cliente
+	promptVarMensaje   = "{{mensaje}}"    // Texto del mensaje a procesar
+	promptVarGrupo     = "{{grupo}}"      // Nombre del grupo/chat de WhatsApp
+
+	// Valores válidos de los catálogos de Supabase (una línea - "Valor" por ítem)
+	promptVarMateriales     = "{{materiales}}"
+	promptVarPresentaciones = "{{presentaciones}}"
+	promptVarTiposEquipo    = "{{tipos_equipo}}"
+	promptVarFormasPago     = "{{formas_pago}}"
+)
+
+// promptVariablesHelp lista las variables disponibles (para los mensajes de error)
+const promptVariablesHelp = "{{fecha}}, {{fecha_hora}}, {{telefono}}, {{mensaje}}, {{grupo}}, " +
+	"{{materiales}}, {{presentaciones}}, {{tipos_equipo}}, {{formas_pago}}"
+
+// promptVariablePattern detecta cualquier {{variable}} en un template
+var promptVariablePattern = regexp.MustCompile(`\{\{\s*[a-zA-Z_]+\s*\}\}`)
+
+// defaultUserTemplate es el mensaje "user" por defecto: fecha actual, teléfono y mensaje del cliente
+const defaultUserTemplate = "## FECHA Y HORA ACTUAL (Argentina)\n" +
+	"- Hoy es: {{fecha}}\n" +
+	"- Fecha y hora actual: {{fecha_hora}}\n" +
+	"- Zona horaria: Argentina (UTC-3)\n" +
+	"- IMPORTANTE: Usa esta fecha como referencia para \"hoy\", \"mañana\", etc.\n\n" +
+	"## Información del Cliente\n" +
+	"- Teléfono: {{telefono}}\n\n" +
+	"## Mensaje del Cliente\n" +
+	"{{mensaje}}"
+
+// promptTemplateCacheTTL es cada cuánto se releen de la BD los templates activos
+const promptTemplateCacheTTL = 30 * time.Second
+
+// PromptTemplate es una versión del prompt guardada en prompt_templates
+type PromptTemplate struct {
+	ID             int       `json:"id"`            // 0 = prompt del archivo (no hay templates en la BD)
+	ProviderName   string    `json:"provider_name"` // "" = todos los proveedores
+	Version        int       `json:"version"`
+	SystemTemplate string    `json:"system_template"`
+	UserTemplate   string    `json:"user_template"`
+	Description    string    `json:"description"`
+	IsActive       bool      `json:"is_active"`
+	CreatedAt      time.Time `json:"created_at"`
+
+	// Resultados procesados con esta versión (solo en GetTemplates)
+	Stats *PromptVersionStats `json:"stats,omitempty"`
+}
+
+// PromptVersionStats resume la calidad de los resultados producidos por una versión del prompt
+type PromptVersionStats struct {
+	Total             int     `json:"total"`
+	Success           int     `json:"success"`
+	Errors            int     `json:"errors"`
+	SchemaErrors      int     `json:"schema_errors"` // Rechazados por el esquema de CargaData
+	Cargas            int     `json:"cargas"`
+	AvgRepairAttempts float64 `json:"avg_repair_attempts"`
+}
+
+// Label identifica la versión en ai_processing_results (ej: "v3", "gemini v2")
+func (t *PromptTemplate) Label() string {
+	if t.ID == 0 {
+		return "archivo"
+	}
+	if t.ProviderName != "" {
+		return fmt.Sprintf("%s v%d", t.ProviderName, t.Version)
+	}
+	return fmt.Sprintf("v%d", t.Version)
+}
+
+// PromptMessage son los datos de un mensaje que se reemplazan en las variables del template
+type PromptMessage struct {
+	Content   string // {{mensaje}}
+	RealPhone string // {{telefono}}
+	GroupName string // {{grupo}}
+
+	// Now es la fecha de referencia para {{fecha}} y {{fecha_hora}} (cero = fecha actual en Argentina)
+	Now time.Time
+}
+
+// Render reemplaza las variables del template y devuelve el system prompt y el mensaje "user"
+func (t *PromptTemplate) Render(msg PromptMessage, now time.Time) (string, string) {
+	replacer := strings.NewReplacer(append([]string{
+		promptVarFecha, now.Format("02/01/2006"),
+		promptVarFechaHora, now.Format("02/01/2006 15:04"),
+		promptVarTelefono, msg.RealPhone,
+		promptVarMensaje, msg.Content,
+		promptVarGrupo, msg.GroupName,
+	}, catalogPromptReplacements()...)...)
+	return replacer.Replace(t.SystemTemplate), replacer.Replace(t.UserTemplate)
+}
+
+// validatePromptTemplate verifica que el template solo use variables conocidas e incluya el mensaje
+func validatePromptTemplate(systemTemplate, userTemplate string) error {
+	if strings.TrimSpace(systemTemplate) == "" {
+		return fmt.Errorf("el system prompt no puede estar vacío")
+	}
+
+	known := map[string]bool{
+		promptVarFecha:     true,
+		promptVarFechaHora: true,
+		promptVarTelefono:  true,
+		promptVarMensaje:   true,
+		promptVarGrupo:     true,
+
+		promptVarMateriales:     true,
+		promptVarPresentaciones: true,
+		promptVarTiposEquipo:    true,
+		promptVarFormasPago:     true,
+	}
+	for _, variable := range promptVariablePattern.FindAllString(systemTemplate+userTemplate, -1) {
+		if !known[variable] {
+			return fmt.Errorf("variable desconocida %s (disponibles: %s)", variable, promptVariablesHelp)
+		}
+	}
+
+	if !strings.Contains(systemTemplate+userTemplate, promptVarMensaje) {
+		return fmt.Errorf("el template debe incluir {{mensaje}}")
+	}
+
+	return nil
+}
+
+// PromptTemplateManager resuelve y versiona los templates del prompt guardados en prompt_templates
+type PromptTemplateManager struct {
+	db       *sql.DB
+	mu       sync.RWMutex
+	reloadMu sync.Mutex // Serializa las recargas del archivo (watcher y manual)
+
+	// fallbackPrompt se usa si no hay ninguna versión activa en la BD (contecto_funcionalidad_ia.md)
+	fallbackPrompt string
+
+	// Caché de templates activos por provider_name
+	activeCache map[string]*PromptTemplate
+	cacheTime   time.Time
+}
+
+// NewPromptTemplateManager crea el manager. fallbackPrompt es el system prompt del archivo de contexto.
+func NewPromptTemplateManager(db *sql.DB, fallbackPrompt string) *PromptTemplateManager {
+	return &PromptTemplateManager{
+		db:             db,
+		fallbackPrompt: fallbackPrompt,
+	}
+}
+
+// EnsureDefaultTemplate importa el prompt del archivo como versión 1 si la tabla está vacía
+func (m *PromptTemplateManager) EnsureDefaultTemplate() error {
+	var count int
+	if err := m.db.QueryRow("SELECT COUNT(*) FROM prompt_templates").Scan(&count); err != nil {
+		return fmt.Errorf("failed to count prompt templates: %v", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	m.mu.RLock()
+	fallback := m.fallbackPrompt
+	m.mu.RUnlock()
+
+	_, err := m.CreateVersion("", fallback, defaultUserTemplate, "Importado de "+systemPromptFile, true)
+	return err
+}
+
+// Resolve devuelve el template activo para un proveedor: su override si existe, si no el template por defecto,
+// y si la BD no tiene ninguno activo, el prompt del archivo con el mensaje "user" por defecto.
+func (m *PromptTemplateManager) Resolve(providerName string) *PromptTemplate {
+	m.mu.RLock()
+	cache, fresh := m.activeCache, time.Since(m.cacheTime) < promptTemplateCacheTTL
+	fallback := m.fallbackPrompt
+	m.mu.RUnlock()
+
+	if !fresh || cache == nil {
+		loaded, err := m.loadActive()
+		if err != nil {
+			fmt.Printf("⚠️ Error cargando templates del prompt, usando el archivo: %v\n", err)
+		} else {
+			cache = loaded
+			m.mu.Lock()
+			m.activeCache = loaded
+			m.cacheTime = time.Now()
+			m.mu.Unlock()
+		}
+	}
+
+	if tmpl, ok := cache[providerName]; ok {
+		return tmpl
+	}
+	if tmpl, ok := cache[""]; ok {
+		return tmpl
+	}
+
+	return &PromptTemplate{
+		SystemTemplate: fallback,
+		UserTemplate:   defaultUserTemplate,
+		IsActive:       true,
+	}
+}
+
+// loadActive lee de la BD los templates activos, indexados por provider_name
+func (m *PromptTemplateManager) loadActive() (map[string]*PromptTemplate, error) {
+	rows, err := m.db.Query(`
+		SELECT id, provider_name, version, system_template, user_template, COALESCE(description, ''), is_active, created_at
+		FROM prompt_templates
+		WHERE is_active = 1
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	active := make(map[string]*PromptTemplate)
+	for rows.Next() {
+		var t PromptTemplate
+		if err := rows.Scan(&t.ID, &t.ProviderName, &t.Version, &t.SystemTemplate, &t.UserTemplate,
+			&t.Description, &t.IsActive, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		active[t.ProviderName] = &t
+	}
+
+	return active, nil
+}
+
+// invalidateCache fuerza a releer los templates activos en la próxima llamada
+func (m *PromptTemplateManager) invalidateCache() {
+	m.mu.Lock()
+	m.activeCache = nil
+	m.mu.Unlock()
+}
+
+// GetTemplates devuelve todas las versiones (más nuevas primero) con las estadísticas de sus resultados
+func (m *PromptTemplateManager) GetTemplates() ([]PromptTemplate, error) {
+	rows, err := m.db.Query(`
+		SELECT pt.id, pt.provider_name, pt.version, pt.system_template, pt.user_template,
+		       COALESCE(pt.description, ''), pt.is_active, pt.created_at,
+		       COUNT(apr.id),
+		       COALESCE(SUM(CASE WHEN apr.status = 'success' THEN 1 ELSE 0 END), 0),
+		       COALESCE(SUM(CASE WHEN apr.status = 'error' THEN 1 ELSE 0 END), 0),
+		       COALESCE(SUM(CASE WHEN apr.validation_errors IS NOT NULL THEN 1 ELSE 0 END), 0),
+		       COALESCE(SUM(CASE WHEN apr.status = 'success' AND apr.supabase_ids IS NOT NULL THEN JSON_LENGTH(apr.supabase_ids) ELSE 0 END), 0),
+		       COALESCE(AVG(apr.repair_attempts), 0)
+		FROM prompt_templates pt
+		LEFT JOIN ai_processing_results apr ON apr.prompt_template_id = pt.id
+		GROUP BY pt.id
+		ORDER BY pt.provider_name, pt.version DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query prompt templates: %v", err)
+	}
+	defer rows.Close()
+
+	var templates []PromptTemplate
+	for rows.Next() {
+		var t PromptTemplate
+		var stats PromptVersionStats
+		if err := rows.Scan(&t.ID, &t.ProviderName, &t.Version, &t.SystemTemplate, &t.UserTemplate,
+			&t.Description, &t.IsActive, &t.CreatedAt,
+			&stats.Total, &stats.Success, &stats.Errors, &stats.SchemaErrors, &stats.Cargas, &stats.AvgRepairAttempts); err != nil {
+			return nil, fmt.Errorf("failed to scan prompt template: %v", err)
+		}
+		t.Stats = &stats
+		templates = append(templates, t)
+	}
+
+	return templates, nil
+}
+
+// CreateVersion guarda una nueva versión del template de un proveedor ("" = por defecto).
+// Si activate es true, pasa a ser la versión activa de ese proveedor.
+func (m *PromptTemplateManager) CreateVersion(providerName, systemTemplate, userTemplate, description string, activate bool) (*PromptTemplate, error) {
+	if strings.TrimSpace(userTemplate) == "" {
+		userTemplate = defaultUserTemplate
+	}
+	if err := validatePromptTemplate(systemTemplate, userTemplate); err != nil {
+		return nil, err
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRow("SELECT COALESCE(MAX(version), 0) + 1 FROM prompt_templates WHERE provider_name = ? FOR UPDATE",
+		providerName).Scan(&version); err != nil {
+		return nil, fmt.Errorf("failed to get next prompt version: %v", err)
+	}
+
+	if activate {
+		if _, err := tx.Exec("UPDATE prompt_templates SET is_active = 0 WHERE provider_name = ?", providerName); err != nil {
+			return nil, fmt.Errorf("failed to deactivate prompt templates: %v", err)
+		}
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO prompt_templates (provider_name, version, system_template, user_template, description, is_active)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, providerName, version, systemTemplate, userTemplate, description, activate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert prompt template: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit prompt template: %v", err)
+	}
+
+	id, _ := res.LastInsertId()
+	m.invalidateCache()
+
+	tmpl := &PromptTemplate{
+		ID:             int(id),
+		ProviderName:   providerName,
+		Version:        version,
+		SystemTemplate: systemTemplate,
+		UserTemplate:   userTemplate,
+		Description:    description,
+		IsActive:       activate,
+		CreatedAt:      time.Now(),
+	}
+	fmt.Printf("📝 Prompt %s guardado (activo: %v)\n", tmpl.Label(), activate)
+
+	return tmpl, nil
+}
+
+// ActivateVersion marca una versión como activa para su proveedor (permite volver a una versión anterior)
+func (m *PromptTemplateManager) ActivateVersion(id int) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var providerName string
+	if err := tx.QueryRow("SELECT provider_name FROM prompt_templates WHERE id = ?", id).Scan(&providerName); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("prompt template %d not found", id)
+		}
+		return fmt.Errorf("failed to get prompt template: %v", err)
+	}
+
+	if _, err := tx.Exec("UPDATE prompt_templates SET is_active = (id = ?) WHERE provider_name = ?", id, providerName); err != nil {
+		return fmt.Errorf("failed to activate prompt template: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit prompt activation: %v", err)
+	}
+
+	m.invalidateCache()
+	return nil
+}
+
+// DeactivateOverride desactiva el override de un proveedor para que vuelva a usar el template por defecto
+func (m *PromptTemplateManager) DeactivateOverride(providerName string) error {
+	if providerName == "" {
+		return fmt.Errorf("el template por defecto no se puede desactivar; activa otra versión")
+	}
+
+	if _, err := m.db.Exec("UPDATE prompt_templates SET is_active = 0 WHERE provider_name = ?", providerName); err != nil {
+		return fmt.Errorf("failed to deactivate prompt override: %v", err)
+	}
+
+	m.invalidateCache()
+	return nil
+}
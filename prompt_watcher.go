@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// systemPromptFile es el archivo de contexto con el system prompt
+const systemPromptFile = "contecto_funcionalidad_ia.md"
+
+// promptWatchInterval es cada cuánto se revisa si cambió el archivo del prompt
+const promptWatchInterval = 2 * time.Second
+
+// PromptReloadResult es el resultado de recargar el prompt desde el archivo (se envía a la UI como evento "prompt-reloaded")
+type PromptReloadResult struct {
+	Success    bool      `json:"success"`
+	Changed    bool      `json:"changed"` // false si el archivo no cambió respecto del prompt activo
+	Version    string    `json:"version"` // Versión activa después de recargar (ej: "v4")
+	Source     string    `json:"source"`  // "watcher" o "manual"
+	Error      string    `json:"error,omitempty"`
+	ReloadedAt time.Time `json:"reloaded_at"`
+}
+
+// ReloadFromFile relee el archivo del prompt y, si cambió, lo guarda como nueva versión activa del template por defecto.
+// Los mensajes en curso terminan con la versión que ya tenían; los siguientes usan la nueva.
+// Si el archivo no se puede leer o es inválido, se mantiene el prompt anterior.
+func (m *PromptTemplateManager) ReloadFromFile(path, source string) PromptReloadResult {
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+
+	result := PromptReloadResult{Source: source, ReloadedAt: time.Now()}
+
+	promptBytes, err := os.ReadFile(path)
+	if err != nil {
+		result.Error = fmt.Sprintf("no se pudo leer %s: %v", path, err)
+		return result
+	}
+
+	content := string(promptBytes)
+	if strings.TrimSpace(content) == "" {
+		result.Error = fmt.Sprintf("%s está vacío; se mantiene el prompt anterior", path)
+		return result
+	}
+
+	// Se parte del mensaje "user" de la versión activa para no perder cambios hechos desde la UI
+	current := m.Resolve("")
+	if err := validatePromptTemplate(content, current.UserTemplate); err != nil {
+		result.Error = fmt.Sprintf("prompt inválido: %v", err)
+		return result
+	}
+
+	m.mu.Lock()
+	m.fallbackPrompt = content
+	m.mu.Unlock()
+
+	result.Success = true
+	if current.ID != 0 && current.SystemTemplate == content {
+		result.Version = current.Label()
+		return result
+	}
+
+	tmpl, err := m.CreateVersion("", content, current.UserTemplate, "Recargado de "+path, true)
+	if err != nil {
+		// El archivo igual queda como fallback, pero la versión activa de la BD no cambió
+		result.Success = false
+		result.Error = fmt.Sprintf("no se pudo guardar la nueva versión: %v", err)
+		return result
+	}
+
+	result.Changed = true
+	result.Version = tmpl.Label()
+	return result
+}
+
+// WatchFile revisa periódicamente el archivo del prompt y lo recarga cuando cambia.
+// Espera a que la fecha de modificación se estabilice un ciclo para no leer escrituras a medias.
+// Devuelve una función para detener el watcher.
+func (m *PromptTemplateManager) WatchFile(path string, onReload func(PromptReloadResult)) func() {
+	stop := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(promptWatchInterval)
+		defer ticker.Stop()
+
+		var lastModTime, pendingModTime time.Time
+		if info, err := os.Stat(path); err == nil {
+			lastModTime = info.ModTime()
+		}
+
+		fmt.Printf("👀 Observando cambios en %s\n", path)
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+
+			modTime := info.ModTime()
+			if modTime.Equal(lastModTime) {
+				pendingModTime = time.Time{}
+				continue
+			}
+
+			// Primer ciclo con cambios: esperar al siguiente para confirmar que terminó de escribirse
+			if !modTime.Equal(pendingModTime) {
+				pendingModTime = modTime
+				continue
+			}
+
+			lastModTime = modTime
+			pendingModTime = time.Time{}
+
+			result := m.ReloadFromFile(path, "watcher")
+			if result.Success {
+				fmt.Printf("🔄 Prompt recargado desde %s (versión activa: %s, cambió: %v)\n", path, result.Version, result.Changed)
+			} else {
+				fmt.Printf("⚠️ Error recargando prompt: %s\n", result.Error)
+			}
+			if onReload != nil {
+				onReload(result)
+			}
+		}
+	}()
+
+	return func() { close(stop) }
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// errCargaNotRecorded indica que la carga quedó creada en Supabase pero no en published_cargas.
+// Reintentar la volvería a insertar, así que el mensaje no se reintenta solo (ver "reconcile").
+var errCargaNotRecorded = errors.New("carga publicada en Supabase pero no registrada localmente")
+
+// PublishedCarga es una carga de un mensaje que ya existe en Supabase
+type PublishedCarga struct {
+	MessageID   string     `json:"message_id"`
+	ChatJID     string     `json:"chat_jid"`
+	CargaIndex  int        `json:"carga_index"`
+	SupabaseID  string     `json:"supabase_id"`
+	PayloadHash string     `json:"payload_hash"` // Hash de los datos publicados ("" = registrada antes de guardar el hash)
+	PublishedAt time.Time  `json:"published_at"` // Cuándo se creó en Supabase (no cambia al modificarla)
+	UpdatedAt   *time.Time `json:"updated_at"`   // Última vez que se modificó en Supabase con datos nuevos
+}
+
+// cargaPayloadHash identifica lo que se publicó de una carga (datos y dador), para detectar
+// si un mensaje procesado de nuevo extrajo otra cosa o le corresponde otro dador
+func cargaPayloadHash(carga CargaData, dadorID string) string {
+	data, _ := json.Marshal(struct {
+		CargaData
+		DadorID string `json:"dadorId"`
+	}{carga, dadorID})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// GetPublishedCargas devuelve las cargas ya publicadas de un mensaje, por índice
+func (store *MessageStore) GetPublishedCargas(messageID, chatJID string) (map[int]PublishedCarga, error) {
+	rows, err := store.db.Query(`
+		SELECT carga_index, supabase_id, COALESCE(payload_hash, ''), published_at, updated_at
+		FROM published_cargas
+		WHERE message_id = ? AND chat_jid = ?
+	`, messageID, chatJID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get published cargas: %v", err)
+	}
+	defer rows.Close()
+
+	published := make(map[int]PublishedCarga)
+	for rows.Next() {
+		c := PublishedCarga{MessageID: messageID, ChatJID: chatJID}
+		var updatedAt sql.NullTime
+		if err := rows.Scan(&c.CargaIndex, &c.SupabaseID, &c.PayloadHash, &c.PublishedAt, &updatedAt); err != nil {
+			return nil, err
+		}
+		if updatedAt.Valid {
+			c.UpdatedAt = &updatedAt.Time
+		}
+		published[c.CargaIndex] = c
+	}
+	return published, rows.Err()
+}
+
+// RecordPublishedCarga registra que la carga "index" del mensaje se creó en Supabase con los datos de payloadHash
+func (store *MessageStore) RecordPublishedCarga(messageID, chatJID string, index int, supabaseID, payloadHash string) error {
+	_, err := store.db.Exec(`
+		INSERT INTO published_cargas (message_id, chat_jid, carga_index, supabase_id, payload_hash, published_by)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, messageID, chatJID, index, supabaseID, payloadHash, store.instanceID)
+	return err
+}
+
+// RecordUpdatedCarga registra que la carga "index" del mensaje se modificó en Supabase con los datos de payloadHash.
+// published_at no cambia: sigue siendo la fecha de creación en Supabase (ver "reconcile").
+func (store *MessageStore) RecordUpdatedCarga(messageID, chatJID string, index int, payloadHash string) error {
+	_, err := store.db.Exec(`
+		UPDATE published_cargas
+		SET payload_hash = ?, published_by = ?, updated_at = NOW()
+		WHERE message_id = ? AND chat_jid = ? AND carga_index = ?
+	`, payloadHash, store.instanceID, messageID, chatJID, index)
+	return err
+}
+
+// backfillPayloadHash guarda el hash de una carga registrada antes de que existiera payload_hash
+func (store *MessageStore) backfillPayloadHash(messageID, chatJID string, index int, payloadHash string) error {
+	_, err := store.db.Exec(`
+		UPDATE published_cargas
+		SET payload_hash = ?
+		WHERE message_id = ? AND chat_jid = ? AND carga_index = ? AND payload_hash IS NULL
+	`, payloadHash, messageID, chatJID, index)
+	return err
+}
+
+// publishCarga sube una carga a Supabase a nombre de dadorID; devuelve su ID y si ya estaba publicada con los mismos datos.
+// Si el índice ya estaba publicado con otros datos (el mensaje se volvió a procesar o se editó), se modifica
+// la carga existente en lugar de crear otra.
+func (p *MessageProcessor) publishCarga(messageID, chatJID string, index int, carga CargaData, dadorID string, published map[int]PublishedCarga) (string, bool, error) {
+	payloadHash := cargaPayloadHash(carga, dadorID)
+
+	if existing, ok := published[index]; ok {
+		if existing.PayloadHash == payloadHash {
+			return existing.SupabaseID, true, nil
+		}
+
+		// Registrada antes de guardar el hash: no se sabe qué datos tiene, así que se toma la
+		// extracción actual como la publicada en lugar de modificarla en cada reintento
+		if existing.PayloadHash == "" {
+			if err := p.messageStore.backfillPayloadHash(messageID, chatJID, index, payloadHash); err != nil {
+				p.logger.Warnf("Error guardando el hash de la carga %s: %v", existing.SupabaseID, err)
+			}
+			return existing.SupabaseID, true, nil
+		}
+
+		if err := p.supabaseService.actualizarCarga(existing.SupabaseID, carga, dadorID); err != nil {
+			return "", false, err
+		}
+		p.logger.Infof("✏️ Carga %d del mensaje %s cambió desde la última publicación: actualizada en Supabase (%s)", index+1, messageID, existing.SupabaseID)
+
+		if err := p.messageStore.RecordUpdatedCarga(messageID, chatJID, index, payloadHash); err != nil {
+			// La carga en Supabase ya tiene los datos nuevos: el próximo intento solo la vuelve a modificar
+			p.logger.Warnf("Error registrando los datos publicados de la carga %s: %v", existing.SupabaseID, err)
+		}
+		return existing.SupabaseID, false, nil
+	}
+
+	supabaseID, err := p.supabaseService.crearCarga(carga, dadorID)
+	if err != nil {
+		return "", false, err
+	}
+
+	if err := p.messageStore.RecordPublishedCarga(messageID, chatJID, index, supabaseID, payloadHash); err != nil {
+		p.logger.Errorf("⚠️ Carga %s del mensaje %s (índice %d) creada pero no registrada: %v", supabaseID, messageID, index, err)
+		return supabaseID, false, fmt.Errorf("%w (%s): %v", errCargaNotRecorded, supabaseID, err)
+	}
+	return supabaseID, false, nil
+}
+
+// publishCargas sube las cargas de un mensaje retomando desde la primera que no se publicó.
+// Si falla a mitad de camino devuelve los IDs creados hasta ese momento.
+func (p *MessageProcessor) publishCargas(msg ProcessableMessage, cargas []CargaData) ([]string, error) {
+	published, err := p.messageStore.GetPublishedCargas(msg.ID, msg.ChatJID)
+	if err != nil {
+		return nil, err
+	}
+
+	dadorID, err := p.resolveDadorID(msg.RealPhone, msg.ChatJID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Una extracción nueva con menos cargas no borra las sobrantes: quedan en Supabase para revisarlas a mano
+	if len(published) > len(cargas) {
+		p.logger.Warnf("⚠️ El mensaje %s tenía %d carga(s) publicada(s) y la nueva extracción tiene %d; las sobrantes siguen en Supabase",
+			msg.ID, len(published), len(cargas))
+	}
+
+	var supabaseIDs []string
+	for i, carga := range cargas {
+		supabaseID, existed, err := p.publishCarga(msg.ID, msg.ChatJID, i, carga, dadorID, published)
+		if err != nil {
+			return supabaseIDs, fmt.Errorf("failed to create carga %d: %w", i+1, err)
+		}
+		supabaseIDs = append(supabaseIDs, supabaseID)
+
+		if existed {
+			p.logger.Infof("⏭️ Carga %d del mensaje %s ya publicada (%s), se omite", i+1, msg.ID, supabaseID)
+			continue
+		}
+
+		// Pequeña pausa entre cargas
+		time.Sleep(100 * time.Millisecond)
+	}
+	return supabaseIDs, nil
+}
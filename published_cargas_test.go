@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestCargaPayloadHash(t *testing.T) {
+	base := CargaData{
+		Material:          "Soja",
+		Presentacion:      "Granel",
+		Peso:              "30000",
+		TipoEquipo:        "Batea",
+		LocalidadCarga:    "Pergamino, Buenos Aires, Argentina",
+		LocalidadDescarga: "Rosario, Santa Fe, Argentina",
+		FechaCarga:        "11/03/2025",
+		FechaDescarga:     "12/03/2025",
+		Telefono:          "+5493415551234",
+	}
+
+	tests := []struct {
+		name   string
+		modify func(c *CargaData)
+		dador  string
+		same   bool
+	}{
+		{name: "mismos datos", modify: func(*CargaData) {}, same: true},
+		{name: "otro peso", modify: func(c *CargaData) { c.Peso = "28000" }},
+		{name: "otra fecha de descarga", modify: func(c *CargaData) { c.FechaDescarga = "13/03/2025" }},
+		{name: "otro destino", modify: func(c *CargaData) { c.LocalidadDescarga = "Santa Fe, Santa Fe, Argentina" }},
+		{name: "precio agregado en revisión", modify: func(c *CargaData) { c.Precio = "1800000" }},
+		{name: "otro dador", modify: func(*CargaData) {}, dador: "b5c1f0a2-7d3e-4c8a-9f61-2e4d8a0b7c13"},
+	}
+
+	want := cargaPayloadHash(base, dadorIDPorDefecto)
+	if len(want) != 64 {
+		t.Fatalf("el hash debería tener 64 caracteres hex (payload_hash CHAR(64)), tiene %d", len(want))
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			carga := base
+			tt.modify(&carga)
+			dador := dadorIDPorDefecto
+			if tt.dador != "" {
+				dador = tt.dador
+			}
+			if got := cargaPayloadHash(carga, dador); (got == want) != tt.same {
+				t.Errorf("cargaPayloadHash igual = %v, se esperaba %v", got == want, tt.same)
+			}
+		})
+	}
+}
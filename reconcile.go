@@ -0,0 +1,260 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ReconcileReport compara las cargas de los dadores en Supabase con las registradas localmente
+type ReconcileReport struct {
+	Since          string             `json:"since,omitempty"`
+	Dadores        []string           `json:"dadores"` // Dador por defecto y dadores asignados
+	SupabaseCargas int                `json:"supabase_cargas"`
+	LocalCargas    int                `json:"local_cargas"`
+	Orphans        []SupabaseCargaRef `json:"orphans"` // En Supabase sin registro local (posibles duplicados)
+	Missing        []PublishedCarga   `json:"missing"` // Registradas localmente pero ya no están en Supabase
+}
+
+// runReconcileCommand ejecuta el subcomando "reconcile" y devuelve el código de salida
+// (1 si hay huérfanas, para poder usarlo desde cron).
+//
+//	loader-meow reconcile [-days 30] [-json reporte.json]
+func runReconcileCommand(args []string) int {
+	fs := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	days := fs.Int("days", 30, "Revisa las cargas creadas en los últimos N días (0 = todas)")
+	jsonPath := fs.String("json", "", "Escribe el reporte en formato JSON en este archivo")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	messageStore, err := NewMessageStore()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error inicializando la base de datos: %v\n", err)
+		return 1
+	}
+	defer messageStore.Close()
+
+	if err := messageStore.InitAIConfigTables(); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error inicializando la configuración: %v\n", err)
+		return 1
+	}
+
+	var since time.Time
+	if *days > 0 {
+		since = time.Now().AddDate(0, 0, -*days)
+	}
+
+	systemConfigManager := NewSystemConfigManager(messageStore.db)
+	dadorIDs, err := reconcileDadorIDs(messageStore, systemConfigManager)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error obteniendo dadores: %v\n", err)
+		return 1
+	}
+
+	supabaseService := NewSupabaseService(systemConfigManager)
+	report, err := reconcileCargas(messageStore, supabaseService, dadorIDs, since)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error conciliando cargas: %v\n", err)
+		return 1
+	}
+
+	printReconcileReport(report)
+
+	if *jsonPath != "" {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		if err := os.WriteFile(*jsonPath, data, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Error escribiendo reporte JSON: %v\n", err)
+			return 1
+		}
+		fmt.Printf("💾 Reporte JSON escrito en %s\n", *jsonPath)
+	}
+
+	if len(report.Orphans) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// reconcileRemoteMargin amplía la ventana de Supabase hacia atrás: published_at se registra después de
+// crear la carga (y con el reloj de MySQL), así que una carga registrada justo después de "since" puede
+// tener created_at anterior en Supabase. Sin el margen aparecería como faltante.
+const reconcileRemoteMargin = time.Hour
+
+// reconcileDadorIDs devuelve los dadores a los que la app publica: el por defecto y los asignados
+func reconcileDadorIDs(store *MessageStore, systemConfigManager *SystemConfigManager) ([]string, error) {
+	mapped, err := store.GetMappedDadorIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	dadorIDs := []string{systemConfigManager.GetDefaultDadorID()}
+	for _, id := range mapped {
+		if id != dadorIDs[0] {
+			dadorIDs = append(dadorIDs, id)
+		}
+	}
+	return dadorIDs, nil
+}
+
+// reconcileCargas busca cargas de los dadores en Supabase que no estén registradas localmente
+// (p. ej. se insertaron pero el proceso se cortó antes de guardar published_cargas).
+// Las dos ventanas usan la fecha de creación: created_at en Supabase y published_at local, que no
+// cambia cuando la carga se modifica.
+func reconcileCargas(store *MessageStore, supabaseService *SupabaseService, dadorIDs []string, since time.Time) (*ReconcileReport, error) {
+	remoteSince := since
+	if !since.IsZero() {
+		remoteSince = since.Add(-reconcileRemoteMargin)
+	}
+
+	var remote []SupabaseCargaRef
+	for _, dadorID := range dadorIDs {
+		cargas, err := supabaseService.listarCargasDador(dadorID, remoteSince)
+		if err != nil {
+			return nil, fmt.Errorf("dador %s: %v", dadorID, err)
+		}
+		remote = append(remote, cargas...)
+	}
+
+	known, err := store.getKnownSupabaseCargaIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	published, err := store.getPublishedCargasSince(since)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReconcileReport{
+		Dadores:        dadorIDs,
+		SupabaseCargas: len(remote),
+		LocalCargas:    len(published),
+		Orphans:        []SupabaseCargaRef{},
+		Missing:        []PublishedCarga{},
+	}
+	if !since.IsZero() {
+		report.Since = since.Format(time.RFC3339)
+	}
+
+	remoteIDs := make(map[string]bool, len(remote))
+	for _, carga := range remote {
+		remoteIDs[carga.ID] = true
+		if !known[carga.ID] {
+			report.Orphans = append(report.Orphans, carga)
+		}
+	}
+	for _, carga := range published {
+		if !remoteIDs[carga.SupabaseID] {
+			report.Missing = append(report.Missing, carga)
+		}
+	}
+	return report, nil
+}
+
+// getKnownSupabaseCargaIDs devuelve todos los IDs de Supabase que la app sabe que creó:
+// published_cargas, la cola de revisión y los resultados anteriores a published_cargas
+func (store *MessageStore) getKnownSupabaseCargaIDs() (map[string]bool, error) {
+	known := make(map[string]bool)
+
+	rows, err := store.db.Query(`
+		SELECT supabase_id FROM published_cargas
+		UNION
+		SELECT supabase_id FROM pending_cargas WHERE supabase_id IS NOT NULL
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get published cargas: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		known[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	resultRows, err := store.db.Query(`
+		SELECT supabase_ids FROM ai_processing_results
+		WHERE supabase_ids IS NOT NULL AND supabase_ids != '[]'
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get processing results: %v", err)
+	}
+	defer resultRows.Close()
+
+	for resultRows.Next() {
+		var raw string
+		if err := resultRows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		var ids []string
+		json.Unmarshal([]byte(raw), &ids)
+		for _, id := range ids {
+			known[id] = true
+		}
+	}
+	return known, resultRows.Err()
+}
+
+// getPublishedCargasSince devuelve las cargas registradas en published_cargas desde "since" (cero = todas)
+func (store *MessageStore) getPublishedCargasSince(since time.Time) ([]PublishedCarga, error) {
+	rows, err := store.db.Query(`
+		SELECT message_id, chat_jid, carga_index, supabase_id, COALESCE(payload_hash, ''), published_at, updated_at
+		FROM published_cargas
+		WHERE published_at >= ?
+		ORDER BY published_at
+	`, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get published cargas: %v", err)
+	}
+	defer rows.Close()
+
+	var cargas []PublishedCarga
+	for rows.Next() {
+		var c PublishedCarga
+		var updatedAt sql.NullTime
+		if err := rows.Scan(&c.MessageID, &c.ChatJID, &c.CargaIndex, &c.SupabaseID, &c.PayloadHash, &c.PublishedAt, &updatedAt); err != nil {
+			return nil, err
+		}
+		if updatedAt.Valid {
+			c.UpdatedAt = &updatedAt.Time
+		}
+		cargas = append(cargas, c)
+	}
+	return cargas, rows.Err()
+}
+
+func printReconcileReport(report *ReconcileReport) {
+	fmt.Println()
+	fmt.Println("🔎 Conciliación de cargas con Supabase")
+	if report.Since != "" {
+		fmt.Printf("   Desde:        %s\n", report.Since)
+	}
+	fmt.Printf("   Dadores:      %d\n", len(report.Dadores))
+	fmt.Printf("   En Supabase:  %d\n", report.SupabaseCargas)
+	fmt.Printf("   Registradas:  %d\n", report.LocalCargas)
+
+	if len(report.Orphans) == 0 {
+		fmt.Println("✅ Sin cargas huérfanas")
+	} else {
+		fmt.Printf("⚠️ %d carga(s) en Supabase sin registro local (posibles duplicados):\n", len(report.Orphans))
+		for _, carga := range report.Orphans {
+			fmt.Printf("   - %s (creada %s)\n", carga.ID, carga.CreatedAt)
+		}
+	}
+
+	if len(report.Missing) > 0 {
+		fmt.Printf("⚠️ %d carga(s) registradas que ya no están en Supabase:\n", len(report.Missing))
+		for _, carga := range report.Missing {
+			fmt.Printf("   - %s (mensaje %s, carga %d)\n", carga.SupabaseID, carga.MessageID, carga.CargaIndex+1)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Clases de error de procesamiento (messages.error_class)
+const (
+	ErrorClassTransient = "transient" // Rate limit, red, 5xx: se reintenta con backoff
+	ErrorClassPermanent = "permanent" // Ubicaciones inválidas, contenido vacío, respuesta inválida: pasa directo a fallido
+)
+
+// maxProcessingAttempts es la cantidad de intentos antes de marcar un mensaje como fallido
+const maxProcessingAttempts = 3
+
+// Backoff exponencial entre reintentos: retryBaseDelay, 2x, 4x... hasta retryMaxDelay (con jitter de ±20%)
+const (
+	retryBaseDelay = 5 * time.Minute
+	retryMaxDelay  = 2 * time.Hour
+)
+
+// retryBackoff calcula cuánto esperar antes del siguiente intento (attempts = intentos fallidos hasta ahora)
+func retryBackoff(attempts int) time.Duration {
+	return backoffDelay(attempts, retryBaseDelay, retryMaxDelay)
+}
+
+// backoffDelay duplica baseDelay por cada intento fallido hasta maxDelay, con jitter de ±20%
+func backoffDelay(attempts int, baseDelay, maxDelay time.Duration) time.Duration {
+	delay := baseDelay
+	for i := 1; i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	// Jitter: evita que los mensajes que fallaron juntos (ej: un corte de red) se reintenten todos a la vez
+	jitter := 0.8 + rand.Float64()*0.4
+	return time.Duration(float64(delay) * jitter)
+}
+
+// networkErrorIndicators son fragmentos de errores de red/timeout (siempre transitorios)
+var networkErrorIndicators = []string{
+	"timeout",
+	"deadline exceeded",
+	"connection refused",
+	"connection reset",
+	"no such host",
+	"broken pipe",
+	"eof",
+	"tls handshake",
+	"failed to send request",
+	"failed to call",
+}
+
+// isNetworkError verifica si un error es de red (sin respuesta del servidor)
+func isNetworkError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	errStr := strings.ToLower(err.Error())
+	for _, indicator := range networkErrorIndicators {
+		if strings.Contains(errStr, indicator) {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyAIError clasifica un error de la llamada a la IA.
+// Solo los rechazos del proveedor para este mensaje (400/404/413/422) son permanentes; el resto
+// (rate limit, red, 5xx, key inválida o sin config activa) se arregla esperando o desde la configuración.
+func classifyAIError(err error) string {
+	if isRateLimitError(err) || isNetworkError(err) {
+		return ErrorClassTransient
+	}
+
+	var apiErr *ProviderAPIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusBadRequest, http.StatusNotFound, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+			return ErrorClassPermanent
+		}
+	}
+
+	return ErrorClassTransient
+}
+
+// supabaseStatusPattern extrae el código HTTP de los errores de SupabaseService (ej: "failed to insert carga: 503 - ...")
+var supabaseStatusPattern = regexp.MustCompile(`: (\d{3})(?: - |$)`)
+
+// classifySupabaseError clasifica un error al subir las cargas a Supabase.
+// 5xx, 429 y errores de red son transitorios; otros 4xx y direcciones sin resultados de geocoding son permanentes.
+func classifySupabaseError(err error) string {
+	errStr := strings.ToLower(err.Error())
+
+	if strings.Contains(errStr, "no geocoding results") || strings.Contains(errStr, "zero_results") {
+		return ErrorClassPermanent
+	}
+
+	if match := supabaseStatusPattern.FindStringSubmatch(err.Error()); match != nil {
+		status, _ := strconv.Atoi(match[1])
+		switch {
+		case status >= 500, status == http.StatusTooManyRequests, status == http.StatusRequestTimeout:
+			return ErrorClassTransient
+		case status >= 400:
+			return ErrorClassPermanent
+		}
+	}
+
+	return ErrorClassTransient
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		base     time.Duration
+	}{
+		{attempts: 1, base: 5 * time.Minute},
+		{attempts: 2, base: 10 * time.Minute},
+		{attempts: 3, base: 20 * time.Minute},
+		{attempts: 5, base: 80 * time.Minute},
+		{attempts: 6, base: 2 * time.Hour},
+		{attempts: 50, base: 2 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			got := retryBackoff(tt.attempts)
+			low, high := time.Duration(float64(tt.base)*0.8), time.Duration(float64(tt.base)*1.2)
+			if got < low || got > high {
+				t.Fatalf("retryBackoff(%d) = %s, se esperaba entre %s y %s", tt.attempts, got, low, high)
+			}
+		}
+	}
+}
+
+func TestClassifyAIError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "rate limit", err: &ProviderAPIError{Provider: "gemini", StatusCode: 429, Body: "quota exceeded"}, want: ErrorClassTransient},
+		{name: "5xx", err: &ProviderAPIError{Provider: "openai", StatusCode: 502, Body: "bad gateway"}, want: ErrorClassTransient},
+		{name: "key inválida", err: &ProviderAPIError{Provider: "openai", StatusCode: 401, Body: "invalid api key"}, want: ErrorClassTransient},
+		{name: "request rechazado", err: &ProviderAPIError{Provider: "openai", StatusCode: 400, Body: "invalid request"}, want: ErrorClassPermanent},
+		{name: "modelo inexistente", err: &ProviderAPIError{Provider: "ollama", StatusCode: 404, Body: "model not found"}, want: ErrorClassPermanent},
+		{name: "mensaje demasiado grande", err: &ProviderAPIError{Provider: "gemini", StatusCode: 413, Body: "payload too large"}, want: ErrorClassPermanent},
+		{name: "timeout", err: fmt.Errorf("failed to send request: %w", context.DeadlineExceeded), want: ErrorClassTransient},
+		{name: "sin config activa", err: errors.New("no hay configuración de IA activa"), want: ErrorClassTransient},
+		{name: "envuelto", err: fmt.Errorf("AI processing failed: %w", &ProviderAPIError{Provider: "qwen", StatusCode: 422, Body: "invalid"}), want: ErrorClassPermanent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyAIError(tt.err); got != tt.want {
+				t.Errorf("classifyAIError(%v) = %s, se esperaba %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifySupabaseError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "5xx", err: errors.New("failed to insert carga: 503 - service unavailable"), want: ErrorClassTransient},
+		{name: "429", err: errors.New("failed to insert carga: 429 - too many requests"), want: ErrorClassTransient},
+		{name: "408", err: errors.New("failed to insert carga: 408"), want: ErrorClassTransient},
+		{name: "4xx", err: errors.New("failed to insert carga: 400 - invalid input syntax for type uuid"), want: ErrorClassPermanent},
+		{name: "geocoding sin resultados", err: errors.New("failed to create ubicacion for 'X': no geocoding results from Nominatim"), want: ErrorClassPermanent},
+		{name: "google ZERO_RESULTS", err: errors.New("geocoding failed: ZERO_RESULTS"), want: ErrorClassPermanent},
+		{name: "red", err: errors.New("failed to call Supabase: connection refused"), want: ErrorClassTransient},
+		{name: "número que no es un status", err: errors.New("carga 1234 duplicada"), want: ErrorClassTransient},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifySupabaseError(tt.err); got != tt.want {
+				t.Errorf("classifySupabaseError(%v) = %s, se esperaba %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepairCallErrorClassifiedByCause(t *testing.T) {
+	validationErr := errors.New("Schema validation failed: carga 1, peso: campo obligatorio")
+
+	var err error = &repairCallError{validationErr: validationErr, cause: errors.New("no se puede reparar ahora (límite RPM alcanzado)")}
+	var callErr *repairCallError
+	if !errors.As(err, &callErr) {
+		t.Fatalf("errors.As no encontró el repairCallError")
+	}
+	if got := classifyAIError(callErr.cause); got != ErrorClassTransient {
+		t.Errorf("sin presupuesto para reparar: %s, se esperaba %s", got, ErrorClassTransient)
+	}
+
+	var apiErr *ProviderAPIError
+	err = &repairCallError{validationErr: validationErr, cause: &ProviderAPIError{Provider: "openai", StatusCode: 400, Body: "bad request"}}
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 400 {
+		t.Errorf("Unwrap debería exponer el ProviderAPIError de la llamada de reparación")
+	}
+}
@@ -0,0 +1,536 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Estados de una carga en pending_cargas
+const (
+	PendingCargaNeedsReview = "needs_review" // Esperando revisión del operador
+	PendingCargaPublishing  = "publishing"   // Aprobada, subiéndose a Supabase
+	PendingCargaApproved    = "approved"     // Publicada en Supabase
+	PendingCargaRejected    = "rejected"     // Descartada por el operador
+	PendingCargaSuperseded  = "superseded"   // El mensaje se volvió a procesar antes de revisarla
+)
+
+// Reglas de revisión por grupo (review_group_rules.mode)
+const (
+	ReviewGroupAlways = "always" // Siempre revisar, aunque el remitente sea de confianza
+	ReviewGroupNever  = "never"  // Nunca revisar, aunque la revisión esté activada
+)
+
+// defaultReviewTrustThreshold es la confianza mínima (phone_associations.confianza) para publicar sin revisión
+const defaultReviewTrustThreshold = 3
+
+// PendingCarga es una carga extraída por la IA que espera revisión antes de publicarse en Supabase
+type PendingCarga struct {
+	ID            int        `json:"id"`
+	MessageID     string     `json:"message_id"`
+	ChatJID       string     `json:"chat_jid"`
+	ChatName      string     `json:"chat_name"`
+	SenderPhone   string     `json:"sender_phone"`
+	RealPhone     string     `json:"real_phone"`
+	Content       string     `json:"content"` // Mensaje original
+	CargaIndex    int        `json:"carga_index"`
+	Carga         CargaData  `json:"carga"`          // Carga con las correcciones del operador
+	OriginalCarga CargaData  `json:"original_carga"` // Carga tal como la extrajo la IA
+	Edited        bool       `json:"edited"`
+	Status        string     `json:"status"`
+	ReviewReason  string     `json:"review_reason"` // Por qué necesitó revisión
+	RejectReason  string     `json:"reject_reason"`
+	SupabaseID    string     `json:"supabase_id"`
+	PublishError  string     `json:"publish_error"` // Último error al publicar (la carga sigue en revisión)
+	CreatedAt     time.Time  `json:"created_at"`
+	ReviewedAt    *time.Time `json:"reviewed_at"`
+}
+
+// ReviewGroupRule fuerza u omite la revisión para un grupo
+type ReviewGroupRule struct {
+	ChatJID  string `json:"chat_jid"`
+	ChatName string `json:"chat_name"`
+	Mode     string `json:"mode"` // always / never
+}
+
+// ReviewSettings es la configuración del modo revisión
+type ReviewSettings struct {
+	Enabled        bool              `json:"enabled"`
+	TrustThreshold int               `json:"trust_threshold"` // Remitentes con esta confianza o más publican sin revisión
+	GroupRules     []ReviewGroupRule `json:"group_rules"`
+}
+
+// reviewReason decide si las cargas de un mensaje pasan por revisión y devuelve el motivo ("" = publicar directo).
+// Un mapeo de catálogo dudoso siempre se revisa. Después manda la regla del grupo; si no hay, se revisa
+// cuando la revisión está activada y el remitente no alcanza la confianza mínima.
+func (p *MessageProcessor) reviewReason(msg ProcessableMessage, matches []CatalogMatch) (string, error) {
+	if doubtful := doubtfulCatalogMatches(matches); doubtful != "" {
+		reason := []rune("mapeo dudoso de catálogo: " + doubtful)
+		if len(reason) > 500 { // pending_cargas.review_reason es VARCHAR(500)
+			reason = append(reason[:497], []rune("...")...)
+		}
+		return string(reason), nil
+	}
+
+	mode, err := p.messageStore.GetReviewGroupMode(msg.ChatJID)
+	if err != nil {
+		return "", err
+	}
+	switch mode {
+	case ReviewGroupAlways:
+		return "el grupo requiere revisión", nil
+	case ReviewGroupNever:
+		return "", nil
+	}
+
+	if !p.systemConfigManager.GetReviewEnabled() {
+		return "", nil
+	}
+
+	threshold := p.systemConfigManager.GetReviewTrustThreshold()
+	confianza, err := p.messageStore.GetPhoneTrust(msg.RealPhone)
+	if err != nil {
+		return "", err
+	}
+	if confianza >= threshold {
+		return "", nil
+	}
+	return fmt.Sprintf("confianza del remitente %d (mínimo %d)", confianza, threshold), nil
+}
+
+// GetReviewGroupMode obtiene la regla de revisión de un grupo ("" si no tiene)
+func (store *MessageStore) GetReviewGroupMode(chatJID string) (string, error) {
+	var mode string
+	err := store.db.QueryRow(`SELECT mode FROM review_group_rules WHERE chat_jid = ?`, chatJID).Scan(&mode)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return mode, err
+}
+
+// GetPhoneTrust obtiene la confianza de un teléfono real (0 si no tiene asociación)
+func (store *MessageStore) GetPhoneTrust(realPhone string) (int, error) {
+	var confianza int
+	err := store.db.QueryRow(
+		`SELECT COALESCE(MAX(confianza), 0) FROM phone_associations WHERE real_phone = ?`,
+		realPhone,
+	).Scan(&confianza)
+	return confianza, err
+}
+
+// GetReviewGroupRules obtiene las reglas de revisión por grupo
+func (store *MessageStore) GetReviewGroupRules() ([]ReviewGroupRule, error) {
+	rows, err := store.db.Query(`
+		SELECT r.chat_jid, COALESCE(c.name, ''), r.mode
+		FROM review_group_rules r
+		LEFT JOIN chats c ON c.jid = r.chat_jid
+		ORDER BY c.name ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []ReviewGroupRule{}
+	for rows.Next() {
+		var rule ReviewGroupRule
+		if err := rows.Scan(&rule.ChatJID, &rule.ChatName, &rule.Mode); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// SetReviewGroupRule guarda la regla de revisión de un grupo (mode = "" la elimina)
+func (store *MessageStore) SetReviewGroupRule(chatJID, mode string) error {
+	if mode == "" {
+		_, err := store.db.Exec(`DELETE FROM review_group_rules WHERE chat_jid = ?`, chatJID)
+		return err
+	}
+	if mode != ReviewGroupAlways && mode != ReviewGroupNever {
+		return fmt.Errorf("modo de revisión inválido: %s", mode)
+	}
+
+	_, err := store.db.Exec(`
+		INSERT INTO review_group_rules (chat_jid, mode)
+		VALUES (?, ?)
+		ON DUPLICATE KEY UPDATE mode = VALUES(mode)
+	`, chatJID, mode)
+	return err
+}
+
+// AddPendingCargas deja las cargas de un mensaje en revisión. Las que seguían en revisión
+// de un procesamiento anterior del mismo mensaje quedan reemplazadas (superseded).
+func (store *MessageStore) AddPendingCargas(msg ProcessableMessage, cargas []CargaData, reason string) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE pending_cargas
+		SET status = ?
+		WHERE message_id = ? AND chat_jid = ? AND status = ?
+	`, PendingCargaSuperseded, msg.ID, msg.ChatJID, PendingCargaNeedsReview)
+	if err != nil {
+		return fmt.Errorf("failed to supersede pending cargas: %v", err)
+	}
+
+	batchID := fmt.Sprintf("%s:%d", store.instanceID, time.Now().UnixNano())
+	for i, carga := range cargas {
+		cargaJSON, err := json.Marshal(carga)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO pending_cargas
+				(message_id, chat_jid, batch_id, carga_index, carga_json, original_json, status, review_reason)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`, msg.ID, msg.ChatJID, batchID, i, string(cargaJSON), string(cargaJSON), PendingCargaNeedsReview, reason)
+		if err != nil {
+			return fmt.Errorf("failed to insert pending carga: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// pendingCargaSelectQuery es la base de las consultas que devuelven PendingCarga (usar con scanPendingCarga)
+const pendingCargaSelectQuery = `
+		SELECT pc.id, pc.message_id, pc.chat_jid, COALESCE(c.name, ''), COALESCE(m.sender_phone, ''),
+		       COALESCE(pa.real_phone, ''), COALESCE(m.content, ''), pc.carga_index, pc.carga_json, pc.original_json,
+		       pc.status, COALESCE(pc.review_reason, ''), COALESCE(pc.reject_reason, ''), COALESCE(pc.supabase_id, ''),
+		       COALESCE(pc.publish_error, ''), pc.created_at, pc.reviewed_at
+		FROM pending_cargas pc
+		LEFT JOIN messages m ON m.id = pc.message_id AND m.chat_jid = pc.chat_jid
+		LEFT JOIN phone_associations pa ON pa.sender_phone = m.sender_phone
+		LEFT JOIN chats c ON c.jid = pc.chat_jid
+`
+
+// scanPendingCarga lee una fila de pendingCargaSelectQuery
+func scanPendingCarga(row rowScanner) (*PendingCarga, error) {
+	var pc PendingCarga
+	var cargaJSON, originalJSON string
+	var reviewedAt sql.NullTime
+
+	err := row.Scan(&pc.ID, &pc.MessageID, &pc.ChatJID, &pc.ChatName, &pc.SenderPhone,
+		&pc.RealPhone, &pc.Content, &pc.CargaIndex, &cargaJSON, &originalJSON,
+		&pc.Status, &pc.ReviewReason, &pc.RejectReason, &pc.SupabaseID,
+		&pc.PublishError, &pc.CreatedAt, &reviewedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(cargaJSON), &pc.Carga); err != nil {
+		return nil, fmt.Errorf("carga %d inválida: %v", pc.ID, err)
+	}
+	json.Unmarshal([]byte(originalJSON), &pc.OriginalCarga)
+	pc.Edited = cargaJSON != originalJSON
+	if reviewedAt.Valid {
+		t := reviewedAt.Time
+		pc.ReviewedAt = &t
+	}
+	return &pc, nil
+}
+
+// GetPendingCargas obtiene las cargas de la cola de revisión con un estado (las más viejas primero)
+func (store *MessageStore) GetPendingCargas(status string, limit int) ([]PendingCarga, error) {
+	order := "ASC"
+	if status != PendingCargaNeedsReview {
+		order = "DESC" // Historial: las revisadas más recientes primero
+	}
+
+	rows, err := store.db.Query(pendingCargaSelectQuery+`
+		WHERE pc.status = ?
+		ORDER BY pc.created_at `+order+`, pc.carga_index ASC
+		LIMIT ?
+	`, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending cargas: %v", err)
+	}
+	defer rows.Close()
+
+	cargas := []PendingCarga{}
+	for rows.Next() {
+		pc, err := scanPendingCarga(rows)
+		if err != nil {
+			return nil, err
+		}
+		cargas = append(cargas, *pc)
+	}
+	return cargas, rows.Err()
+}
+
+// GetPendingCarga obtiene una carga de la cola de revisión
+func (store *MessageStore) GetPendingCarga(id int) (*PendingCarga, error) {
+	pc, err := scanPendingCarga(store.db.QueryRow(pendingCargaSelectQuery+` WHERE pc.id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("carga en revisión %d no encontrada", id)
+	}
+	return pc, err
+}
+
+// GetPendingCargaCount cuenta las cargas que esperan revisión
+func (store *MessageStore) GetPendingCargaCount() (int, error) {
+	var count int
+	err := store.db.QueryRow(`SELECT COUNT(*) FROM pending_cargas WHERE status = ?`, PendingCargaNeedsReview).Scan(&count)
+	return count, err
+}
+
+// UpdatePendingCarga guarda las correcciones del operador (la carga sigue en revisión)
+func (store *MessageStore) UpdatePendingCarga(id int, carga CargaData) error {
+	cargaJSON, err := json.Marshal(carga)
+	if err != nil {
+		return err
+	}
+
+	// Misma validación de esquema que la respuesta de la IA
+	if fieldErrors := ValidateCargasSchema([]byte("[" + string(cargaJSON) + "]")); len(fieldErrors) > 0 {
+		return fmt.Errorf("carga inválida: %s", FormatCargaFieldErrors(fieldErrors))
+	}
+
+	res, err := store.db.Exec(`
+		UPDATE pending_cargas
+		SET carga_json = ?
+		WHERE id = ? AND status = ?
+	`, string(cargaJSON), id, PendingCargaNeedsReview)
+	if err != nil {
+		return fmt.Errorf("failed to update pending carga: %v", err)
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		if pc, err := store.GetPendingCarga(id); err == nil && pc.Status == PendingCargaNeedsReview {
+			return nil // Sin cambios
+		}
+		return fmt.Errorf("la carga ya no está en revisión")
+	}
+	return nil
+}
+
+// reviewPublishLease es cuánto puede quedar una carga aprobada en "publishing" antes de darla por interrumpida
+// (la app se cerró a mitad de la publicación). Supera con margen el timeout de Supabase y del geocoding.
+const reviewPublishLease = 5 * time.Minute
+
+// claimPendingCargaForPublishing pasa una carga de revisión a "publishing" solo si sigue en revisión
+// (evita que dos operadores o instancias publiquen la misma carga). La lease permite recuperarla si el proceso muere.
+func (store *MessageStore) claimPendingCargaForPublishing(id int) error {
+	res, err := store.db.Exec(`
+		UPDATE pending_cargas
+		SET status = ?, publishing_until = NOW() + INTERVAL ? SECOND
+		WHERE id = ? AND status = ?
+	`, PendingCargaPublishing, int(reviewPublishLease.Seconds()), id, PendingCargaNeedsReview)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return fmt.Errorf("la carga ya no está en revisión")
+	}
+	return nil
+}
+
+// ApprovePendingCarga publica en Supabase una carga de la cola de revisión y devuelve su ID
+func (p *MessageProcessor) ApprovePendingCarga(id int) (string, error) {
+	pc, err := p.messageStore.GetPendingCarga(id)
+	if err != nil {
+		return "", err
+	}
+	if err := p.messageStore.claimPendingCargaForPublishing(id); err != nil {
+		return "", err
+	}
+
+	p.logger.Infof("👀 Carga en revisión %d aprobada, subiendo a Supabase (mensaje %s)", id, pc.MessageID)
+	// Si un intento anterior ya la publicó se reutiliza su ID (o se modifica, si cambió) en lugar de duplicarla
+	var supabaseID, dadorID string
+	published, err := p.messageStore.GetPublishedCargas(pc.MessageID, pc.ChatJID)
+	if err == nil {
+		dadorID, err = p.resolveDadorID(pc.RealPhone, pc.ChatJID)
+	}
+	if err == nil {
+		supabaseID, _, err = p.publishCarga(pc.MessageID, pc.ChatJID, pc.CargaIndex, pc.Carga, dadorID, published)
+		if errors.Is(err, errCargaNotRecorded) {
+			err = nil // La carga existe en Supabase igual: se aprueba y queda su ID en pending_cargas
+		}
+	}
+	if err != nil {
+		// Vuelve a revisión con el error, para corregirla o reintentar
+		if _, dbErr := p.messageStore.db.Exec(`
+			UPDATE pending_cargas SET status = ?, publish_error = ?, publishing_until = NULL WHERE id = ?
+		`, PendingCargaNeedsReview, err.Error(), id); dbErr != nil {
+			p.logger.Errorf("Error devolviendo carga %d a revisión: %v", id, dbErr)
+		}
+		return "", fmt.Errorf("Supabase upload failed: %v", err)
+	}
+
+	_, err = p.messageStore.db.Exec(`
+		UPDATE pending_cargas
+		SET status = ?, supabase_id = ?, publish_error = NULL, publishing_until = NULL, reviewed_at = NOW()
+		WHERE id = ?
+	`, PendingCargaApproved, supabaseID, id)
+	if err != nil {
+		return supabaseID, fmt.Errorf("carga publicada (%s) pero no se pudo registrar: %v", supabaseID, err)
+	}
+
+	p.finishReview(pc.MessageID, pc.ChatJID, pc.RealPhone, id)
+	return supabaseID, nil
+}
+
+// RecoverInterruptedReviews resuelve las cargas que quedaron en "publishing" con la lease vencida
+// (el proceso murió a mitad de ApprovePendingCarga). Si la carga se registró (o modificó) en published_cargas
+// durante esa aprobación se aprueba con ese ID; si no, vuelve a revisión para que el operador la apruebe de nuevo.
+// Devuelve cuántas cargas se recuperaron.
+func (p *MessageProcessor) RecoverInterruptedReviews() (int, error) {
+	rows, err := p.messageStore.db.Query(`
+		SELECT pc.id, pc.message_id, pc.chat_jid, pc.carga_index, COALESCE(pa.real_phone, ''), COALESCE(pub.supabase_id, '')
+		FROM pending_cargas pc
+		LEFT JOIN published_cargas pub ON pub.message_id = pc.message_id AND pub.chat_jid = pc.chat_jid
+		     AND pub.carga_index = pc.carga_index
+		     AND (pc.publishing_until IS NULL OR COALESCE(pub.updated_at, pub.published_at) >= pc.publishing_until - INTERVAL ? SECOND)
+		LEFT JOIN messages m ON m.id = pc.message_id AND m.chat_jid = pc.chat_jid
+		LEFT JOIN phone_associations pa ON pa.sender_phone = m.sender_phone
+		WHERE pc.status = ? AND (pc.publishing_until IS NULL OR pc.publishing_until < NOW())
+	`, int(reviewPublishLease.Seconds()), PendingCargaPublishing)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get interrupted reviews: %v", err)
+	}
+
+	var stuck []PendingCarga
+	for rows.Next() {
+		var pc PendingCarga
+		if err := rows.Scan(&pc.ID, &pc.MessageID, &pc.ChatJID, &pc.CargaIndex, &pc.RealPhone, &pc.SupabaseID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		stuck = append(stuck, pc)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	recovered := 0
+	for _, pc := range stuck {
+		// La condición de estado evita pisar una carga que otra instancia ya resolvió
+		var res sql.Result
+		if pc.SupabaseID != "" {
+			res, err = p.messageStore.db.Exec(`
+				UPDATE pending_cargas
+				SET status = ?, supabase_id = ?, publish_error = NULL, publishing_until = NULL, reviewed_at = NOW()
+				WHERE id = ? AND status = ?
+			`, PendingCargaApproved, pc.SupabaseID, pc.ID, PendingCargaPublishing)
+		} else {
+			res, err = p.messageStore.db.Exec(`
+				UPDATE pending_cargas
+				SET status = ?, publish_error = ?, publishing_until = NULL
+				WHERE id = ? AND status = ?
+			`, PendingCargaNeedsReview, "La publicación se interrumpió antes de terminar; volvé a aprobarla", pc.ID, PendingCargaPublishing)
+		}
+		if err != nil {
+			return recovered, fmt.Errorf("failed to recover pending carga %d: %v", pc.ID, err)
+		}
+		if affected, _ := res.RowsAffected(); affected == 0 {
+			continue
+		}
+
+		recovered++
+		if pc.SupabaseID != "" {
+			p.logger.Infof("👀 Carga en revisión %d ya estaba publicada (%s): se marca como aprobada", pc.ID, pc.SupabaseID)
+			p.finishReview(pc.MessageID, pc.ChatJID, pc.RealPhone, pc.ID)
+		} else {
+			p.logger.Warnf("👀 Carga en revisión %d quedó a mitad de la publicación: vuelve a revisión", pc.ID)
+		}
+	}
+	return recovered, nil
+}
+
+// RejectPendingCarga descarta una carga de la cola de revisión
+func (p *MessageProcessor) RejectPendingCarga(id int, reason string) error {
+	pc, err := p.messageStore.GetPendingCarga(id)
+	if err != nil {
+		return err
+	}
+
+	res, err := p.messageStore.db.Exec(`
+		UPDATE pending_cargas
+		SET status = ?, reject_reason = NULLIF(?, ''), reviewed_at = NOW()
+		WHERE id = ? AND status = ?
+	`, PendingCargaRejected, strings.TrimSpace(reason), id, PendingCargaNeedsReview)
+	if err != nil {
+		return fmt.Errorf("failed to reject pending carga: %v", err)
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return fmt.Errorf("la carga ya no está en revisión")
+	}
+
+	p.logger.Infof("👀 Carga en revisión %d rechazada (mensaje %s)", id, pc.MessageID)
+	p.finishReview(pc.MessageID, pc.ChatJID, pc.RealPhone, id)
+	return nil
+}
+
+// finishReview cierra el resultado del mensaje cuando ya no le quedan cargas en revisión:
+// ai_processing_results pasa a success (con los IDs publicados) o rejected, y si se aprobó
+// al menos una carga el remitente suma confianza.
+func (p *MessageProcessor) finishReview(messageID, chatJID, realPhone string, reviewedID int) {
+	var batchID string
+	if err := p.messageStore.db.QueryRow(`SELECT batch_id FROM pending_cargas WHERE id = ?`, reviewedID).Scan(&batchID); err != nil {
+		p.logger.Warnf("Error leyendo lote de revisión: %v", err)
+		return
+	}
+
+	var remaining int
+	err := p.messageStore.db.QueryRow(`
+		SELECT COUNT(*) FROM pending_cargas WHERE batch_id = ? AND status IN (?, ?)
+	`, batchID, PendingCargaNeedsReview, PendingCargaPublishing).Scan(&remaining)
+	if err != nil || remaining > 0 {
+		return
+	}
+
+	rows, err := p.messageStore.db.Query(`
+		SELECT supabase_id FROM pending_cargas
+		WHERE batch_id = ? AND status = ?
+		ORDER BY carga_index ASC
+	`, batchID, PendingCargaApproved)
+	if err != nil {
+		p.logger.Warnf("Error leyendo cargas aprobadas: %v", err)
+		return
+	}
+	supabaseIDs := []string{}
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			supabaseIDs = append(supabaseIDs, id)
+		}
+	}
+	rows.Close()
+
+	status := "success"
+	errorMessage := fmt.Sprintf("Revisión: %d carga(s) aprobada(s)", len(supabaseIDs))
+	if len(supabaseIDs) == 0 {
+		status = PendingCargaRejected
+		errorMessage = "Revisión: todas las cargas fueron rechazadas"
+	}
+	supabaseIDsJSON, _ := json.Marshal(supabaseIDs)
+
+	_, err = p.messageStore.db.Exec(`
+		UPDATE ai_processing_results
+		SET status = ?, supabase_ids = ?, error_message = ?
+		WHERE message_id = ? AND chat_jid = ? AND status = ?
+		ORDER BY id DESC
+		LIMIT 1
+	`, status, string(supabaseIDsJSON), errorMessage, messageID, chatJID, PendingCargaNeedsReview)
+	if err != nil {
+		p.logger.Warnf("Error actualizando resultado de %s después de la revisión: %v", messageID, err)
+	}
+
+	if len(supabaseIDs) > 0 && realPhone != "" {
+		// La confianza se gana con cargas aprobadas por un operador, no con extracciones sin revisar
+		if err := p.messageStore.UpdatePhoneProfiling(realPhone, true); err != nil {
+			p.logger.Warnf("Error actualizando perfil para %s: %v", realPhone, err)
+		}
+	}
+
+	p.logger.Infof("👀 Revisión del mensaje %s terminada: %s", messageID, errorMessage)
+}
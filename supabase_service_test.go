@@ -0,0 +1,62 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLockUbicacionSerializesAndPrunes(t *testing.T) {
+	s := &SupabaseService{ubicacionLocks: make(map[string]*ubicacionLock)}
+
+	var mu sync.Mutex
+	inside, maxInside := 0, 0
+	var wg sync.WaitGroup
+	for _, direccion := range []string{"Pergamino, Buenos Aires", " pergamino, buenos aires ", "PERGAMINO, BUENOS AIRES"} {
+		wg.Add(1)
+		go func(direccion string) {
+			defer wg.Done()
+			unlock := s.lockUbicacion(direccion)
+			defer unlock()
+
+			mu.Lock()
+			inside++
+			maxInside = max(maxInside, inside)
+			mu.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			mu.Lock()
+			inside--
+			mu.Unlock()
+		}(direccion)
+	}
+	wg.Wait()
+
+	if maxInside != 1 {
+		t.Errorf("la misma dirección tuvo %d workers a la vez, se esperaba 1", maxInside)
+	}
+	if len(s.ubicacionLocks) != 0 {
+		t.Errorf("quedaron %d locks en el mapa después de liberarlos", len(s.ubicacionLocks))
+	}
+}
+
+func TestNominatimTurn(t *testing.T) {
+	s := &SupabaseService{}
+
+	waits := []time.Duration{s.nominatimTurn(), s.nominatimTurn(), s.nominatimTurn()}
+	if waits[0] != 0 {
+		t.Errorf("el primer turno debería ser inmediato, espera %s", waits[0])
+	}
+	for i, want := range []time.Duration{time.Second, 2 * time.Second} {
+		if got := waits[i+1]; got < want-50*time.Millisecond || got > want {
+			t.Errorf("turno %d: espera %s, se esperaba ~%s", i+2, got, want)
+		}
+	}
+
+	// Un turno viejo no se acumula: después de un rato sin requests el próximo vuelve a ser inmediato
+	s.nextNominatim = time.Now().Add(-time.Minute)
+	if wait := s.nominatimTurn(); wait != 0 {
+		t.Errorf("después de un rato sin requests se esperaba turno inmediato, espera %s", wait)
+	}
+}
@@ -3,14 +3,17 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
 // SystemConfigManager maneja las configuraciones generales del sistema
 type SystemConfigManager struct {
-	db *sql.DB
-	mu sync.RWMutex
+	db     *sql.DB
+	mu     sync.RWMutex
+	static map[string]string // Valores fijos cuando no hay BD (eval en modo replay)
 }
 
 // SystemConfig representa una configuración del sistema
@@ -29,11 +32,27 @@ func NewSystemConfigManager(db *sql.DB) *SystemConfigManager {
 	}
 }
 
+// NewStaticSystemConfigManager crea un manejador sin BD que responde con valores fijos
+// (los del seed de migrations/create_ai_config_tables.sql en el eval offline)
+func NewStaticSystemConfigManager(values map[string]string) *SystemConfigManager {
+	return &SystemConfigManager{
+		static: values,
+	}
+}
+
 // GetConfig obtiene una configuración por su key
 func (m *SystemConfigManager) GetConfig(key string) (string, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	
+	if m.db == nil {
+		value, ok := m.static[key]
+		if !ok {
+			return "", fmt.Errorf("configuration key not found: %s", key)
+		}
+		return value, nil
+	}
+	
 	var value string
 	err := m.db.QueryRow(`
 		SELECT config_value 
@@ -134,6 +153,83 @@ func (m *SystemConfigManager) GetSupabaseAPIKey() string {
 	return key
 }
 
+// GetReviewEnabled indica si las cargas pasan por revisión humana antes de publicarse en Supabase
+func (m *SystemConfigManager) GetReviewEnabled() bool {
+	value, err := m.GetConfig("review_enabled")
+	return err == nil && value == "true"
+}
+
+// GetReviewTrustThreshold obtiene la confianza mínima del remitente para publicar sin revisión
+func (m *SystemConfigManager) GetReviewTrustThreshold() int {
+	value, err := m.GetConfig("review_trust_threshold")
+	if err != nil {
+		return defaultReviewTrustThreshold
+	}
+	threshold, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return defaultReviewTrustThreshold
+	}
+	return threshold
+}
+
+// GetCatalogTTL obtiene cada cuánto se vuelven a sincronizar los catálogos de Supabase
+func (m *SystemConfigManager) GetCatalogTTL() time.Duration {
+	hours := defaultCatalogTTLHours
+	if value, err := m.GetConfig("catalog_ttl_hours"); err == nil {
+		if parsed, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && parsed > 0 {
+			hours = parsed
+		}
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// GetCatalogMatchThreshold obtiene la confianza mínima (0 a 1) para aceptar una coincidencia aproximada
+// de catálogo sin mandar la carga a revisión
+func (m *SystemConfigManager) GetCatalogMatchThreshold() float64 {
+	percent := defaultCatalogMatchThreshold
+	if value, err := m.GetConfig("catalog_match_threshold"); err == nil {
+		if parsed, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && parsed > 0 && parsed <= 100 {
+			percent = parsed
+		}
+	}
+	return float64(percent) / 100
+}
+
+// GetDefaultDadorID obtiene el dador de Supabase de las cargas cuyo remitente o grupo no tiene uno asignado
+func (m *SystemConfigManager) GetDefaultDadorID() string {
+	value, err := m.GetConfig("supabase_default_dador_id")
+	if err != nil || strings.TrimSpace(value) == "" {
+		return dadorIDPorDefecto
+	}
+	return strings.TrimSpace(value)
+}
+
+// GetGeocodeNegativeTTL obtiene cuánto se recuerda que una dirección no se pudo geocodificar
+func (m *SystemConfigManager) GetGeocodeNegativeTTL() time.Duration {
+	hours := defaultGeocodeNegativeTTLHours
+	if value, err := m.GetConfig("geocode_negative_ttl_hours"); err == nil {
+		if parsed, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && parsed > 0 {
+			hours = parsed
+		}
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// GetGazetteerValidation indica si las localidades de las cargas se validan contra el nomenclador (desactivado por defecto)
+func (m *SystemConfigManager) GetGazetteerValidation() bool {
+	value, err := m.GetConfig("gazetteer_validation")
+	return err == nil && strings.TrimSpace(value) == "true"
+}
+
+// GetGazetteerPath obtiene el archivo o directorio con los CSV del nomenclador ("" = dataset incluido en la app)
+func (m *SystemConfigManager) GetGazetteerPath() string {
+	value, err := m.GetConfig("gazetteer_path")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(value)
+}
+
 // GetSupabaseURL obtiene la URL de Supabase
 func (m *SystemConfigManager) GetSupabaseURL() string {
 	url, err := m.GetConfig("supabase_url")
@@ -57,7 +57,12 @@ type ChatMessage struct {
 // ProcessableMessage representa un mensaje que puede ser procesado por IA
 type ProcessableMessage struct {
 	ChatMessage
-	RealPhone string `json:"real_phone"` // Teléfono real asociado
+	RealPhone          string     `json:"real_phone"` // Teléfono real asociado
+	ProcessingAttempts int        `json:"processing_attempts"`
+	NextAttemptAt      *time.Time `json:"next_attempt_at"` // Cuándo se reintenta (nil = en el próximo lote)
+	LastError          string     `json:"last_error"`
+	ErrorClass         string     `json:"error_class"` // transient / permanent
+	AIConfigID         int        `json:"ai_config_id"` // Config fijada al reencolar desde la dead-letter queue (0 = la activa)
 }
 
 // Chat representa un chat en la lista
@@ -69,7 +74,9 @@ type Chat struct {
 
 // MessageStore maneja el almacenamiento de mensajes
 type MessageStore struct {
-	db *sql.DB
+	db         *sql.DB
+	instanceID string // Identifica a esta instancia en messages.claimed_by
+	skipLocked bool   // El servidor soporta FOR UPDATE SKIP LOCKED
 }
 
 // NewMessageStore crea una nueva instancia del store de mensajes
@@ -157,6 +164,155 @@ func NewMessageStore() (*MessageStore, error) {
 			INDEX idx_processed_at (processed_at),
 			FOREIGN KEY (message_id, chat_jid) REFERENCES messages(id, chat_jid) ON DELETE CASCADE
 		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+
+		`CREATE TABLE IF NOT EXISTS pending_cargas (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			message_id VARCHAR(255) NOT NULL,
+			chat_jid VARCHAR(255) NOT NULL,
+			batch_id VARCHAR(300) NOT NULL,
+			carga_index INT NOT NULL,
+			carga_json TEXT NOT NULL,
+			original_json TEXT NOT NULL,
+			status VARCHAR(20) NOT NULL,
+			review_reason VARCHAR(500),
+			reject_reason TEXT,
+			supabase_id VARCHAR(100),
+			publish_error TEXT,
+			publishing_until TIMESTAMP NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			reviewed_at TIMESTAMP NULL,
+			INDEX idx_pending_cargas_status (status, created_at),
+			INDEX idx_pending_cargas_message (message_id, chat_jid),
+			INDEX idx_pending_cargas_batch (batch_id),
+			FOREIGN KEY (message_id, chat_jid) REFERENCES messages(id, chat_jid) ON DELETE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+
+		`CREATE TABLE IF NOT EXISTS review_group_rules (
+			chat_jid VARCHAR(255) PRIMARY KEY,
+			mode VARCHAR(10) NOT NULL,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+
+		// Sin FOREIGN KEY: el historial se conserva aunque se borre el mensaje
+		`CREATE TABLE IF NOT EXISTS dead_letter_audit (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			message_id VARCHAR(255) NOT NULL,
+			chat_jid VARCHAR(255) NOT NULL,
+			action VARCHAR(30) NOT NULL,
+			reason TEXT NULL,
+			ai_config_id INT NULL,
+			previous_error TEXT NULL,
+			previous_error_class VARCHAR(20) NULL,
+			previous_attempts INT NOT NULL DEFAULT 0,
+			previous_content TEXT NULL,
+			performed_by VARCHAR(255),
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			INDEX idx_dla_message (message_id, chat_jid),
+			INDEX idx_dla_created_at (created_at)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+
+		`CREATE TABLE IF NOT EXISTS supabase_outbox (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			message_id VARCHAR(255) NOT NULL,
+			chat_jid VARCHAR(255) NOT NULL,
+			real_phone VARCHAR(50),
+			payload MEDIUMTEXT NOT NULL,
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			attempts INT NOT NULL DEFAULT 0,
+			next_attempt_at TIMESTAMP NULL,
+			last_error TEXT NULL,
+			error_class VARCHAR(20) NULL,
+			supabase_ids TEXT NULL,
+			claimed_by VARCHAR(255) NULL,
+			claimed_until TIMESTAMP NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			published_at TIMESTAMP NULL,
+			INDEX idx_outbox_status (status, next_attempt_at),
+			INDEX idx_outbox_message (message_id, chat_jid),
+			FOREIGN KEY (message_id, chat_jid) REFERENCES messages(id, chat_jid) ON DELETE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+
+		// Caché local de los catálogos de Supabase (material, presentación, tipo de equipo, forma de pago)
+		`CREATE TABLE IF NOT EXISTS supabase_catalogs (
+			kind VARCHAR(30) NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			supabase_id VARCHAR(64) NOT NULL,
+			synced_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (kind, name)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+
+		// Direcciones ya geocodificadas (las negativas vencen en expires_at)
+		`CREATE TABLE IF NOT EXISTS geocode_cache (
+			address_key VARCHAR(255) NOT NULL PRIMARY KEY,
+			address VARCHAR(500) NOT NULL,
+			found BOOLEAN NOT NULL,
+			lat DOUBLE,
+			lng DOUBLE,
+			provider VARCHAR(20),
+			formatted_address VARCHAR(500),
+			ubicacion_id VARCHAR(64),
+			last_error VARCHAR(500),
+			hits INT NOT NULL DEFAULT 0,
+			expires_at TIMESTAMP NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			INDEX idx_found_expires (found, expires_at)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+
+		// Nomenclador de Argentina: provincias, departamentos y localidades con su centroide
+		`CREATE TABLE IF NOT EXISTS gazetteer (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			kind VARCHAR(20) NOT NULL,
+			nombre VARCHAR(255) NOT NULL,
+			name_key VARCHAR(255) NOT NULL,
+			departamento VARCHAR(255) NOT NULL DEFAULT '',
+			provincia VARCHAR(255) NOT NULL,
+			provincia_key VARCHAR(255) NOT NULL,
+			lat DOUBLE NOT NULL,
+			lng DOUBLE NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			INDEX idx_name (name_key, kind),
+			INDEX idx_provincia (provincia_key)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+
+		// Dador de Supabase de cada remitente (real_phone) o grupo (chat_jid)
+		`CREATE TABLE IF NOT EXISTS dador_mappings (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			match_type VARCHAR(10) NOT NULL,
+			match_value VARCHAR(255) NOT NULL,
+			dador_id VARCHAR(64) NOT NULL,
+			nombre VARCHAR(255),
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			UNIQUE KEY uk_dador_match (match_type, match_value),
+			INDEX idx_dador_id (dador_id)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+
+		// Sinónimos editables de los valores de catálogo ("bolsones" → "Big Bag")
+		`CREATE TABLE IF NOT EXISTS catalog_synonyms (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			kind VARCHAR(30) NOT NULL,
+			synonym VARCHAR(255) NOT NULL,
+			synonym_key VARCHAR(255) NOT NULL, -- catalogMatchKey(synonym): sin acentos, mayúsculas ni espacios
+			value VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE KEY uk_catalog_synonym (kind, synonym_key)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+
+		// Sin FOREIGN KEY: la carga sigue existiendo en Supabase aunque se borre el mensaje
+		`CREATE TABLE IF NOT EXISTS published_cargas (
+			message_id VARCHAR(255) NOT NULL,
+			chat_jid VARCHAR(255) NOT NULL,
+			carga_index INT NOT NULL,
+			supabase_id VARCHAR(64) NOT NULL,
+			payload_hash CHAR(64) NULL,
+			published_by VARCHAR(255),
+			published_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NULL,
+			PRIMARY KEY (message_id, chat_jid, carga_index),
+			UNIQUE KEY uq_published_supabase_id (supabase_id),
+			INDEX idx_published_at (published_at)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
 	}
 
 	// Crear cada tabla individualmente
@@ -191,7 +347,14 @@ func NewMessageStore() (*MessageStore, error) {
 		return nil, fmt.Errorf("failed to run migrations: %v", err)
 	}
 
-	return &MessageStore{db: db}, nil
+	store := &MessageStore{
+		db:         db,
+		instanceID: newInstanceID(),
+		skipLocked: detectSkipLocked(db),
+	}
+	fmt.Printf("🔒 Instancia %s (SKIP LOCKED: %v)\n", store.instanceID, store.skipLocked)
+
+	return store, nil
 }
 
 // InitAIConfigTables inicializa las tablas de configuración de IA
@@ -276,17 +439,35 @@ func isIndexExistsError(err error) bool {
 func runMigrations(db *sql.DB) error {
 	// Verificar si las columnas ya existen
 	columns := []struct {
+		table      string
 		name       string
 		definition string
 	}{
-		{"processing_attempts", "INT DEFAULT 0"},
-		{"last_processing_error", "TEXT"},
-		{"last_processing_attempt", "TIMESTAMP NULL"},
+		{"messages", "processing_attempts", "INT DEFAULT 0"},
+		{"messages", "last_processing_error", "TEXT"},
+		{"messages", "last_processing_attempt", "TIMESTAMP NULL"},
+		{"ai_processing_results", "failover_chain", "TEXT NULL"},
+		{"ai_processing_results", "validation_errors", "TEXT NULL"},
+		{"ai_processing_results", "catalog_matches", "TEXT NULL"},
+		{"ai_processing_results", "repair_attempts", "INT NOT NULL DEFAULT 0"},
+		{"messages", "repair_attempts", "INT NOT NULL DEFAULT 0"},
+		{"ai_processing_results", "prompt_template_id", "INT NULL"},
+		{"ai_processing_results", "prompt_version", "VARCHAR(100) NULL"},
+		{"messages", "claimed_by", "VARCHAR(255) NULL"},
+		{"messages", "claimed_until", "TIMESTAMP NULL"},
+		{"messages", "next_attempt_at", "TIMESTAMP NULL"},
+		{"messages", "error_class", "VARCHAR(20) NULL"},
+		{"messages", "failed_at", "TIMESTAMP NULL"},
+		{"messages", "ai_config_id", "INT NULL"},
+		{"messages", "discarded_at", "TIMESTAMP NULL"},
+		{"messages", "discard_reason", "TEXT NULL"},
+		{"published_cargas", "payload_hash", "CHAR(64) NULL"},
+		{"published_cargas", "updated_at", "TIMESTAMP NULL"},
 	}
 
 	for _, col := range columns {
 		// Intentar agregar la columna
-		alterQuery := fmt.Sprintf("ALTER TABLE messages ADD COLUMN %s %s", col.name, col.definition)
+		alterQuery := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", col.table, col.name, col.definition)
 		_, err := db.Exec(alterQuery)
 
 		// Ignorar error si la columna ya existe
@@ -295,6 +476,17 @@ func runMigrations(db *sql.DB) error {
 		}
 	}
 
+	// Índices sobre columnas agregadas por migración
+	indices := []string{
+		`CREATE INDEX IF NOT EXISTS idx_messages_claim ON messages(processed, claimed_until)`,
+	}
+
+	for _, query := range indices {
+		if _, err := db.Exec(query); err != nil && !isIndexExistsError(err) {
+			return fmt.Errorf("failed to create index: %v", err)
+		}
+	}
+
 	return nil
 }
 
@@ -477,9 +669,10 @@ func (store *MessageStore) GetMessageByID(messageID, chatJID string) (*Processab
 	query := `
 		SELECT m.id, m.chat_jid, m.sender_phone, m.sender_name, m.content, 
 		       m.timestamp, m.is_from_me, m.media_type, m.filename, m.processed,
-		       pa.real_phone
+		       pa.real_phone, COALESCE(c.name, '') as chat_name, COALESCE(m.ai_config_id, 0)
 		FROM messages m
 		INNER JOIN phone_associations pa ON m.sender_phone = pa.sender_phone
+		LEFT JOIN chats c ON c.jid = m.chat_jid
 		WHERE m.id = ? 
 		  AND m.chat_jid = ?
 		  AND m.content IS NOT NULL 
@@ -493,7 +686,7 @@ func (store *MessageStore) GetMessageByID(messageID, chatJID string) (*Processab
 	err := store.db.QueryRow(query, messageID, chatJID).Scan(
 		&msg.ID, &msg.ChatJID, &msg.SenderPhone, &msg.SenderName, &msg.Content,
 		&msg.Timestamp, &msg.IsFromMe, &msg.MediaType, &msg.Filename, &msg.Processed,
-		&msg.RealPhone,
+		&msg.RealPhone, &msg.ChatName, &msg.AIConfigID,
 	)
 
 	if err == sql.ErrNoRows {
@@ -511,15 +704,17 @@ func (store *MessageStore) GetProcessableMessages(limit int) ([]ProcessableMessa
 	query := `
 		SELECT m.id, m.chat_jid, m.sender_phone, m.sender_name, m.content, 
 		       m.timestamp, m.is_from_me, m.media_type, m.filename, m.processed,
-		       pa.real_phone
+		       pa.real_phone, COALESCE(c.name, '') as chat_name
 		FROM messages m
 		INNER JOIN phone_associations pa ON m.sender_phone = pa.sender_phone
+		LEFT JOIN chats c ON c.jid = m.chat_jid
 		WHERE m.processed = 0 
 		  AND m.content IS NOT NULL 
 		  AND m.content != ''
 		  AND pa.real_phone IS NOT NULL
 		  AND pa.real_phone != ''
-		  AND (m.processing_attempts < 3 OR m.processing_attempts IS NULL)
+		  AND (m.processing_attempts < ? OR m.processing_attempts IS NULL)
+		  AND (m.next_attempt_at IS NULL OR m.next_attempt_at <= NOW())
 		  -- Filtrar mensajes de texto cortos (menos de 20 caracteres) para descongestionar la API
 		  -- Solo aplicar este filtro a mensajes de texto (sin media_type o media_type vacío)
 		  AND (
@@ -531,7 +726,7 @@ func (store *MessageStore) GetProcessableMessages(limit int) ([]ProcessableMessa
 		LIMIT ?
 	`
 
-	rows, err := store.db.Query(query, limit)
+	rows, err := store.db.Query(query, maxProcessingAttempts, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -541,7 +736,7 @@ func (store *MessageStore) GetProcessableMessages(limit int) ([]ProcessableMessa
 	for rows.Next() {
 		var msg ProcessableMessage
 		err := rows.Scan(&msg.ID, &msg.ChatJID, &msg.SenderPhone, &msg.SenderName, &msg.Content,
-			&msg.Timestamp, &msg.IsFromMe, &msg.MediaType, &msg.Filename, &msg.Processed, &msg.RealPhone)
+			&msg.Timestamp, &msg.IsFromMe, &msg.MediaType, &msg.Filename, &msg.Processed, &msg.RealPhone, &msg.ChatName)
 		if err != nil {
 			return nil, err
 		}
@@ -583,7 +778,8 @@ func (store *MessageStore) GetUnprocessedMessagesWithRealPhone(limit int) ([]Pro
 	query := `
 		SELECT m.id, m.chat_jid, m.sender_phone, m.sender_name, m.content, 
 		       m.timestamp, m.is_from_me, m.media_type, m.filename, m.processed,
-		       pa.real_phone, COALESCE(m.processing_attempts, 0) as processing_attempts
+		       pa.real_phone, COALESCE(m.processing_attempts, 0) as processing_attempts,
+		       m.next_attempt_at, COALESCE(m.last_processing_error, ''), COALESCE(m.error_class, '')
 		FROM messages m
 		INNER JOIN phone_associations pa ON m.sender_phone = pa.sender_phone
 		WHERE m.processed = 0 
@@ -611,14 +807,17 @@ func (store *MessageStore) GetUnprocessedMessagesWithRealPhone(limit int) ([]Pro
 	var messages []ProcessableMessage
 	for rows.Next() {
 		var msg ProcessableMessage
-		var attempts int
+		var nextAttemptAt sql.NullTime
 
 		err := rows.Scan(&msg.ID, &msg.ChatJID, &msg.SenderPhone, &msg.SenderName, &msg.Content,
 			&msg.Timestamp, &msg.IsFromMe, &msg.MediaType, &msg.Filename, &msg.Processed,
-			&msg.RealPhone, &attempts)
+			&msg.RealPhone, &msg.ProcessingAttempts, &nextAttemptAt, &msg.LastError, &msg.ErrorClass)
 		if err != nil {
 			return nil, err
 		}
+		if nextAttemptAt.Valid {
+			msg.NextAttemptAt = &nextAttemptAt.Time
+		}
 		messages = append(messages, msg)
 	}
 	return messages, nil
@@ -627,45 +826,106 @@ func (store *MessageStore) GetUnprocessedMessagesWithRealPhone(limit int) ([]Pro
 // MarkMessageAsProcessed marca un mensaje como procesado
 func (store *MessageStore) MarkMessageAsProcessed(messageID, chatJID string) error {
 	_, err := store.db.Exec(
-		`UPDATE messages SET processed = 1 WHERE id = ? AND chat_jid = ?`,
+		`UPDATE messages SET processed = 1, next_attempt_at = NULL WHERE id = ? AND chat_jid = ?`,
 		messageID, chatJID,
 	)
 	return err
 }
 
-// IncrementProcessingAttempt incrementa el contador de intentos de procesamiento
-func (store *MessageStore) IncrementProcessingAttempt(messageID, chatJID, errorMsg string) error {
-	_, err := store.db.Exec(
+// RecordProcessingFailure registra un intento fallido y programa el siguiente.
+// Los errores permanentes pasan directo a fallido; los transitorios se reintentan con backoff exponencial
+// hasta maxProcessingAttempts. Devuelve cuándo se reintenta (nil si el mensaje quedó fallido).
+func (store *MessageStore) RecordProcessingFailure(messageID, chatJID, errorMsg, errorClass string) (*time.Time, error) {
+	var attempts int
+	err := store.db.QueryRow(
+		`SELECT COALESCE(processing_attempts, 0) FROM messages WHERE id = ? AND chat_jid = ?`,
+		messageID, chatJID,
+	).Scan(&attempts)
+	if err != nil {
+		return nil, err
+	}
+	attempts++
+
+	if errorClass == ErrorClassPermanent || attempts >= maxProcessingAttempts {
+		_, err = store.db.Exec(
+			`UPDATE messages 
+			 SET processing_attempts = ?,
+			     last_processing_error = ?,
+			     error_class = ?,
+			     last_processing_attempt = NOW(),
+			     next_attempt_at = NULL
+			 WHERE id = ? AND chat_jid = ?`,
+			attempts, errorMsg, errorClass, messageID, chatJID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		return nil, store.MarkMessageAsFailedAfterRetries(messageID, chatJID)
+	}
+
+	delay := retryBackoff(attempts)
+	_, err = store.db.Exec(
 		`UPDATE messages 
-		 SET processing_attempts = processing_attempts + 1,
+		 SET processing_attempts = ?,
 		     last_processing_error = ?,
-		     last_processing_attempt = NOW()
+		     error_class = ?,
+		     last_processing_attempt = NOW(),
+		     next_attempt_at = NOW() + INTERVAL ? SECOND
 		 WHERE id = ? AND chat_jid = ?`,
-		errorMsg, messageID, chatJID,
+		attempts, errorMsg, errorClass, int(delay.Seconds()), messageID, chatJID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	nextAttemptAt := time.Now().Add(delay)
+	return &nextAttemptAt, nil
+}
+
+// AddRepairAttempts suma los intentos de auto-reparación de JSON de un mensaje.
+// Se cuentan aparte de processing_attempts para no agotar los reintentos por errores de transporte
+func (store *MessageStore) AddRepairAttempts(messageID, chatJID string, count int) error {
+	_, err := store.db.Exec(
+		`UPDATE messages 
+		 SET repair_attempts = repair_attempts + ?
+		 WHERE id = ? AND chat_jid = ?`,
+		count, messageID, chatJID,
 	)
 	return err
 }
 
-// MarkMessageAsFailedAfterRetries marca un mensaje como procesado después de múltiples fallos
+// MarkMessageAsFailedAfterRetries marca un mensaje como fallido (sale de la cola, se conserva el último error)
 func (store *MessageStore) MarkMessageAsFailedAfterRetries(messageID, chatJID string) error {
 	_, err := store.db.Exec(
 		`UPDATE messages 
 		 SET processed = 1,
-		     last_processing_error = 'Demasiados intentos fallidos'
+		     failed_at = NOW(),
+		     next_attempt_at = NULL,
+		     last_processing_error = COALESCE(last_processing_error, 'Demasiados intentos fallidos')
 		 WHERE id = ? AND chat_jid = ?`,
 		messageID, chatJID,
 	)
 	return err
 }
 
-// ResetProcessingAttempts resetea el contador de intentos para reprocesar un mensaje
+// resetProcessingColumns es el SET que devuelve un mensaje a la cola como si nunca se hubiera procesado
+// (lo usan el reprocesamiento manual, la edición y el reencolado desde la dead-letter queue)
+const resetProcessingColumns = `processing_attempts = 0,
+		     repair_attempts = 0,
+		     processed = 0,
+		     last_processing_error = NULL,
+		     last_processing_attempt = NULL,
+		     next_attempt_at = NULL,
+		     error_class = NULL,
+		     failed_at = NULL,
+		     discarded_at = NULL`
+
+// ResetProcessingAttempts resetea el contador de intentos para reprocesar un mensaje (con la config activa)
 func (store *MessageStore) ResetProcessingAttempts(messageID, chatJID string) error {
 	_, err := store.db.Exec(
 		`UPDATE messages 
-		 SET processing_attempts = 0,
-		     processed = 0,
-		     last_processing_error = NULL,
-		     last_processing_attempt = NULL
+		 SET `+resetProcessingColumns+`,
+		     ai_config_id = NULL
 		 WHERE id = ? AND chat_jid = ?`,
 		messageID, chatJID,
 	)
@@ -695,9 +955,7 @@ func (store *MessageStore) UpdateMessageContent(messageID, chatJID, newContent s
 	_, err := store.db.Exec(
 		`UPDATE messages 
 		 SET content = ?,
-		     processing_attempts = 0,
-		     processed = 0,
-		     last_processing_error = NULL
+		     `+resetProcessingColumns+`
 		 WHERE id = ? AND chat_jid = ?`,
 		newContent, messageID, chatJID,
 	)
@@ -1412,7 +1670,7 @@ func (s *WhatsAppService) StartAutoProcessor() {
 
 		for range ticker.C {
 			// Procesar hasta 10 mensajes cada 5 minutos
-			results, err := s.messageProcessor.ProcessPendingMessages(10)
+			results, err := s.messageProcessor.ProcessPendingMessages(context.Background(), 10)
 			if err != nil {
 				s.logger.Errorf("Error en procesamiento automático: %v", err)
 				continue
@@ -1420,18 +1678,25 @@ func (s *WhatsAppService) StartAutoProcessor() {
 
 			if len(results) > 0 {
 				successCount := 0
+				outboxCount := 0
+				reviewCount := 0
 				errorCount := 0
 
 				for _, result := range results {
-					if result.Status == "success" {
+					switch result.Status {
+					case "success":
 						successCount++
-					} else {
+					case ProcessingStatusExtracted:
+						outboxCount++
+					case PendingCargaNeedsReview:
+						reviewCount++
+					default:
 						errorCount++
 					}
 				}
 
-				s.logger.Infof("🤖 Procesamiento automático completado: %d exitosos, %d errores",
-					successCount, errorCount)
+				s.logger.Infof("🤖 Procesamiento automático completado: %d exitosos, %d por publicar, %d en revisión, %d errores",
+					successCount, outboxCount, reviewCount, errorCount)
 			}
 		}
 	}()